@@ -0,0 +1,18 @@
+-- migrate:up
+-- Aggregates written before incident types were recorded can't be attributed
+-- to a type, and under a placeholder type could neither be queried nor
+-- replaced by upserts, so they are deleted. Reconciliation over the full
+-- history rewrites them with their types.
+truncate aggregate_buckets;
+alter table aggregate_buckets add column incident_type varchar(32) not null;
+
+-- The index created by 01-init.sql was named by Postgres.
+drop index if exists aggregate_buckets_occurred_at_geo_id_idx;
+create index aggregate_buckets_time_geo_type_idx on aggregate_buckets (occurred_at, geo_id, incident_type) include (incident_count);
+
+
+-- migrate:down
+drop index aggregate_buckets_time_geo_type_idx;
+create index on aggregate_buckets (occurred_at, geo_id) include (incident_count);
+
+alter table aggregate_buckets drop column incident_type;
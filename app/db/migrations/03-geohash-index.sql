@@ -0,0 +1,12 @@
+-- migrate:up
+-- Use byte-wise ordering so that geohash prefixes can be matched with range
+-- scans.
+alter table aggregate_buckets alter column geo_id type varchar(12) collate "C";
+
+create index aggregate_buckets_geo_time_idx on aggregate_buckets (geo_id, occurred_at) include (incident_type, incident_count);
+
+
+-- migrate:down
+drop index aggregate_buckets_geo_time_idx;
+
+alter table aggregate_buckets alter column geo_id type varchar(12) collate "default";
@@ -0,0 +1,88 @@
+-- migrate:up
+-- Functions are single SQL expressions, so that the planner inlines them into
+-- queries rather than calling them per row. Each is only passed columns and
+-- query parameters, as a function isn't inlined if an argument which is used
+-- more than once is expensive to compute. They are stable, rather than
+-- immutable, as timezone rules can change.
+
+-- Convert a timestamp, in UTC, to the wall clock time in the `tz` timezone.
+create function bucket_time_wall_clock(ts timestamp, tz text)
+returns timestamp
+language sql
+stable parallel safe
+as $$
+    select (ts at time zone 'UTC') at time zone tz
+$$;
+
+-- Round the wall clock time of a timestamp up to the end of its time bucket.
+create function bucket_time_wall_clock_end(ts timestamp, bin_width interval, calendar_unit text, tz text)
+returns timestamp
+language sql
+stable parallel safe
+as $$
+    select case
+        when calendar_unit is null then
+            date_bin(bin_width, bucket_time_wall_clock(ts, tz) - interval '1 microsecond', timestamp '2000-01-01')
+                + bin_width
+        else
+            date_trunc(calendar_unit, bucket_time_wall_clock(ts, tz) - interval '1 microsecond')
+                + case calendar_unit
+                    when 'quarter' then interval '3 months'
+                    else ('1 ' || calendar_unit)::interval
+                end
+    end
+$$;
+
+-- End of a timestamp's time bucket, in UTC, assuming the timestamp's UTC offset
+-- holds until then.
+create function bucket_time_naive(ts timestamp, bin_width interval, calendar_unit text, tz text)
+returns timestamp
+language sql
+stable parallel safe
+as $$
+    select bucket_time_wall_clock_end(ts, bin_width, calendar_unit, tz) - (bucket_time_wall_clock(ts, tz) - ts)
+$$;
+
+-- UTC offset at the naive end of a timestamp's time bucket.
+create function bucket_time_naive_offset(ts timestamp, bin_width interval, calendar_unit text, tz text)
+returns interval
+language sql
+stable parallel safe
+as $$
+    select ((bucket_time_naive(ts, bin_width, calendar_unit, tz) at time zone 'UTC') at time zone tz)
+        - bucket_time_naive(ts, bin_width, calendar_unit, tz)
+$$;
+
+-- Round a timestamp, in UTC, up to the end of its time bucket, such that the
+-- timestamp occurred no later than the returned timestamp. Buckets are aligned
+-- to the wall clock in the `tz` timezone, and are either `bin_width` wide or,
+-- if given, calendar periods of `calendar_unit` (week, month, quarter or
+-- year). Mirrors `BucketTime` in the app.
+--
+-- If a daylight saving time transition occurs within a fixed-width bucket, the
+-- bucket is aligned to the wall clock after the transition, unless that wall
+-- clock time was skipped over.
+create function bucket_time(ts timestamp, bin_width interval, calendar_unit text, tz text)
+returns timestamp
+language sql
+stable parallel safe
+as $$
+    select case
+        when calendar_unit is not null then
+            (bucket_time_wall_clock_end(ts, bin_width, calendar_unit, tz) at time zone tz) at time zone 'UTC'
+        when bucket_time_wall_clock_end(ts, bin_width, calendar_unit, tz)
+            - bucket_time_naive_offset(ts, bin_width, calendar_unit, tz) >= ts then
+            bucket_time_wall_clock_end(ts, bin_width, calendar_unit, tz)
+                - bucket_time_naive_offset(ts, bin_width, calendar_unit, tz)
+        else
+            bucket_time_naive(ts, bin_width, calendar_unit, tz)
+    end
+$$;
+
+
+-- migrate:down
+drop function bucket_time;
+drop function bucket_time_naive_offset;
+drop function bucket_time_naive;
+drop function bucket_time_wall_clock_end;
+drop function bucket_time_wall_clock;
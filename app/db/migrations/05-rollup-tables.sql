@@ -0,0 +1,47 @@
+-- migrate:up
+-- Aggregates pre-aggregated to coarser precisions, which are kept up to date by
+-- the app as aggregates are written. Precisions must match `RollupTables` in
+-- the app.
+create table aggregate_buckets_hourly (
+    occurred_at timestamp not null,
+    geo_id varchar(5) collate "C" not null,
+    incident_type varchar(32) not null,
+    incident_count int not null,
+    primary key (occurred_at, geo_id, incident_type) include (incident_count)
+);
+
+create index on aggregate_buckets_hourly (geo_id, occurred_at) include (incident_type, incident_count);
+
+create table aggregate_buckets_daily (
+    occurred_at timestamp not null,
+    geo_id varchar(4) collate "C" not null,
+    incident_type varchar(32) not null,
+    incident_count int not null,
+    primary key (occurred_at, geo_id, incident_type) include (incident_count)
+);
+
+create index on aggregate_buckets_daily (geo_id, occurred_at) include (incident_type, incident_count);
+
+insert into aggregate_buckets_hourly (occurred_at, geo_id, incident_type, incident_count)
+select
+    bucket_time(occurred_at, interval '1 hour', null, 'UTC'),
+    left(geo_id, 5),
+    incident_type,
+    sum(incident_count)
+from aggregate_buckets
+group by 1, 2, 3;
+
+insert into aggregate_buckets_daily (occurred_at, geo_id, incident_type, incident_count)
+select
+    bucket_time(occurred_at, interval '24 hours', null, 'America/Los_Angeles'),
+    left(geo_id, 4),
+    incident_type,
+    sum(incident_count)
+from aggregate_buckets
+group by 1, 2, 3;
+
+
+-- migrate:down
+drop table aggregate_buckets_daily;
+
+drop table aggregate_buckets_hourly;
@@ -0,0 +1,184 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.5
+// 	protoc        (unknown)
+// source: aggregates.proto
+
+package main
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Aggregates are rolled up incident counts, stored by column. The i-th
+// aggregate is made up of the i-th element of each of `occurred_at`,
+// `geohash_index`, `incident_type_index` and `count`, which are all of the same
+// length.
+type Aggregates struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Distinct geohashes and incident types, referred to by index.
+	Geohashes     []string `protobuf:"bytes,1,rep,name=geohashes,proto3" json:"geohashes,omitempty"`
+	IncidentTypes []string `protobuf:"bytes,2,rep,name=incident_types,json=incidentTypes,proto3" json:"incident_types,omitempty"`
+	// End of each aggregate's time bucket, in seconds since the Unix epoch.
+	OccurredAt        []int64  `protobuf:"varint,3,rep,packed,name=occurred_at,json=occurredAt,proto3" json:"occurred_at,omitempty"`
+	GeohashIndex      []uint32 `protobuf:"varint,4,rep,packed,name=geohash_index,json=geohashIndex,proto3" json:"geohash_index,omitempty"`
+	IncidentTypeIndex []uint32 `protobuf:"varint,5,rep,packed,name=incident_type_index,json=incidentTypeIndex,proto3" json:"incident_type_index,omitempty"`
+	Count             []int32  `protobuf:"varint,6,rep,packed,name=count,proto3" json:"count,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *Aggregates) Reset() {
+	*x = Aggregates{}
+	mi := &file_aggregates_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Aggregates) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Aggregates) ProtoMessage() {}
+
+func (x *Aggregates) ProtoReflect() protoreflect.Message {
+	mi := &file_aggregates_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Aggregates.ProtoReflect.Descriptor instead.
+func (*Aggregates) Descriptor() ([]byte, []int) {
+	return file_aggregates_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Aggregates) GetGeohashes() []string {
+	if x != nil {
+		return x.Geohashes
+	}
+	return nil
+}
+
+func (x *Aggregates) GetIncidentTypes() []string {
+	if x != nil {
+		return x.IncidentTypes
+	}
+	return nil
+}
+
+func (x *Aggregates) GetOccurredAt() []int64 {
+	if x != nil {
+		return x.OccurredAt
+	}
+	return nil
+}
+
+func (x *Aggregates) GetGeohashIndex() []uint32 {
+	if x != nil {
+		return x.GeohashIndex
+	}
+	return nil
+}
+
+func (x *Aggregates) GetIncidentTypeIndex() []uint32 {
+	if x != nil {
+		return x.IncidentTypeIndex
+	}
+	return nil
+}
+
+func (x *Aggregates) GetCount() []int32 {
+	if x != nil {
+		return x.Count
+	}
+	return nil
+}
+
+var File_aggregates_proto protoreflect.FileDescriptor
+
+var file_aggregates_proto_rawDesc = string([]byte{
+	0x0a, 0x10, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x65, 0x73, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x12, 0x08, 0x68, 0x6f, 0x74, 0x73, 0x70, 0x6f, 0x74, 0x73, 0x22, 0xdd, 0x01, 0x0a,
+	0x0a, 0x41, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x65, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x67,
+	0x65, 0x6f, 0x68, 0x61, 0x73, 0x68, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x09,
+	0x67, 0x65, 0x6f, 0x68, 0x61, 0x73, 0x68, 0x65, 0x73, 0x12, 0x25, 0x0a, 0x0e, 0x69, 0x6e, 0x63,
+	0x69, 0x64, 0x65, 0x6e, 0x74, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28,
+	0x09, 0x52, 0x0d, 0x69, 0x6e, 0x63, 0x69, 0x64, 0x65, 0x6e, 0x74, 0x54, 0x79, 0x70, 0x65, 0x73,
+	0x12, 0x1f, 0x0a, 0x0b, 0x6f, 0x63, 0x63, 0x75, 0x72, 0x72, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18,
+	0x03, 0x20, 0x03, 0x28, 0x03, 0x52, 0x0a, 0x6f, 0x63, 0x63, 0x75, 0x72, 0x72, 0x65, 0x64, 0x41,
+	0x74, 0x12, 0x23, 0x0a, 0x0d, 0x67, 0x65, 0x6f, 0x68, 0x61, 0x73, 0x68, 0x5f, 0x69, 0x6e, 0x64,
+	0x65, 0x78, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0d, 0x52, 0x0c, 0x67, 0x65, 0x6f, 0x68, 0x61, 0x73,
+	0x68, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x2e, 0x0a, 0x13, 0x69, 0x6e, 0x63, 0x69, 0x64, 0x65,
+	0x6e, 0x74, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x5f, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x05, 0x20,
+	0x03, 0x28, 0x0d, 0x52, 0x11, 0x69, 0x6e, 0x63, 0x69, 0x64, 0x65, 0x6e, 0x74, 0x54, 0x79, 0x70,
+	0x65, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18,
+	0x06, 0x20, 0x03, 0x28, 0x05, 0x52, 0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x42, 0x28, 0x5a, 0x26,
+	0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x64, 0x73, 0x6c, 0x61, 0x77,
+	0x2f, 0x68, 0x6f, 0x74, 0x73, 0x70, 0x6f, 0x74, 0x73, 0x2f, 0x61, 0x70, 0x70, 0x2f, 0x73, 0x72,
+	0x63, 0x3b, 0x6d, 0x61, 0x69, 0x6e, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+})
+
+var (
+	file_aggregates_proto_rawDescOnce sync.Once
+	file_aggregates_proto_rawDescData []byte
+)
+
+func file_aggregates_proto_rawDescGZIP() []byte {
+	file_aggregates_proto_rawDescOnce.Do(func() {
+		file_aggregates_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_aggregates_proto_rawDesc), len(file_aggregates_proto_rawDesc)))
+	})
+	return file_aggregates_proto_rawDescData
+}
+
+var file_aggregates_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_aggregates_proto_goTypes = []any{
+	(*Aggregates)(nil), // 0: hotspots.Aggregates
+}
+var file_aggregates_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_aggregates_proto_init() }
+func file_aggregates_proto_init() {
+	if File_aggregates_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_aggregates_proto_rawDesc), len(file_aggregates_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_aggregates_proto_goTypes,
+		DependencyIndexes: file_aggregates_proto_depIdxs,
+		MessageInfos:      file_aggregates_proto_msgTypes,
+	}.Build()
+	File_aggregates_proto = out.File
+	file_aggregates_proto_goTypes = nil
+	file_aggregates_proto_depIdxs = nil
+}
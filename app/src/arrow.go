@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+var arrowSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "occurred_at", Type: &arrow.TimestampType{Unit: arrow.Second, TimeZone: "UTC"}},
+	{Name: "geohash", Type: &arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Uint32, ValueType: arrow.BinaryTypes.String}},
+	{Name: "incident_type", Type: &arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Uint32, ValueType: arrow.BinaryTypes.String}},
+	{Name: "count", Type: arrow.PrimitiveTypes.Int32},
+}, nil)
+
+// EncodeAggregatesArrow writes the aggregates as a single record batch in the
+// Arrow IPC streaming format. Geohashes and incident types are dictionary
+// encoded.
+func EncodeAggregatesArrow(records []Aggregate, w io.Writer) error {
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, arrowSchema)
+	defer builder.Release()
+
+	occurredAt := builder.Field(0).(*array.TimestampBuilder)
+	geohashes := builder.Field(1).(*array.BinaryDictionaryBuilder)
+	incidentTypes := builder.Field(2).(*array.BinaryDictionaryBuilder)
+	counts := builder.Field(3).(*array.Int32Builder)
+
+	for _, record := range records {
+		occurredAt.Append(arrow.Timestamp(record.OccurredAt.Unix()))
+		if err := geohashes.AppendString(record.Geohash); err != nil {
+			return err
+		}
+		if err := incidentTypes.AppendString(record.IncidentType); err != nil {
+			return err
+		}
+		counts.Append(record.Count)
+	}
+
+	batch := builder.NewRecord()
+	defer batch.Release()
+
+	writer := ipc.NewWriter(w, ipc.WithSchema(arrowSchema))
+	if err := writer.Write(batch); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeAggregatesArrow(t *testing.T) {
+	records := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcdefh", IncidentType: IncidentTypePoliceIncident, Count: 2},
+	}
+
+	var buff bytes.Buffer
+	err := EncodeAggregatesArrow(records, &buff)
+	require.Nil(t, err)
+
+	reader, err := ipc.NewReader(&buff)
+	require.Nil(t, err)
+	defer reader.Release()
+
+	require.True(t, reader.Next())
+	batch := reader.Record()
+	require.Equal(t, int64(2), batch.NumRows())
+
+	occurredAt := batch.Column(0).(*array.Timestamp)
+	geohashes := batch.Column(1).(*array.Dictionary)
+	counts := batch.Column(3).(*array.Int32)
+	for idx, record := range records {
+		assert.Equal(t, arrow.Timestamp(record.OccurredAt.Unix()), occurredAt.Value(idx))
+		assert.Equal(t, record.Geohash, geohashes.Dictionary().(*array.String).Value(geohashes.GetValueIndex(idx)))
+		assert.Equal(t, record.Count, counts.Value(idx))
+	}
+	assert.False(t, reader.Next())
+}
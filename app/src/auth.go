@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AuthMethodAPIKey = "api_key"
+	AuthMethodHMAC   = "hmac"
+)
+
+// Signed requests are rejected if their timestamp differs from the current
+// time by more than this, to limit replays.
+const MaxSignatureAge = 5 * time.Minute
+
+// Header holding the Unix time, in seconds, at which a request was signed.
+const timestampHeader = "X-Auth-Timestamp"
+
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"
+	ScopeWrite Scope = "write"
+)
+
+var (
+	ErrInvalidAuthMethod = errors.New("Invalid auth method")
+	ErrInvalidAPIKeys    = errors.New("Invalid API keys")
+	ErrUnauthenticated   = errors.New("Request is not authenticated")
+)
+
+// APIKey is a client's credentials. For API key auth, the secret is sent as a
+// bearer token, and for HMAC auth, it is used to sign requests.
+type APIKey struct {
+	ID     string
+	Secret string
+	Scopes []Scope
+}
+
+func (k APIKey) HasScope(scope Scope) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// ParseAPIKeys parses keys given as `<id>:<secret>:<scopes>`, separated by
+// semicolons, where scopes are separated by commas.
+func ParseAPIKeys(s string) ([]APIKey, error) {
+	keys := []APIKey{}
+	ids := make(map[string]bool)
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		fields := strings.Split(entry, ":")
+		if len(fields) != 3 || fields[0] == "" || fields[1] == "" || ids[fields[0]] {
+			return nil, ErrInvalidAPIKeys
+		}
+		ids[fields[0]] = true
+
+		key := APIKey{ID: fields[0], Secret: fields[1], Scopes: []Scope{}}
+		for _, scope := range strings.Split(fields[2], ",") {
+			scope := Scope(strings.TrimSpace(scope))
+			if scope != ScopeRead && scope != ScopeWrite {
+				return nil, ErrInvalidAPIKeys
+			}
+			key.Scopes = append(key.Scopes, scope)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Authenticator identifies the key a request was made with, returning
+// ErrUnauthenticated if the request's credentials are missing or invalid.
+type Authenticator interface {
+	Authenticate(*http.Request) (APIKey, error)
+}
+
+// NewAuthenticator creates an authenticator for the method. Signed request
+// bodies larger than maxBodyBytes are not read.
+func NewAuthenticator(method string, keys []APIKey, maxBodyBytes int64) (Authenticator, error) {
+	switch method {
+	case AuthMethodAPIKey:
+		return &APIKeyAuthenticator{keys: keys}, nil
+	case AuthMethodHMAC:
+		return NewHMACAuthenticator(keys, maxBodyBytes, time.Now), nil
+	default:
+		return nil, ErrInvalidAuthMethod
+	}
+}
+
+// APIKeyAuthenticator authenticates requests with an `Authorization: Bearer
+// <secret>` header.
+type APIKeyAuthenticator struct {
+	keys []APIKey
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (APIKey, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return APIKey{}, ErrUnauthenticated
+	}
+
+	for _, key := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key.Secret)) == 1 {
+			return key, nil
+		}
+	}
+	return APIKey{}, ErrUnauthenticated
+}
+
+// HMACAuthenticator authenticates requests signed with an `Authorization: HMAC
+// <key id>:<signature>` header and the X-Auth-Timestamp header. See
+// SignatureString for what is signed. As the body is read to verify it, bodies
+// larger than MaxBodyBytes are rejected with an *http.MaxBytesError.
+type HMACAuthenticator struct {
+	MaxBodyBytes int64
+	keys         map[string]APIKey
+	now          func() time.Time
+}
+
+func NewHMACAuthenticator(keys []APIKey, maxBodyBytes int64, now func() time.Time) *HMACAuthenticator {
+	keysByID := make(map[string]APIKey, len(keys))
+	for _, key := range keys {
+		keysByID[key.ID] = key
+	}
+	return &HMACAuthenticator{MaxBodyBytes: maxBodyBytes, keys: keysByID, now: now}
+}
+
+// SignatureString returns the string which is signed: the request method,
+// request URI, timestamp and hex encoded SHA-256 digest of the body, each on
+// their own line.
+func SignatureString(method, requestURI, timestamp string, body []byte) string {
+	digest := sha256.Sum256(body)
+	return strings.Join([]string{method, requestURI, timestamp, hex.EncodeToString(digest[:])}, "\n")
+}
+
+// Sign returns the hex encoded HMAC-SHA256 signature of the signature string.
+func Sign(secret, signatureString string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signatureString))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *HMACAuthenticator) Authenticate(r *http.Request) (APIKey, error) {
+	credentials, ok := strings.CutPrefix(r.Header.Get("Authorization"), "HMAC ")
+	if !ok {
+		return APIKey{}, ErrUnauthenticated
+	}
+
+	id, signature, ok := strings.Cut(credentials, ":")
+	if !ok {
+		return APIKey{}, ErrUnauthenticated
+	}
+
+	key, ok := a.keys[id]
+	if !ok {
+		return APIKey{}, ErrUnauthenticated
+	}
+
+	timestamp := r.Header.Get(timestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return APIKey{}, ErrUnauthenticated
+	}
+	age := a.now().Sub(time.Unix(seconds, 0))
+	if age > MaxSignatureAge || age < -MaxSignatureAge {
+		return APIKey{}, ErrUnauthenticated
+	}
+
+	// Read the body to verify it, and replace it so that it can be read again
+	// by the handler.
+	body := []byte{}
+	if r.Body != nil {
+		body, err = io.ReadAll(http.MaxBytesReader(nil, r.Body, a.MaxBodyBytes))
+		if err != nil {
+			return APIKey{}, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	expected := Sign(key.Secret, SignatureString(r.Method, r.URL.RequestURI(), timestamp, body))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return APIKey{}, ErrUnauthenticated
+	}
+	return key, nil
+}
+
+type apiKeyContextKey struct{}
+
+// APIKeyFromContext returns the key the request was authenticated with.
+func APIKeyFromContext(ctx context.Context) (APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(APIKey)
+	return key, ok
+}
+
+// RequireScope wraps the handler, such that only requests authenticated with
+// a key having the given scope are handled. Requests are otherwise rejected
+// with a 401, if unauthenticated, a 413, if too large to authenticate, or a
+// 403. The key is added to the request's context.
+func RequireScope(auth Authenticator, scope Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := auth.Authenticate(r)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`%s realm="hotspots"`, authScheme(auth)))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if !key.HasScope(scope) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+func authScheme(auth Authenticator) string {
+	if _, ok := auth.(*HMACAuthenticator); ok {
+		return "HMAC"
+	}
+	return "Bearer"
+}
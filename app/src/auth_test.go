@@ -0,0 +1,218 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testAPIKeys = []APIKey{
+	{ID: "reader", Secret: "reader-secret", Scopes: []Scope{ScopeRead}},
+	{ID: "writer", Secret: "writer-secret", Scopes: []Scope{ScopeRead, ScopeWrite}},
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	actual, err := ParseAPIKeys("reader:reader-secret:read; writer:writer-secret:read,write;")
+	assert.Nil(t, err)
+	assert.Equal(t, testAPIKeys, actual)
+}
+
+func TestParseAPIKeysWhenEmpty(t *testing.T) {
+	actual, err := ParseAPIKeys("")
+	assert.Nil(t, err)
+	assert.Empty(t, actual)
+}
+
+func TestParseAPIKeysWhenInvalid(t *testing.T) {
+	values := []string{
+		"reader:reader-secret",
+		":reader-secret:read",
+		"reader::read",
+		"reader:reader-secret:admin",
+		"reader:reader-secret:read;reader:other-secret:read",
+	}
+
+	for _, value := range values {
+		t.Run(value, func(t *testing.T) {
+			_, err := ParseAPIKeys(value)
+			assert.ErrorIs(t, err, ErrInvalidAPIKeys)
+		})
+	}
+}
+
+func TestNewAuthenticatorWhenInvalidMethod(t *testing.T) {
+	_, err := NewAuthenticator("basic", testAPIKeys, 1<<20)
+	assert.ErrorIs(t, err, ErrInvalidAuthMethod)
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	type testCase struct {
+		Name          string
+		Authorization string
+		ExpectedID    string
+		ExpectedErr   error
+	}
+
+	testCases := []testCase{
+		{Name: "Valid key", Authorization: "Bearer writer-secret", ExpectedID: "writer"},
+		{Name: "Invalid key", Authorization: "Bearer other-secret", ExpectedErr: ErrUnauthenticated},
+		{Name: "Wrong scheme", Authorization: "Basic writer-secret", ExpectedErr: ErrUnauthenticated},
+		{Name: "Missing", ExpectedErr: ErrUnauthenticated},
+	}
+
+	auth, _ := NewAuthenticator(AuthMethodAPIKey, testAPIKeys, 1<<20)
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/aggregates", nil)
+			if tc.Authorization != "" {
+				req.Header.Set("Authorization", tc.Authorization)
+			}
+
+			key, err := auth.Authenticate(req)
+			assert.ErrorIs(t, err, tc.ExpectedErr)
+			assert.Equal(t, tc.ExpectedID, key.ID)
+		})
+	}
+}
+
+func makeSignedRequest(method, target, body, id, secret string, signedAt time.Time) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	signature := Sign(secret, SignatureString(method, req.URL.RequestURI(), timestamp, []byte(body)))
+	req.Header.Set("Authorization", "HMAC "+id+":"+signature)
+	req.Header.Set(timestampHeader, timestamp)
+	return req
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	body := `[{"occurred_at": "2025-01-01T12:00:00Z"}]`
+
+	t.Run("Valid signature", func(t *testing.T) {
+		auth := NewHMACAuthenticator(testAPIKeys, 1<<20, func() time.Time { return now })
+		req := makeSignedRequest("POST", "/aggregates", body, "writer", "writer-secret", now.Add(-time.Minute))
+
+		key, err := auth.Authenticate(req)
+		assert.Nil(t, err)
+		assert.Equal(t, "writer", key.ID)
+
+		// The body can still be read by the handler.
+		actual, _ := io.ReadAll(req.Body)
+		assert.Equal(t, body, string(actual))
+	})
+
+	type testCase struct {
+		Name    string
+		Request func() *http.Request
+	}
+
+	testCases := []testCase{
+		{
+			Name: "Wrong secret",
+			Request: func() *http.Request {
+				return makeSignedRequest("POST", "/aggregates", body, "writer", "other-secret", now)
+			},
+		},
+		{
+			Name: "Unknown key",
+			Request: func() *http.Request {
+				return makeSignedRequest("POST", "/aggregates", body, "other", "writer-secret", now)
+			},
+		},
+		{
+			Name: "Tampered body",
+			Request: func() *http.Request {
+				req := makeSignedRequest("POST", "/aggregates", body, "writer", "writer-secret", now)
+				req.Body = io.NopCloser(strings.NewReader("[]"))
+				return req
+			},
+		},
+		{
+			Name: "Tampered query",
+			Request: func() *http.Request {
+				req := makeSignedRequest("GET", "/aggregates?geo_precision=5", "", "writer", "writer-secret", now)
+				req.URL.RawQuery = "geo_precision=7"
+				return req
+			},
+		},
+		{
+			Name: "Expired signature",
+			Request: func() *http.Request {
+				return makeSignedRequest("POST", "/aggregates", body, "writer", "writer-secret", now.Add(-MaxSignatureAge-time.Second))
+			},
+		},
+		{
+			Name: "Missing timestamp",
+			Request: func() *http.Request {
+				req := makeSignedRequest("POST", "/aggregates", body, "writer", "writer-secret", now)
+				req.Header.Del(timestampHeader)
+				return req
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			auth := NewHMACAuthenticator(testAPIKeys, 1<<20, func() time.Time { return now })
+			_, err := auth.Authenticate(tc.Request())
+			assert.ErrorIs(t, err, ErrUnauthenticated)
+		})
+	}
+}
+
+func TestHMACAuthenticatorWhenBodyTooLarge(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	auth := NewHMACAuthenticator(testAPIKeys, 8, func() time.Time { return now })
+	req := makeSignedRequest("POST", "/aggregates", `[{"occurred_at": "2025-01-01T12:00:00Z"}]`, "writer", "writer-secret", now)
+
+	_, err := auth.Authenticate(req)
+	var tooLarge *http.MaxBytesError
+	assert.ErrorAs(t, err, &tooLarge)
+
+	w := httptest.NewRecorder()
+	req = makeSignedRequest("POST", "/aggregates", `[{"occurred_at": "2025-01-01T12:00:00Z"}]`, "writer", "writer-secret", now)
+	RequireScope(auth, ScopeWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestRequireScope(t *testing.T) {
+	type testCase struct {
+		Name           string
+		Authorization  string
+		Scope          Scope
+		ExpectedStatus int
+	}
+
+	testCases := []testCase{
+		{Name: "Read with read scope", Authorization: "Bearer reader-secret", Scope: ScopeRead, ExpectedStatus: http.StatusOK},
+		{Name: "Write with write scope", Authorization: "Bearer writer-secret", Scope: ScopeWrite, ExpectedStatus: http.StatusOK},
+		{Name: "Write without write scope", Authorization: "Bearer reader-secret", Scope: ScopeWrite, ExpectedStatus: http.StatusForbidden},
+		{Name: "Unauthenticated", Scope: ScopeRead, ExpectedStatus: http.StatusUnauthorized},
+	}
+
+	auth, _ := NewAuthenticator(AuthMethodAPIKey, testAPIKeys, 1<<20)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/aggregates", nil)
+			if tc.Authorization != "" {
+				req.Header.Set("Authorization", tc.Authorization)
+			}
+			w := httptest.NewRecorder()
+
+			RequireScope(auth, tc.Scope, next).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.ExpectedStatus, w.Code)
+			if tc.ExpectedStatus == http.StatusUnauthorized {
+				assert.Equal(t, `Bearer realm="hotspots"`, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
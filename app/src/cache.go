@@ -1,59 +1,517 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"google.golang.org/protobuf/proto"
 )
 
-var ErrNoSuchKey = errors.New("No such key exists")
+var (
+	ErrNoSuchKey      = errors.New("No such key exists")
+	ErrStaleKey       = errors.New("Key is stale")
+	ErrVersionChanged = errors.New("Aggregates were written since the version was read")
+)
+
+const (
+	// Cached entries are indexed by the time partitions their time range
+	// overlaps, so that they can be invalidated when rows within those
+	// partitions are written.
+	CachePartitionDuration = time.Duration(24) * time.Hour
+	// Entries overlapping more partitions than this, e.g. those without a
+	// start time, are not indexed. Instead, they are checked when read against
+	// a generation which every write advances.
+	MaxCachePartitions = 366
+	partitionLayout    = "2006-01-02"
+)
+
+// Advances a generation, and records the latest time aggregates within it were
+// written. If given, the entries indexed by the index, and the index itself,
+// are then deleted, atomically so that entries indexed concurrently are not
+// missed.
+var invalidateScript = redis.NewScript(`
+redis.call("INCR", KEYS[1])
+if (tonumber(redis.call("GET", KEYS[2])) or 0) < tonumber(ARGV[1]) then
+    redis.call("SET", KEYS[2], ARGV[1])
+end
+
+if not KEYS[3] then
+    return 0
+end
+local keys = redis.call("SMEMBERS", KEYS[3])
+for _, key in ipairs(keys) do
+    redis.call("DEL", key)
+end
+redis.call("DEL", KEYS[3])
+return #keys
+`)
+
+// Sets an entry and adds it to its indexes, unless the sum of the generation
+// counters differs from the entry's generation, as aggregates have been written
+// since the entry's aggregates were read. The entry key is followed by the
+// counter keys, then the index keys.
+var setEntryScript = redis.NewScript(`
+local counterCount = tonumber(ARGV[4])
+local generation = 0
+for idx = 2, counterCount + 1 do
+    generation = generation + (tonumber(redis.call("GET", KEYS[idx])) or 0)
+end
+if generation ~= tonumber(ARGV[3]) then
+    return 0
+end
+
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+for idx = counterCount + 2, #KEYS do
+    redis.call("SADD", KEYS[idx], KEYS[1])
+    -- Indexes outlive every entry within them.
+    redis.call("PEXPIRE", KEYS[idx], ARGV[2])
+end
+return 1
+`)
+
+// CachePartitions returns the time partitions overlapped by the time range,
+// or false if there are more than MaxCachePartitions.
+func CachePartitions(start, end time.Time) ([]time.Time, bool) {
+	first := start.UTC().Truncate(CachePartitionDuration)
+	last := end.UTC().Truncate(CachePartitionDuration)
+	if last.Sub(first)/CachePartitionDuration >= MaxCachePartitions {
+		return nil, false
+	}
+
+	partitions := []time.Time{}
+	for partition := first; !partition.After(last); partition = partition.Add(CachePartitionDuration) {
+		partitions = append(partitions, partition)
+	}
+	return partitions, true
+}
+
+// WrittenPartitions returns the distinct time partitions of the times, in
+// order.
+func WrittenPartitions(times []time.Time) []time.Time {
+	partitions := make([]time.Time, len(times))
+	for idx, t := range times {
+		partitions[idx] = t.UTC().Truncate(CachePartitionDuration)
+	}
+	slices.SortFunc(partitions, time.Time.Compare)
+	return slices.CompactFunc(partitions, time.Time.Equal)
+}
 
 type Cache struct {
 	Prefix string
 	TTL    time.Duration
-	conn   *redis.Client
+	// How long entries are kept, and can be served as stale, after expiring.
+	StaleTTL time.Duration
+	conn     *redis.Client
 }
 
-func NewCacheFromURL(url, prefix string, ttl time.Duration) *Cache {
+func NewCacheFromURL(url, prefix string, ttl, staleTTL time.Duration) *Cache {
 	opts, err := redis.ParseURL(url)
 	if err != nil {
 		panic(err)
 	}
 
 	conn := redis.NewClient(opts)
-	return &Cache{Prefix: prefix, TTL: ttl, conn: conn}
+	return &Cache{Prefix: prefix, TTL: ttl, StaleTTL: staleTTL, conn: conn}
+}
+
+// CacheVersion identifies the state of the aggregates within a time range. It
+// is read before the aggregates are, and entries are only set if the version is
+// unchanged, so that aggregates which a concurrent write outdated are not
+// cached after the write has invalidated the cache.
+type CacheVersion struct {
+	// Sum of the generations of the time partitions the time range overlaps,
+	// which each write within a partition advances.
+	Generation int64
+	// Latest time aggregates within the time partitions were written, which is
+	// zero if none have been since the cache was created.
+	ModifiedAt time.Time
+}
+
+// cacheEntry is a cached value, which is fresh until the given time and stale
+// thereafter.
+type cacheEntry struct {
+	FreshUntil time.Time
+	ETag       string
+	ModifiedAt time.Time
+	Aggregates []Aggregate
+	Generation int64
+}
+
+func toUnixMicro(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMicro()
+}
+
+func fromUnixMicro(us int64) time.Time {
+	if us == 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(us).UTC()
+}
+
+// encodeCacheEntry encodes the entry as a CacheEntry message, which is much
+// smaller than JSON and faster to decode.
+func encodeCacheEntry(entry cacheEntry) ([]byte, error) {
+	return proto.Marshal(&CacheEntry{
+		FreshUntil: toUnixMicro(entry.FreshUntil),
+		Etag:       entry.ETag,
+		ModifiedAt: toUnixMicro(entry.ModifiedAt),
+		Aggregates: MapToProto(entry.Aggregates),
+		Generation: entry.Generation,
+	})
+}
+
+func decodeCacheEntry(s string) (cacheEntry, error) {
+	var message CacheEntry
+	if err := proto.Unmarshal([]byte(s), &message); err != nil {
+		return cacheEntry{}, err
+	}
+
+	records, err := MapFromProto(message.GetAggregates())
+	if err != nil {
+		return cacheEntry{}, err
+	}
+
+	return cacheEntry{
+		FreshUntil: fromUnixMicro(message.FreshUntil),
+		ETag:       message.Etag,
+		ModifiedAt: fromUnixMicro(message.ModifiedAt),
+		Aggregates: records,
+		Generation: message.Generation,
+	}, nil
 }
 
 func (c *Cache) Close() error {
 	return c.conn.Close()
 }
 
+// Ping checks that Redis is reachable.
+func (c *Cache) Ping(ctx context.Context) error {
+	return c.conn.Ping(ctx).Err()
+}
+
+// ParamsKey identifies the aggregates for the params.
+func ParamsKey(params AggregatesReqParams) string {
+	bbox := ""
+	if params.BoundingBox != nil {
+		bbox = fmt.Sprintf(
+			"%g,%g,%g,%g",
+			params.BoundingBox.MinLon,
+			params.BoundingBox.MinLat,
+			params.BoundingBox.MaxLon,
+			params.BoundingBox.MaxLat,
+		)
+	}
+
+	return fmt.Sprintf(
+		"%s|%s|%s|%s|%d|%s|%s|%s",
+		params.StartTime,
+		params.EndTime,
+		params.TimePrecision,
+		params.Location,
+		params.GeoPrecision,
+		strings.Join(params.IncidentTypes, ","),
+		bbox,
+		params.GeohashPrefix,
+	)
+}
+
 func (c *Cache) MakeKey(params AggregatesReqParams) string {
-	return fmt.Sprintf("%s:%s|%s|%s|%d", c.Prefix, params.StartTime, params.EndTime, params.TimePrecision, params.GeoPrecision)
+	return fmt.Sprintf("%s:%s", c.Prefix, ParamsKey(params))
+}
+
+func (c *Cache) MakeIndexKey(partition time.Time) string {
+	return fmt.Sprintf("%s:index:%s", c.Prefix, partition.Format(partitionLayout))
+}
+
+func (c *Cache) MakeGenerationKey(partition time.Time) string {
+	return fmt.Sprintf("%s:generation:%s", c.Prefix, partition.Format(partitionLayout))
+}
+
+// MakeUnboundedGenerationKey makes the key of the generation which every write
+// advances.
+func (c *Cache) MakeUnboundedGenerationKey() string {
+	return fmt.Sprintf("%s:generation:unbounded", c.Prefix)
+}
+
+func (c *Cache) MakeModifiedKey(partition time.Time) string {
+	return fmt.Sprintf("%s:modified:%s", c.Prefix, partition.Format(partitionLayout))
+}
+
+func (c *Cache) MakeUnboundedModifiedKey() string {
+	return fmt.Sprintf("%s:modified:unbounded", c.Prefix)
+}
+
+// indexKeys returns the keys of the indexes which an entry for the params is
+// added to, which are none if its time range is unbounded.
+func (c *Cache) indexKeys(params AggregatesReqParams) []string {
+	partitions, ok := CachePartitions(params.StartTime, params.EndTime)
+	if !ok {
+		return nil
+	}
+
+	keys := make([]string, len(partitions))
+	for idx, partition := range partitions {
+		keys[idx] = c.MakeIndexKey(partition)
+	}
+	return keys
+}
+
+// generationKeys returns the keys of the counters which sum to the generation
+// of the params' time range.
+func (c *Cache) generationKeys(params AggregatesReqParams) []string {
+	partitions, ok := CachePartitions(params.StartTime, params.EndTime)
+	if !ok {
+		return []string{c.MakeUnboundedGenerationKey()}
+	}
+
+	keys := make([]string, len(partitions))
+	for idx, partition := range partitions {
+		keys[idx] = c.MakeGenerationKey(partition)
+	}
+	return keys
 }
 
-func (c *Cache) Get(ctx context.Context, params AggregatesReqParams) ([]Aggregate, error) {
-	key := c.MakeKey(params)
-	value, err := c.conn.Get(ctx, key).Result()
+// modifiedKeys returns the keys of the write times whose latest is when the
+// params' time range was last written.
+func (c *Cache) modifiedKeys(params AggregatesReqParams) []string {
+	partitions, ok := CachePartitions(params.StartTime, params.EndTime)
+	if !ok {
+		return []string{c.MakeUnboundedModifiedKey()}
+	}
 
-	if errors.Is(err, redis.Nil) {
-		return []Aggregate{}, ErrNoSuchKey
+	keys := make([]string, len(partitions))
+	for idx, partition := range partitions {
+		keys[idx] = c.MakeModifiedKey(partition)
 	}
+	return keys
+}
 
-	return DecodeAggregates(value)
+// parseInt parses a counter's or write time's value, which is nil if it hasn't
+// been set.
+func parseInt(value any) int64 {
+	s, ok := value.(string)
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
 }
 
-func (c *Cache) Set(ctx context.Context, params AggregatesReqParams, records []Aggregate) error {
-	key := c.MakeKey(params)
+// Versions gets the version of the aggregates for each of the params.
+func (c *Cache) Versions(ctx context.Context, params []AggregatesReqParams) (_ []CacheVersion, err error) {
+	ctx, span := startCacheSpan(ctx, "Cache.Versions")
+	defer func() { endSpan(span, err) }()
+
+	keys := []string{}
+	keyIndexes := make(map[string]int)
+	for _, p := range params {
+		for _, key := range append(c.generationKeys(p), c.modifiedKeys(p)...) {
+			if _, ok := keyIndexes[key]; !ok {
+				keyIndexes[key] = len(keys)
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	values, err := c.conn.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]CacheVersion, len(params))
+	for idx, p := range params {
+		for _, key := range c.generationKeys(p) {
+			versions[idx].Generation += parseInt(values[keyIndexes[key]])
+		}
+
+		modifiedAt := int64(0)
+		for _, key := range c.modifiedKeys(p) {
+			modifiedAt = max(modifiedAt, parseInt(values[keyIndexes[key]]))
+		}
+		versions[idx].ModifiedAt = fromUnixMicro(modifiedAt)
+	}
+	return versions, nil
+}
+
+// Get gets the aggregates for the params, along with their validators. Expired
+// entries which are still kept are returned along with ErrStaleKey.
+func (c *Cache) Get(ctx context.Context, params AggregatesReqParams) (_ AggregatesResult, err error) {
+	ctx, span := startCacheSpan(ctx, "Cache.Get")
+	defer func() { endSpan(span, ignoreCacheMiss(err)) }()
+
+	// Entries which aren't indexed are read along with the generation they are
+	// checked against.
+	keys := []string{c.MakeKey(params)}
+	indexed := c.indexKeys(params) != nil
+	if !indexed {
+		keys = append(keys, c.MakeUnboundedGenerationKey())
+	}
+
+	values, err := c.conn.MGet(ctx, keys...).Result()
+	if err != nil {
+		return AggregatesResult{}, err
+	}
 
-	var buff bytes.Buffer
-	if err := EncodeAggregates(records, &buff); err != nil {
+	value, ok := values[0].(string)
+	if !ok {
+		return AggregatesResult{}, ErrNoSuchKey
+	}
+
+	entry, err := decodeCacheEntry(value)
+	if err != nil {
+		return AggregatesResult{}, err
+	}
+	if !indexed && entry.Generation != parseInt(values[1]) {
+		return AggregatesResult{}, ErrNoSuchKey
+	}
+
+	result := AggregatesResult{Aggregates: entry.Aggregates, ETag: entry.ETag, ModifiedAt: entry.ModifiedAt}
+	if time.Now().After(entry.FreshUntil) {
+		return result, ErrStaleKey
+	}
+	return result, nil
+}
+
+// ignoreCacheMiss returns nil for misses and stale entries, which are not
+// failures of the cache.
+func ignoreCacheMiss(err error) error {
+	if errors.Is(err, ErrNoSuchKey) || errors.Is(err, ErrStaleKey) {
+		return nil
+	}
+	return err
+}
+
+// ignoreVersionChanged returns nil if aggregates weren't cached as they were
+// outdated, which is expected while aggregates are being written.
+func ignoreVersionChanged(err error) error {
+	if errors.Is(err, ErrVersionChanged) {
+		return nil
+	}
+	return err
+}
+
+// Set sets the aggregates for the params, along with their validators, unless
+// their version has changed, in which case ErrVersionChanged is returned.
+func (c *Cache) Set(ctx context.Context, params AggregatesReqParams, result AggregatesResult, version CacheVersion) error {
+	ctx, span := startCacheSpan(ctx, "Cache.Set")
+	entry := cacheEntry{ETag: result.ETag, ModifiedAt: result.ModifiedAt, Aggregates: result.Aggregates, Generation: version.Generation}
+	err := c.setEntries(ctx, []AggregatesReqParams{params}, []cacheEntry{entry})
+	endSpan(span, ignoreVersionChanged(err))
+	return err
+}
+
+// GetMany gets the aggregates for each of the params, which are nil for params
+// without a cached entry. Expired entries which are still kept are returned,
+// and reported as stale.
+func (c *Cache) GetMany(ctx context.Context, params []AggregatesReqParams) (_ [][]Aggregate, _ []bool, err error) {
+	ctx, span := startCacheSpan(ctx, "Cache.GetMany")
+	defer func() { endSpan(span, err) }()
+
+	keys := make([]string, len(params))
+	for idx, p := range params {
+		keys[idx] = c.MakeKey(p)
+	}
+
+	values, err := c.conn.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	results := make([][]Aggregate, len(params))
+	stale := make([]bool, len(params))
+	for idx, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		entry, err := decodeCacheEntry(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		results[idx] = entry.Aggregates
+		stale[idx] = now.After(entry.FreshUntil)
+	}
+	return results, stale, nil
+}
+
+// SetMany sets the aggregates for each of the params whose version is
+// unchanged. If any version has changed, ErrVersionChanged is returned.
+func (c *Cache) SetMany(ctx context.Context, params []AggregatesReqParams, results [][]Aggregate, versions []CacheVersion) error {
+	ctx, span := startCacheSpan(ctx, "Cache.SetMany")
+	entries := make([]cacheEntry, len(results))
+	for idx, records := range results {
+		entries[idx] = cacheEntry{Aggregates: records, Generation: versions[idx].Generation}
+	}
+	err := c.setEntries(ctx, params, entries)
+	endSpan(span, ignoreVersionChanged(err))
+	return err
+}
+
+// setEntries sets the entry for each of the params, unless the generation of
+// its time range differs from the entry's. Entries are fresh for the TTL, then
+// stale for the stale TTL.
+func (c *Cache) setEntries(ctx context.Context, params []AggregatesReqParams, entries []cacheEntry) error {
+	freshUntil := time.Now().Add(c.TTL)
+	ttl := c.TTL + c.StaleTTL
+
+	cmds := make([]*redis.Cmd, len(params))
+	_, err := c.conn.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for idx, p := range params {
+			entry := entries[idx]
+			entry.FreshUntil = freshUntil
+
+			value, err := encodeCacheEntry(entry)
+			if err != nil {
+				return err
+			}
+
+			generationKeys := c.generationKeys(p)
+			keys := append([]string{c.MakeKey(p)}, generationKeys...)
+			keys = append(keys, c.indexKeys(p)...)
+			cmds[idx] = setEntryScript.Eval(ctx, pipe, keys, value, ttl.Milliseconds(), entry.Generation, len(generationKeys))
+		}
+		return nil
+	})
+	if err != nil {
 		return err
 	}
 
-	return c.conn.Set(ctx, key, buff.String(), c.TTL).Err()
+	for _, cmd := range cmds {
+		if set, _ := cmd.Int(); set == 0 {
+			return ErrVersionChanged
+		}
+	}
+	return nil
+}
+
+// Invalidate deletes every cached entry whose time range overlaps any of the
+// given times, advances the generations of those time ranges, and records when
+// they were written. Generations are kept indefinitely, as a counter which
+// expired could repeat a generation.
+func (c *Cache) Invalidate(ctx context.Context, times []time.Time) (err error) {
+	ctx, span := startCacheSpan(ctx, "Cache.Invalidate")
+	defer func() { endSpan(span, err) }()
+
+	modifiedAt := time.Now().UnixMicro()
+	for _, partition := range WrittenPartitions(times) {
+		keys := []string{c.MakeGenerationKey(partition), c.MakeModifiedKey(partition), c.MakeIndexKey(partition)}
+		if err := invalidateScript.Run(ctx, c.conn, keys, modifiedAt).Err(); err != nil {
+			return err
+		}
+	}
+
+	// Entries which aren't indexed are invalidated by any write.
+	keys := []string{c.MakeUnboundedGenerationKey(), c.MakeUnboundedModifiedKey()}
+	return invalidateScript.Run(ctx, c.conn, keys, modifiedAt).Err()
 }
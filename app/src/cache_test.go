@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachePartitions(t *testing.T) {
+	start := time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC)
+	end := time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC)
+	expected := []time.Time{
+		time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC),
+	}
+
+	actual, ok := CachePartitions(start, end)
+	assert.True(t, ok)
+	assert.Equal(t, expected, actual)
+}
+
+func TestCachePartitionsWhenUnbounded(t *testing.T) {
+	_, ok := CachePartitions(time.Time{}, time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC))
+	assert.False(t, ok)
+}
+
+func TestWrittenPartitions(t *testing.T) {
+	times := []time.Time{
+		time.Date(2025, 1, 3, 1, 0, 0, 0, time.UTC),
+		time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC),
+		time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC),
+	}
+	expected := []time.Time{
+		time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC),
+	}
+
+	assert.Equal(t, expected, WrittenPartitions(times))
+}
+
+func TestCacheIndexKeys(t *testing.T) {
+	cache := &Cache{Prefix: "aggregates"}
+	params := AggregatesReqParams{
+		StartTime: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	actual := cache.indexKeys(params)
+	assert.Equal(t, []string{"aggregates:index:2025-01-01", "aggregates:index:2025-01-02"}, actual)
+
+	params.StartTime = time.Time{}
+	actual = cache.indexKeys(params)
+	assert.Nil(t, actual)
+}
+
+func TestCacheGenerationKeys(t *testing.T) {
+	cache := &Cache{Prefix: "aggregates"}
+	params := AggregatesReqParams{
+		StartTime: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	actual := cache.generationKeys(params)
+	assert.Equal(t, []string{"aggregates:generation:2025-01-01", "aggregates:generation:2025-01-02"}, actual)
+
+	params.StartTime = time.Time{}
+	actual = cache.generationKeys(params)
+	assert.Equal(t, []string{"aggregates:generation:unbounded"}, actual)
+}
+
+func TestCacheModifiedKeys(t *testing.T) {
+	cache := &Cache{Prefix: "aggregates"}
+	params := AggregatesReqParams{
+		StartTime: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	actual := cache.modifiedKeys(params)
+	assert.Equal(t, []string{"aggregates:modified:2025-01-01", "aggregates:modified:2025-01-02"}, actual)
+
+	params.StartTime = time.Time{}
+	actual = cache.modifiedKeys(params)
+	assert.Equal(t, []string{"aggregates:modified:unbounded"}, actual)
+}
+
+func TestCacheMakeKey(t *testing.T) {
+	cache := Cache{Prefix: "aggregates"}
+	params := AggregatesReqParams{
+		StartTime:     time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+		TimePrecision: FixedTimePrecision(time.Hour),
+		Location:      time.UTC,
+		GeoPrecision:  DefaultGeoPrecision,
+	}
+
+	assert.Equal(t, "aggregates:"+ParamsKey(params), cache.MakeKey(params))
+	assert.NotEqual(t, ParamsKey(params), ParamsKey(withTimeRange(params, params.StartTime, params.StartTime)))
+}
+
+func TestCacheEntryEncoding(t *testing.T) {
+	entry := cacheEntry{
+		FreshUntil: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+		ETag:       "abc",
+		Generation: 3,
+		Aggregates: []Aggregate{
+			{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+		},
+	}
+
+	value, err := encodeCacheEntry(entry)
+	assert.Nil(t, err)
+
+	actual, err := decodeCacheEntry(string(value))
+	assert.Nil(t, err)
+	assert.Equal(t, entry, actual)
+}
+
+func TestCacheEntryEncodingWhenNoAggregates(t *testing.T) {
+	value, err := encodeCacheEntry(cacheEntry{})
+	assert.Nil(t, err)
+
+	actual, err := decodeCacheEntry(string(value))
+	assert.Nil(t, err)
+	// Entries without aggregates are distinct from missing entries.
+	assert.Equal(t, []Aggregate{}, actual.Aggregates)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+const (
+	// Requests spanning more chunks than this are cached whole.
+	MaxCacheChunks = 366
+	// Resolution of database timestamps. Adding it to a time excludes that
+	// time from a time range which starts at it.
+	timestampResolution = time.Microsecond
+)
+
+// ChunkPrecision returns the precision of the chunks which a request is split
+// into for caching: local days, or calendar periods for calendar precisions.
+// Chunk boundaries are always also bucket boundaries, so that each bucket lies
+// within a single chunk.
+func ChunkPrecision(precision TimePrecision) TimePrecision {
+	if precision.IsCalendar() {
+		return precision
+	}
+	return FixedTimePrecision(time.Duration(24) * time.Hour)
+}
+
+// ChunkPlan splits a request's time range into complete chunks, which can be
+// cached and shared between requests, and partial chunks at either end, which
+// are not cached.
+type ChunkPlan struct {
+	Precision TimePrecision
+	// Partial chunk at the start of the time range. If the time range starts on
+	// a chunk boundary, it still includes aggregates at that time, which lie
+	// in the bucket ending there rather than in the first complete chunk.
+	Head AggregatesReqParams
+	// Complete chunks, each starting just after the previous chunk's end.
+	Chunks []AggregatesReqParams
+	// Partial chunk at the end of the time range, which is nil if the time
+	// range ends on a chunk boundary. Includes the still open chunk, if any.
+	Tail *AggregatesReqParams
+}
+
+func withTimeRange(params AggregatesReqParams, start, end time.Time) AggregatesReqParams {
+	params.StartTime = start
+	params.EndTime = end
+	return params
+}
+
+// PlanChunks splits the request's time range into chunks. Chunks which end
+// after `now` are still open and so are not complete. If there are no
+// complete chunks, or more than MaxCacheChunks, false is returned.
+func PlanChunks(params AggregatesReqParams, now time.Time) (ChunkPlan, bool) {
+	precision := ChunkPrecision(params.TimePrecision)
+	end := params.EndTime
+	if now.Before(end) {
+		end = now
+	}
+
+	lower := BucketTime(params.StartTime, precision, params.Location)
+	upper := TruncateTime(end, precision, params.Location)
+	if !lower.Before(upper) {
+		return ChunkPlan{}, false
+	}
+
+	chunks := []AggregatesReqParams{}
+	for start := lower; start.Before(upper); {
+		if len(chunks) == MaxCacheChunks {
+			return ChunkPlan{}, false
+		}
+
+		chunkEnd := BucketTime(start.Add(timestampResolution), precision, params.Location)
+		chunks = append(chunks, withTimeRange(params, start.Add(timestampResolution), chunkEnd))
+		start = chunkEnd
+	}
+
+	plan := ChunkPlan{
+		Precision: precision,
+		Head:      withTimeRange(params, params.StartTime, lower),
+		Chunks:    chunks,
+	}
+	if upper.Before(params.EndTime) {
+		tail := withTimeRange(params, upper.Add(timestampResolution), params.EndTime)
+		plan.Tail = &tail
+	}
+	return plan, true
+}
+
+// SplitByChunk groups rolled up aggregates by the chunk they lie within, given
+// the chunk ends.
+func SplitByChunk(records []Aggregate, precision TimePrecision, loc *time.Location, chunkEnds []time.Time) [][]Aggregate {
+	indexes := make(map[time.Time]int)
+	chunks := make([][]Aggregate, len(chunkEnds))
+	for idx, chunkEnd := range chunkEnds {
+		indexes[chunkEnd] = idx
+		chunks[idx] = []Aggregate{}
+	}
+
+	for _, record := range records {
+		idx, ok := indexes[BucketTime(record.OccurredAt, precision, loc)]
+		if ok {
+			chunks[idx] = append(chunks[idx], record)
+		}
+	}
+	return chunks
+}
+
+// StitchChunks concatenates the aggregates of consecutive chunks, summing
+// counts of any bucket split between chunks, ordered as rolled up rows are.
+func StitchChunks(chunks ...[]Aggregate) []Aggregate {
+	type Bucket struct {
+		OccurredAt   time.Time
+		Geohash      string
+		IncidentType string
+	}
+
+	records := []Aggregate{}
+	indexes := make(map[Bucket]int)
+	for _, chunk := range chunks {
+		for _, record := range chunk {
+			bucket := Bucket{OccurredAt: record.OccurredAt, Geohash: record.Geohash, IncidentType: record.IncidentType}
+			if idx, ok := indexes[bucket]; ok {
+				records[idx].Count += record.Count
+				continue
+			}
+			indexes[bucket] = len(records)
+			records = append(records, record)
+		}
+	}
+
+	slices.SortStableFunc(records, func(a, b Aggregate) int {
+		return cmp.Or(
+			a.OccurredAt.Compare(b.OccurredAt),
+			cmp.Compare(a.Geohash, b.Geohash),
+			cmp.Compare(a.IncidentType, b.IncidentType),
+		)
+	})
+	return records
+}
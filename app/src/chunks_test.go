@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkPrecision(t *testing.T) {
+	assert.Equal(t, FixedTimePrecision(24*time.Hour), ChunkPrecision(FixedTimePrecision(time.Hour)))
+	assert.Equal(t, CalendarTimePrecision(CalendarMonth), ChunkPrecision(CalendarTimePrecision(CalendarMonth)))
+}
+
+func TestPlanChunks(t *testing.T) {
+	params := AggregatesReqParams{
+		StartTime:     time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(2025, 1, 4, 12, 0, 0, 0, time.UTC),
+		TimePrecision: FixedTimePrecision(time.Hour),
+		Location:      time.UTC,
+		GeoPrecision:  DefaultGeoPrecision,
+	}
+	now := time.Date(2025, 1, 3, 12, 0, 0, 0, time.UTC)
+
+	plan, ok := PlanChunks(params, now)
+
+	assert.True(t, ok)
+	assert.Equal(t, FixedTimePrecision(24*time.Hour), plan.Precision)
+	assert.Equal(t, withTimeRange(params, params.StartTime, time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)), plan.Head)
+	assert.Equal(t, []AggregatesReqParams{
+		withTimeRange(params, time.Date(2025, 1, 2, 0, 0, 0, 1000, time.UTC), time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC)),
+	}, plan.Chunks)
+	// The chunk ending on 2025-01-04 is still open.
+	assert.Equal(t, withTimeRange(params, time.Date(2025, 1, 3, 0, 0, 0, 1000, time.UTC), params.EndTime), *plan.Tail)
+}
+
+func TestPlanChunksWhenEndsOnChunkBoundary(t *testing.T) {
+	loc := MustLoadLocation("America/Los_Angeles")
+	params := AggregatesReqParams{
+		StartTime:     time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC),
+		TimePrecision: CalendarTimePrecision(CalendarMonth),
+		Location:      loc,
+		GeoPrecision:  DefaultGeoPrecision,
+	}
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	plan, ok := PlanChunks(params, now)
+
+	assert.True(t, ok)
+	assert.Equal(t, withTimeRange(params, params.StartTime, params.StartTime), plan.Head)
+	assert.Equal(t, []AggregatesReqParams{
+		withTimeRange(params, time.Date(2025, 1, 1, 8, 0, 0, 1000, time.UTC), time.Date(2025, 2, 1, 8, 0, 0, 0, time.UTC)),
+		withTimeRange(params, time.Date(2025, 2, 1, 8, 0, 0, 1000, time.UTC), time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC)),
+	}, plan.Chunks)
+	assert.Nil(t, plan.Tail)
+}
+
+func TestPlanChunksWhenStartsOnChunkBoundary(t *testing.T) {
+	params := AggregatesReqParams{
+		StartTime:     time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC),
+		TimePrecision: FixedTimePrecision(time.Hour),
+		Location:      time.UTC,
+		GeoPrecision:  DefaultGeoPrecision,
+	}
+
+	plan, ok := PlanChunks(params, params.EndTime)
+
+	assert.True(t, ok)
+	assert.Equal(t, withTimeRange(params, params.StartTime, params.StartTime), plan.Head)
+	assert.Equal(t, []AggregatesReqParams{
+		withTimeRange(params, time.Date(2025, 1, 1, 0, 0, 0, 1000, time.UTC), time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)),
+	}, plan.Chunks)
+	assert.Equal(t, withTimeRange(params, time.Date(2025, 1, 2, 0, 0, 0, 1000, time.UTC), params.EndTime), *plan.Tail)
+}
+
+func TestPlanChunksWhenNoCompleteChunks(t *testing.T) {
+	params := AggregatesReqParams{
+		StartTime:     time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC),
+		TimePrecision: FixedTimePrecision(time.Hour),
+		Location:      time.UTC,
+	}
+
+	_, ok := PlanChunks(params, params.EndTime)
+	assert.False(t, ok)
+}
+
+func TestPlanChunksWhenTooManyChunks(t *testing.T) {
+	params := AggregatesReqParams{
+		EndTime:       time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC),
+		TimePrecision: FixedTimePrecision(time.Hour),
+		Location:      time.UTC,
+	}
+
+	_, ok := PlanChunks(params, params.EndTime)
+	assert.False(t, ok)
+}
+
+func TestSplitByChunk(t *testing.T) {
+	records := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 1, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 2},
+		{OccurredAt: time.Date(2025, 1, 3, 1, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 3},
+	}
+	chunkEnds := []time.Time{
+		time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 1, 4, 0, 0, 0, 0, time.UTC),
+	}
+	expected := [][]Aggregate{
+		{records[0], records[1]},
+		{},
+		{records[2]},
+	}
+
+	actual := SplitByChunk(records, FixedTimePrecision(24*time.Hour), time.UTC, chunkEnds)
+	assert.Equal(t, expected, actual)
+}
+
+func TestStitchChunks(t *testing.T) {
+	head := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 1, 0, 0, 0, time.UTC), Geohash: "abcdefh", IncidentType: IncidentTypeFireIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 1, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+	}
+	chunk := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 1, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 2},
+		{OccurredAt: time.Date(2025, 1, 1, 2, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 3},
+	}
+	expected := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 1, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 3},
+		{OccurredAt: time.Date(2025, 1, 1, 1, 0, 0, 0, time.UTC), Geohash: "abcdefh", IncidentType: IncidentTypeFireIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 2, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 3},
+	}
+
+	actual := StitchChunks(head, chunk, []Aggregate{})
+	assert.Equal(t, expected, actual)
+}
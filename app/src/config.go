@@ -3,15 +3,46 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"time"
 )
 
 type Config struct {
-	CachePrefix string
-	CacheTTL    time.Duration
-	CacheURL    string
-	DatabaseURL string
-	Port        string
+	CachePrefix   string
+	CacheTTL      time.Duration
+	CacheStaleTTL time.Duration
+	CacheURL      string
+	// In-memory cache, in front of the shared cache.
+	LocalCacheTTL        time.Duration
+	LocalCacheMaxEntries int
+	LocalCacheMaxBytes   int64
+	DatabaseURL          string
+	Port                 string
+	// Port metrics are served on, which shouldn't be exposed publicly.
+	AdminPort  string
+	AuthMethod string
+	AuthKeys   []APIKey
+	// Requests per second, and burst size, allowed per client.
+	RateLimit      float64
+	RateLimitBurst int
+	// Failed authentications per second, and burst size, allowed per IP
+	// address.
+	AuthFailureRateLimit      float64
+	AuthFailureRateLimitBurst int
+	QueryCostLimit            QueryCostLimit
+	// Maximum size of the body of requests writing aggregates.
+	InsertMaxBytes int64
+	// Span exporter, and the file spans are written to by the file exporter.
+	TracesExporter string
+	TracesFile     string
+	// Server timeouts, for reading requests, writing responses, idle
+	// connections and draining connections on shutdown.
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+	// Maximum duration of database queries.
+	QueryTimeout time.Duration
 }
 
 func NewConfig() (*Config, error) {
@@ -34,11 +65,53 @@ func NewConfig() (*Config, error) {
 	}
 	config.CacheTTL = cacheTTL
 
+	cacheStaleTTLString, ok := os.LookupEnv("CACHE_AGGREGATES_STALE_TTL")
+	if !ok {
+		return config, fmt.Errorf("Unable to read cache stale ttl")
+	}
+
+	cacheStaleTTL, err := time.ParseDuration(cacheStaleTTLString)
+	if err != nil {
+		return config, err
+	}
+	config.CacheStaleTTL = cacheStaleTTL
+
 	config.CacheURL, ok = os.LookupEnv("REDIS_URL")
 	if !ok {
 		return config, fmt.Errorf("Unable to read cache url")
 	}
 
+	localCacheTTLString, ok := os.LookupEnv("LOCAL_CACHE_AGGREGATES_TTL")
+	if !ok {
+		return config, fmt.Errorf("Unable to read local cache ttl")
+	}
+
+	localCacheTTL, err := time.ParseDuration(localCacheTTLString)
+	if err != nil {
+		return config, err
+	}
+	config.LocalCacheTTL = localCacheTTL
+
+	localCacheMaxEntriesString, ok := os.LookupEnv("LOCAL_CACHE_AGGREGATES_MAX_ENTRIES")
+	if !ok {
+		return config, fmt.Errorf("Unable to read local cache max entries")
+	}
+
+	config.LocalCacheMaxEntries, err = strconv.Atoi(localCacheMaxEntriesString)
+	if err != nil {
+		return config, err
+	}
+
+	localCacheMaxBytesString, ok := os.LookupEnv("LOCAL_CACHE_AGGREGATES_MAX_BYTES")
+	if !ok {
+		return config, fmt.Errorf("Unable to read local cache max bytes")
+	}
+
+	config.LocalCacheMaxBytes, err = strconv.ParseInt(localCacheMaxBytesString, 10, 64)
+	if err != nil {
+		return config, err
+	}
+
 	config.DatabaseURL, ok = os.LookupEnv("AGGREGATES_DB_URL")
 	if !ok {
 		return config, fmt.Errorf("Unable to read database url")
@@ -49,5 +122,178 @@ func NewConfig() (*Config, error) {
 		return config, fmt.Errorf("Unable to read app port")
 	}
 
+	config.AdminPort, ok = os.LookupEnv("APP_ADMIN_PORT")
+	if !ok {
+		return config, fmt.Errorf("Unable to read app admin port")
+	}
+
+	config.AuthMethod, ok = os.LookupEnv("AUTH_METHOD")
+	if !ok {
+		return config, fmt.Errorf("Unable to read auth method")
+	}
+
+	authKeysString, ok := os.LookupEnv("AUTH_KEYS")
+	if !ok {
+		return config, fmt.Errorf("Unable to read auth keys")
+	}
+
+	config.AuthKeys, err = ParseAPIKeys(authKeysString)
+	if err != nil {
+		return config, err
+	}
+
+	rateLimitString, ok := os.LookupEnv("RATE_LIMIT_RPS")
+	if !ok {
+		return config, fmt.Errorf("Unable to read rate limit")
+	}
+
+	config.RateLimit, err = strconv.ParseFloat(rateLimitString, 64)
+	if err != nil {
+		return config, err
+	}
+
+	rateLimitBurstString, ok := os.LookupEnv("RATE_LIMIT_BURST")
+	if !ok {
+		return config, fmt.Errorf("Unable to read rate limit burst")
+	}
+
+	config.RateLimitBurst, err = strconv.Atoi(rateLimitBurstString)
+	if err != nil {
+		return config, err
+	}
+
+	authFailureRateLimitString, ok := os.LookupEnv("AUTH_FAILURE_RATE_LIMIT_RPS")
+	if !ok {
+		return config, fmt.Errorf("Unable to read auth failure rate limit")
+	}
+
+	config.AuthFailureRateLimit, err = strconv.ParseFloat(authFailureRateLimitString, 64)
+	if err != nil {
+		return config, err
+	}
+
+	authFailureRateLimitBurstString, ok := os.LookupEnv("AUTH_FAILURE_RATE_LIMIT_BURST")
+	if !ok {
+		return config, fmt.Errorf("Unable to read auth failure rate limit burst")
+	}
+
+	config.AuthFailureRateLimitBurst, err = strconv.Atoi(authFailureRateLimitBurstString)
+	if err != nil {
+		return config, err
+	}
+
+	maxQueryCostString, ok := os.LookupEnv("QUERY_MAX_COST")
+	if !ok {
+		return config, fmt.Errorf("Unable to read max query cost")
+	}
+
+	config.QueryCostLimit.MaxCost, err = strconv.ParseInt(maxQueryCostString, 10, 64)
+	if err != nil {
+		return config, err
+	}
+
+	maxExportCostString, ok := os.LookupEnv("QUERY_MAX_EXPORT_COST")
+	if !ok {
+		return config, fmt.Errorf("Unable to read max export cost")
+	}
+
+	config.QueryCostLimit.MaxExportCost, err = strconv.ParseInt(maxExportCostString, 10, 64)
+	if err != nil {
+		return config, err
+	}
+
+	insertMaxBytesString, ok := os.LookupEnv("INSERT_MAX_BYTES")
+	if !ok {
+		return config, fmt.Errorf("Unable to read max insert size")
+	}
+
+	config.InsertMaxBytes, err = strconv.ParseInt(insertMaxBytesString, 10, 64)
+	if err != nil {
+		return config, err
+	}
+
+	queryCostExtentString, ok := os.LookupEnv("QUERY_COST_EXTENT")
+	if !ok {
+		return config, fmt.Errorf("Unable to read query cost extent")
+	}
+
+	queryCostExtent, err := ParseBoundingBox(queryCostExtentString)
+	if err != nil {
+		return config, err
+	}
+	config.QueryCostLimit.Extent = *queryCostExtent
+
+	queryCostEpochString, ok := os.LookupEnv("QUERY_COST_EPOCH")
+	if !ok {
+		return config, fmt.Errorf("Unable to read query cost epoch")
+	}
+
+	config.QueryCostLimit.Epoch, err = ParseTimestamp(queryCostEpochString)
+	if err != nil {
+		return config, err
+	}
+
+	config.TracesExporter, ok = os.LookupEnv("TRACES_EXPORTER")
+	if !ok {
+		return config, fmt.Errorf("Unable to read traces exporter")
+	}
+
+	if config.TracesExporter == TracesExporterFile {
+		config.TracesFile, ok = os.LookupEnv("TRACES_FILE")
+		if !ok {
+			return config, fmt.Errorf("Unable to read traces file")
+		}
+	}
+
+	readTimeoutString, ok := os.LookupEnv("APP_READ_TIMEOUT")
+	if !ok {
+		return config, fmt.Errorf("Unable to read read timeout")
+	}
+
+	config.ReadTimeout, err = time.ParseDuration(readTimeoutString)
+	if err != nil {
+		return config, err
+	}
+
+	writeTimeoutString, ok := os.LookupEnv("APP_WRITE_TIMEOUT")
+	if !ok {
+		return config, fmt.Errorf("Unable to read write timeout")
+	}
+
+	config.WriteTimeout, err = time.ParseDuration(writeTimeoutString)
+	if err != nil {
+		return config, err
+	}
+
+	idleTimeoutString, ok := os.LookupEnv("APP_IDLE_TIMEOUT")
+	if !ok {
+		return config, fmt.Errorf("Unable to read idle timeout")
+	}
+
+	config.IdleTimeout, err = time.ParseDuration(idleTimeoutString)
+	if err != nil {
+		return config, err
+	}
+
+	shutdownTimeoutString, ok := os.LookupEnv("APP_SHUTDOWN_TIMEOUT")
+	if !ok {
+		return config, fmt.Errorf("Unable to read shutdown timeout")
+	}
+
+	config.ShutdownTimeout, err = time.ParseDuration(shutdownTimeoutString)
+	if err != nil {
+		return config, err
+	}
+
+	queryTimeoutString, ok := os.LookupEnv("QUERY_TIMEOUT")
+	if !ok {
+		return config, fmt.Errorf("Unable to read query timeout")
+	}
+
+	config.QueryTimeout, err = time.ParseDuration(queryTimeoutString)
+	if err != nil {
+		return config, err
+	}
+
 	return config, nil
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/mmcloughlin/geohash"
+)
+
+// Names of time precisions, from finest to coarsest, which queries are
+// coarsened through.
+var timePrecisionSteps = []string{"1m", "15m", "1h", "6h", "12h", "24h", "1w", "1mo", "1q", "1y"}
+
+// TimePrecisionName returns the `time_precision` parameter value of the
+// precision.
+func TimePrecisionName(precision TimePrecision) string {
+	for _, name := range timePrecisionSteps {
+		if p, _ := ParseTimePrecision(name); p == precision {
+			return name
+		}
+	}
+	return precision.String()
+}
+
+// Average durations of calendar periods, for estimating the number of time
+// buckets.
+var calendarUnitDurations = map[CalendarUnit]time.Duration{
+	CalendarWeek:    time.Duration(7*24) * time.Hour,
+	CalendarMonth:   time.Duration(730) * time.Hour,
+	CalendarQuarter: time.Duration(2190) * time.Hour,
+	CalendarYear:    time.Duration(8766) * time.Hour,
+}
+
+func (p TimePrecision) approxDuration() time.Duration {
+	if p.IsCalendar() {
+		return calendarUnitDurations[p.Unit]
+	}
+	return p.Duration
+}
+
+// QueryCostLimit bounds the cost of queries, estimated as the number of
+// (time bucket, geohash cell) pairs a query can return.
+type QueryCostLimit struct {
+	MaxCost int64
+	// Budget of streamed exports, which aren't buffered, so may be larger.
+	MaxExportCost int64
+	// Extent of the aggregates, which queries without a spatial filter are
+	// assumed to cover.
+	Extent BoundingBox
+	// Earliest time of the aggregates, which queries without a start time are
+	// assumed to start from.
+	Epoch time.Time
+}
+
+// Estimate returns the number of time buckets in the query's time range,
+// multiplied by the number of geohash cells in its spatial filter.
+func (l QueryCostLimit) Estimate(params AggregatesReqParams) int64 {
+	return l.EstimateBuckets(params) * l.EstimateCells(params)
+}
+
+// EstimateBuckets returns the number of time buckets in the query's time range.
+func (l QueryCostLimit) EstimateBuckets(params AggregatesReqParams) int64 {
+	start := params.StartTime
+	if start.IsZero() {
+		start = l.Epoch
+	}
+	return int64(max(params.EndTime.Sub(start), 0)/params.TimePrecision.approxDuration()) + 1
+}
+
+// EstimateCells returns the number of geohash cells in the query's spatial
+// filter.
+func (l QueryCostLimit) EstimateCells(params AggregatesReqParams) int64 {
+	region, ok := l.region(params)
+	if !ok {
+		return 0
+	}
+	cells := int64(coverRange(region, params.GeoPrecision).Len())
+	if params.GeohashPrefix != "" && len(params.GeohashPrefix) <= params.GeoPrecision {
+		// The cover includes neighbouring cells on the prefix's edges.
+		cells = min(cells, int64(1)<<(5*(params.GeoPrecision-len(params.GeohashPrefix))))
+	}
+	return cells
+}
+
+// region returns the area covered by the query's spatial filter, within the
+// extent, or false if there is no overlap.
+func (l QueryCostLimit) region(params AggregatesReqParams) (BoundingBox, bool) {
+	region := l.Extent
+	if params.BoundingBox != nil {
+		region = intersectBoxes(region, *params.BoundingBox)
+	}
+	if params.GeohashPrefix != "" {
+		box := geohash.BoundingBox(params.GeohashPrefix)
+		region = intersectBoxes(region, BoundingBox{MinLon: box.MinLng, MinLat: box.MinLat, MaxLon: box.MaxLng, MaxLat: box.MaxLat})
+	}
+	return region, region.Valid()
+}
+
+func intersectBoxes(a, b BoundingBox) BoundingBox {
+	return BoundingBox{
+		MinLon: max(a.MinLon, b.MinLon),
+		MinLat: max(a.MinLat, b.MinLat),
+		MaxLon: min(a.MaxLon, b.MaxLon),
+		MaxLat: min(a.MaxLat, b.MaxLat),
+	}
+}
+
+// ForFormat returns the limit of queries in the response format.
+func (l QueryCostLimit) ForFormat(format string) QueryCostLimit {
+	if IsStreamingFormat(format) {
+		l.MaxCost = l.MaxExportCost
+	}
+	return l
+}
+
+// Coarsen returns the query at the finest precisions within the limit. Time
+// precision is coarsened before geohash precision. If no precisions are within
+// the limit, false is returned.
+func (l QueryCostLimit) Coarsen(params AggregatesReqParams) (AggregatesReqParams, bool) {
+	first := slices.Index(timePrecisionSteps, TimePrecisionName(params.TimePrecision))
+
+	coarsened := params
+	for geoPrecision := params.GeoPrecision; geoPrecision >= MinGeoPrecision; geoPrecision-- {
+		coarsened.GeoPrecision = geoPrecision
+		for _, name := range timePrecisionSteps[max(first, 0):] {
+			coarsened.TimePrecision, _ = ParseTimePrecision(name)
+			if l.Estimate(coarsened) <= l.MaxCost {
+				return coarsened, true
+			}
+		}
+	}
+	return params, false
+}
+
+// QueryCostError explains why a query was rejected.
+func QueryCostError(cost, maxCost int64) ErrorResponse {
+	return ErrorResponse{
+		Error: fmt.Sprintf(
+			"Query may return up to %d (time bucket, geohash cell) pairs, over the limit of %d. "+
+				"Narrow the time range or spatial filter, use a coarser time_precision or geo_precision, "+
+				"or set coarsen=true to coarsen the query automatically.",
+			cost,
+			maxCost,
+		),
+		Cost:    cost,
+		MaxCost: maxCost,
+	}
+}
+
+// GetCoarsen returns whether the client opted in to coarsening of over-budget
+// queries.
+func GetCoarsen(params url.Values) (bool, error) {
+	return GetParam(params, "coarsen", false, strconv.ParseBool)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testQueryCostLimit = QueryCostLimit{
+	Extent: BoundingBox{MinLon: -122.52, MinLat: 37.70, MaxLon: -122.35, MaxLat: 37.84},
+	Epoch:  time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+}
+
+func makeCostParams(timePrecision TimePrecision, geoPrecision int) AggregatesReqParams {
+	return AggregatesReqParams{
+		StartTime:     time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+		TimePrecision: timePrecision,
+		Location:      time.UTC,
+		GeoPrecision:  geoPrecision,
+		GeohashPrefix: "9q8yy",
+	}
+}
+
+func TestQueryCostLimitEstimate(t *testing.T) {
+	withoutStartTime := makeCostParams(FixedTimePrecision(time.Hour), 7)
+	withoutStartTime.StartTime = time.Time{}
+
+	outsideExtent := makeCostParams(FixedTimePrecision(time.Hour), 7)
+	outsideExtent.GeohashPrefix = ""
+	outsideExtent.BoundingBox = &BoundingBox{MinLon: -74.1, MinLat: 40.6, MaxLon: -73.9, MaxLat: 40.8}
+
+	type testCase struct {
+		Name     string
+		Params   AggregatesReqParams
+		Expected int64
+	}
+
+	testCases := []testCase{
+		{Name: "Single cell", Params: makeCostParams(FixedTimePrecision(time.Hour), 5), Expected: 25},
+		{Name: "Cells within prefix", Params: makeCostParams(FixedTimePrecision(time.Hour), 7), Expected: 25 * 1024},
+		{Name: "Calendar precision", Params: makeCostParams(CalendarTimePrecision(CalendarMonth), 7), Expected: 1024},
+		{Name: "Without start time", Params: withoutStartTime, Expected: 25 * 1024},
+		{Name: "Outside extent", Params: outsideExtent, Expected: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, testQueryCostLimit.Estimate(tc.Params))
+		})
+	}
+}
+
+func TestQueryCostLimitEstimateWithoutSpatialFilter(t *testing.T) {
+	params := makeCostParams(FixedTimePrecision(time.Hour), 5)
+	params.GeohashPrefix = ""
+
+	// The extent spans several cells at precision 5.
+	assert.Greater(t, testQueryCostLimit.Estimate(params), int64(25))
+}
+
+func TestQueryCostLimitCoarsen(t *testing.T) {
+	type testCase struct {
+		Name     string
+		MaxCost  int64
+		Expected AggregatesReqParams
+	}
+
+	testCases := []testCase{
+		{Name: "Within limit", MaxCost: 1441 * 1024, Expected: makeCostParams(FixedTimePrecision(time.Minute), 7)},
+		{Name: "Coarser time precision", MaxCost: 2048, Expected: makeCostParams(FixedTimePrecision(24*time.Hour), 7)},
+		{Name: "Coarser geohash precision", MaxCost: 500, Expected: makeCostParams(FixedTimePrecision(6*time.Hour), 6)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			limit := testQueryCostLimit
+			limit.MaxCost = tc.MaxCost
+
+			actual, ok := limit.Coarsen(makeCostParams(FixedTimePrecision(time.Minute), 7))
+			assert.True(t, ok)
+			assert.Equal(t, tc.Expected, actual)
+		})
+	}
+}
+
+func TestQueryCostLimitCoarsenWhenOverLimit(t *testing.T) {
+	params := makeCostParams(FixedTimePrecision(time.Minute), 7)
+	_, ok := testQueryCostLimit.Coarsen(params)
+	assert.False(t, ok)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	ShapeLong = "long"
+	ShapeCube = "cube"
+)
+
+const (
+	CubeLayoutDense = "dense"
+	CubeLayoutCSR   = "csr"
+)
+
+// Zero filled cubes with more timestamps than this are rejected.
+const MaxCubeTimestamps = 100_000
+
+// Dense cubes with more cells than this are rejected, as every cell is
+// allocated and encoded, whether or not it has a count.
+const MaxDenseCubeCells = 10_000_000
+
+var (
+	ErrInvalidShape      = errors.New("Invalid response shape")
+	ErrInvalidCubeLayout = errors.New("Invalid cube layout")
+	ErrInvalidZeroFill   = errors.New("Invalid zero fill")
+	ErrTooManyTimestamps = errors.New("Too many timestamps to zero fill")
+	ErrCubeTooLarge      = errors.New("Too many cells for a dense cube")
+)
+
+// ShapeParams determine how aggregates are arranged in the response.
+type ShapeParams struct {
+	Shape string
+	// Layout of the counts matrix of cubes.
+	Layout string
+	// Whether cubes include every time bucket in the time range, rather than
+	// only those with counts.
+	ZeroFill bool
+}
+
+// EstimateCubeSize estimates the size of the cube the query may return, as its
+// cost is estimated, before it is run. If the cube may be over its limits,
+// ErrTooManyTimestamps or ErrCubeTooLarge is returned along with its estimated
+// number of timestamps or cells.
+func EstimateCubeSize(limit QueryCostLimit, params AggregatesReqParams, shape ShapeParams) (int64, error) {
+	if buckets := limit.EstimateBuckets(params); shape.ZeroFill && buckets > MaxCubeTimestamps {
+		return buckets, ErrTooManyTimestamps
+	}
+	if cells := limit.Estimate(params); shape.Layout != CubeLayoutCSR && cells > MaxDenseCubeCells {
+		return cells, ErrCubeTooLarge
+	}
+	return 0, nil
+}
+
+// CubeSizeError explains why a cube was rejected, given ErrTooManyTimestamps or
+// ErrCubeTooLarge and the cube's estimated size, if known.
+func CubeSizeError(err error, size int64) ErrorResponse {
+	if errors.Is(err, ErrTooManyTimestamps) {
+		return ErrorResponse{
+			Error: fmt.Sprintf(
+				"Zero filled cubes may have at most %d timestamps. "+
+					"Narrow the time range, use a coarser time_precision, or set zero_fill=false.",
+				MaxCubeTimestamps,
+			),
+			Cost:    size,
+			MaxCost: MaxCubeTimestamps,
+		}
+	}
+	return ErrorResponse{
+		Error: fmt.Sprintf(
+			"Dense cubes may have at most %d cells, one per (time bucket, geohash cell) pair. "+
+				"Narrow the time range or spatial filter, use a coarser time_precision or geo_precision, "+
+				"or set layout=csr.",
+			MaxDenseCubeCells,
+		),
+		Cost:    size,
+		MaxCost: MaxDenseCubeCells,
+	}
+}
+
+func ParseShape(s string) (string, error) {
+	if s != ShapeLong && s != ShapeCube {
+		return "", ErrInvalidShape
+	}
+	return s, nil
+}
+
+func ParseCubeLayout(s string) (string, error) {
+	if s != CubeLayoutDense && s != CubeLayoutCSR {
+		return "", ErrInvalidCubeLayout
+	}
+	return s, nil
+}
+
+func ParseZeroFill(s string) (bool, error) {
+	value, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, ErrInvalidZeroFill
+	}
+	return value, nil
+}
+
+func GetShapeParams(params url.Values) (p ShapeParams, err error) {
+	p.Shape, err = GetParam(params, "shape", ShapeLong, ParseShape)
+	if err != nil {
+		return
+	}
+
+	p.Layout, err = GetParam(params, "layout", CubeLayoutDense, ParseCubeLayout)
+	if err != nil {
+		return
+	}
+
+	p.ZeroFill, err = GetParam(params, "zero_fill", false, ParseZeroFill)
+	return
+}
+
+// CSRMatrix is a sparse matrix in compressed sparse row format. The non-zero
+// entries of row i are at positions IndPtr[i] up to IndPtr[i+1] of Indices,
+// which holds their column indexes, and Data, which holds their values.
+type CSRMatrix struct {
+	IndPtr  []int   `json:"indptr"`
+	Indices []int   `json:"indices"`
+	Data    []int32 `json:"data"`
+}
+
+// Cube holds counts summed over incident types as a matrix, with a row per
+// time bucket and a column per geohash cell. Counts are either a dense
+// [][]int32 or a *CSRMatrix.
+type Cube struct {
+	Timestamps []time.Time `json:"timestamps"`
+	Geohashes  []string    `json:"geohashes"`
+	Counts     any         `json:"counts"`
+}
+
+// ZeroFillTimestamps returns the time buckets within the request's time range.
+// Without a start time, the time range starts at the earliest aggregate.
+func ZeroFillTimestamps(params AggregatesReqParams, records []Aggregate) ([]time.Time, error) {
+	start := params.StartTime
+	if start.IsZero() {
+		start = params.EndTime
+		for _, record := range records {
+			if record.OccurredAt.Before(start) {
+				start = record.OccurredAt
+			}
+		}
+	}
+
+	timestamps, ok := BucketTimes(start, params.EndTime, params.TimePrecision, params.Location)
+	if !ok {
+		return nil, ErrTooManyTimestamps
+	}
+	return timestamps, nil
+}
+
+// BucketTimes returns the end of each time bucket within the time range, in
+// order. If there are more than MaxCubeTimestamps buckets, false is returned.
+func BucketTimes(start, end time.Time, precision TimePrecision, loc *time.Location) ([]time.Time, bool) {
+	last := BucketTime(end, precision, loc)
+	times := []time.Time{}
+	for t := BucketTime(start, precision, loc); !t.After(last); t = BucketTime(t.Add(timestampResolution), precision, loc) {
+		if len(times) == MaxCubeTimestamps {
+			return nil, false
+		}
+		times = append(times, t)
+	}
+	return times, true
+}
+
+// RollupCube rolls up aggregates into a cube, summing counts over incident
+// types. Timestamps and geohashes are sorted. If `timestamps` is not nil, the
+// cube has a row for each of them, and aggregates in other time buckets are
+// dropped, otherwise it has a row for each time bucket with counts. Dense cubes
+// with more than MaxDenseCubeCells cells are rejected.
+func RollupCube(records []Aggregate, layout string, timestamps []time.Time) (Cube, error) {
+	if timestamps == nil {
+		timestamps = []time.Time{}
+		for _, record := range records {
+			timestamps = append(timestamps, record.OccurredAt)
+		}
+		slices.SortFunc(timestamps, func(a, b time.Time) int { return a.Compare(b) })
+		timestamps = slices.CompactFunc(timestamps, time.Time.Equal)
+	}
+
+	geohashes := []string{}
+	for _, record := range records {
+		geohashes = append(geohashes, record.Geohash)
+	}
+	slices.Sort(geohashes)
+	geohashes = slices.Compact(geohashes)
+
+	if layout != CubeLayoutCSR && len(timestamps)*len(geohashes) > MaxDenseCubeCells {
+		return Cube{}, ErrCubeTooLarge
+	}
+
+	// Times are keyed by their instant, as equal times may have different
+	// locations.
+	rowIndexes := make(map[int64]int, len(timestamps))
+	for idx, t := range timestamps {
+		rowIndexes[t.UnixMicro()] = idx
+	}
+	colIndexes := make(map[string]int, len(geohashes))
+	for idx, hash := range geohashes {
+		colIndexes[hash] = idx
+	}
+
+	type Cell struct {
+		Row int
+		Col int
+	}
+
+	counts := make(map[Cell]int32)
+	for _, record := range records {
+		row, ok := rowIndexes[record.OccurredAt.UnixMicro()]
+		if !ok {
+			continue
+		}
+		counts[Cell{Row: row, Col: colIndexes[record.Geohash]}] += record.Count
+	}
+
+	cube := Cube{Timestamps: timestamps, Geohashes: geohashes}
+	if layout == CubeLayoutCSR {
+		cells := make([]Cell, 0, len(counts))
+		for cell := range counts {
+			cells = append(cells, cell)
+		}
+		slices.SortFunc(cells, func(a, b Cell) int {
+			return cmp.Or(cmp.Compare(a.Row, b.Row), cmp.Compare(a.Col, b.Col))
+		})
+
+		matrix := &CSRMatrix{
+			IndPtr:  make([]int, len(timestamps)+1),
+			Indices: make([]int, len(cells)),
+			Data:    make([]int32, len(cells)),
+		}
+		for idx, cell := range cells {
+			matrix.IndPtr[cell.Row+1]++
+			matrix.Indices[idx] = cell.Col
+			matrix.Data[idx] = counts[cell]
+		}
+		for row := range timestamps {
+			matrix.IndPtr[row+1] += matrix.IndPtr[row]
+		}
+		cube.Counts = matrix
+		return cube, nil
+	}
+
+	matrix := make([][]int32, len(timestamps))
+	for row := range matrix {
+		matrix[row] = make([]int32, len(geohashes))
+	}
+	for cell, count := range counts {
+		matrix[cell.Row][cell.Col] = count
+	}
+	cube.Counts = matrix
+	return cube, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetShapeParams(t *testing.T) {
+	params, _ := url.ParseQuery("shape=cube&layout=csr&zero_fill=true")
+	actual, err := GetShapeParams(params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, ShapeParams{Shape: ShapeCube, Layout: CubeLayoutCSR, ZeroFill: true}, actual)
+}
+
+func TestGetShapeParamsWhenDefault(t *testing.T) {
+	actual, err := GetShapeParams(url.Values{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, ShapeParams{Shape: ShapeLong, Layout: CubeLayoutDense}, actual)
+}
+
+func TestGetShapeParamsWhenInvalid(t *testing.T) {
+	for _, testCase := range []struct {
+		Query    string
+		Expected error
+	}{
+		{Query: "shape=wide", Expected: ErrInvalidShape},
+		{Query: "layout=coo", Expected: ErrInvalidCubeLayout},
+		{Query: "zero_fill=maybe", Expected: ErrInvalidZeroFill},
+	} {
+		params, _ := url.ParseQuery(testCase.Query)
+		_, err := GetShapeParams(params)
+		assert.ErrorIs(t, err, testCase.Expected, testCase.Query)
+	}
+}
+
+var cubeRecords = []Aggregate{
+	{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcdefh", IncidentType: IncidentTypeFireIncident, Count: 1},
+	{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcdefh", IncidentType: IncidentTypePoliceIncident, Count: 2},
+	{OccurredAt: time.Date(2025, 1, 1, 16, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 3},
+}
+
+func TestRollupCubeWhenDense(t *testing.T) {
+	expected := Cube{
+		Timestamps: []time.Time{time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 16, 0, 0, 0, time.UTC)},
+		Geohashes:  []string{"abcdefg", "abcdefh"},
+		Counts:     [][]int32{{0, 3}, {3, 0}},
+	}
+
+	actual, err := RollupCube(cubeRecords, CubeLayoutDense, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestRollupCubeWhenCSR(t *testing.T) {
+	expected := Cube{
+		Timestamps: []time.Time{time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 16, 0, 0, 0, time.UTC)},
+		Geohashes:  []string{"abcdefg", "abcdefh"},
+		Counts:     &CSRMatrix{IndPtr: []int{0, 1, 2}, Indices: []int{1, 0}, Data: []int32{3, 3}},
+	}
+
+	actual, err := RollupCube(cubeRecords, CubeLayoutCSR, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestRollupCubeWhenZeroFilled(t *testing.T) {
+	params := AggregatesReqParams{
+		StartTime:     time.Date(2025, 1, 1, 12, 30, 0, 0, time.UTC),
+		EndTime:       time.Date(2025, 1, 1, 16, 0, 0, 0, time.UTC),
+		TimePrecision: FixedTimePrecision(time.Hour),
+		Location:      time.UTC,
+	}
+	timestamps, err := ZeroFillTimestamps(params, cubeRecords)
+	require.Nil(t, err)
+
+	expected := Cube{
+		Timestamps: []time.Time{
+			time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC),
+			time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC),
+			time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC),
+			time.Date(2025, 1, 1, 16, 0, 0, 0, time.UTC),
+		},
+		Geohashes: []string{"abcdefg", "abcdefh"},
+		Counts:    &CSRMatrix{IndPtr: []int{0, 0, 1, 1, 2}, Indices: []int{1, 0}, Data: []int32{3, 3}},
+	}
+
+	actual, err := RollupCube(cubeRecords, CubeLayoutCSR, timestamps)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestRollupCubeWhenTimestampsInOtherLocation(t *testing.T) {
+	loc, _ := time.LoadLocation("America/Los_Angeles")
+	timestamps := []time.Time{
+		time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC).In(loc),
+		time.Date(2025, 1, 1, 16, 0, 0, 0, time.UTC).In(loc),
+	}
+
+	actual, err := RollupCube(cubeRecords, CubeLayoutDense, timestamps)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]int32{{0, 3}, {3, 0}}, actual.Counts)
+}
+
+func TestEstimateCubeSize(t *testing.T) {
+	params := AggregatesReqParams{
+		StartTime:     time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(2025, 1, 11, 0, 0, 0, 0, time.UTC),
+		TimePrecision: FixedTimePrecision(time.Minute),
+		GeohashPrefix: "9q8yy",
+		GeoPrecision:  7,
+	}
+
+	size, err := EstimateCubeSize(testQueryCostLimit, params, ShapeParams{Shape: ShapeCube, Layout: CubeLayoutDense})
+	assert.ErrorIs(t, err, ErrCubeTooLarge)
+	assert.Equal(t, int64(14401*1024), size)
+
+	_, err = EstimateCubeSize(testQueryCostLimit, params, ShapeParams{Shape: ShapeCube, Layout: CubeLayoutCSR})
+	assert.Nil(t, err)
+
+	_, err = EstimateCubeSize(testQueryCostLimit, params, ShapeParams{Shape: ShapeCube, Layout: CubeLayoutCSR, ZeroFill: true})
+	assert.Nil(t, err)
+
+	params.EndTime = time.Date(2025, 4, 11, 0, 0, 0, 0, time.UTC)
+	size, err = EstimateCubeSize(testQueryCostLimit, params, ShapeParams{Shape: ShapeCube, Layout: CubeLayoutCSR, ZeroFill: true})
+	assert.ErrorIs(t, err, ErrTooManyTimestamps)
+	assert.Equal(t, int64(144001), size)
+}
+
+func TestRollupCubeWhenDenseTooLarge(t *testing.T) {
+	timestamps := make([]time.Time, MaxCubeTimestamps)
+	for idx := range timestamps {
+		timestamps[idx] = time.Date(2025, 1, 1, 0, idx, 0, 0, time.UTC)
+	}
+	records := []Aggregate{}
+	for idx := range MaxDenseCubeCells/MaxCubeTimestamps + 1 {
+		records = append(records, Aggregate{OccurredAt: timestamps[0], Geohash: fmt.Sprintf("9q8yy%02d", idx), Count: 1})
+	}
+
+	_, err := RollupCube(records, CubeLayoutDense, timestamps)
+	assert.ErrorIs(t, err, ErrCubeTooLarge)
+
+	_, err = RollupCube(records, CubeLayoutCSR, timestamps)
+	assert.Nil(t, err)
+}
+
+func TestZeroFillTimestampsWhenNoStartTime(t *testing.T) {
+	params := AggregatesReqParams{
+		EndTime:       time.Date(2025, 1, 1, 16, 0, 0, 0, time.UTC),
+		TimePrecision: FixedTimePrecision(time.Hour),
+		Location:      time.UTC,
+	}
+
+	actual, err := ZeroFillTimestamps(params, cubeRecords)
+
+	assert.Nil(t, err)
+	assert.Len(t, actual, 3)
+	assert.Equal(t, cubeRecords[0].OccurredAt, actual[0])
+}
+
+func TestZeroFillTimestampsWhenTooMany(t *testing.T) {
+	params := AggregatesReqParams{
+		StartTime:     time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		TimePrecision: FixedTimePrecision(time.Minute),
+		Location:      time.UTC,
+	}
+
+	_, err := ZeroFillTimestamps(params, cubeRecords)
+	assert.ErrorIs(t, err, ErrTooManyTimestamps)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Length, in bytes, of the digest used as an ETag.
+const etagDigestLength = 16
+
+// AggregatesResult is a set of rolled up aggregates, along with validators for
+// conditional requests.
+type AggregatesResult struct {
+	Aggregates []Aggregate
+	// Identifies the aggregates, changing whenever any aggregate does.
+	ETag string
+	// When aggregates within the time range were last written, which is zero
+	// if unknown.
+	ModifiedAt time.Time
+}
+
+func NewAggregatesResult(records []Aggregate, modifiedAt time.Time) AggregatesResult {
+	return AggregatesResult{
+		Aggregates: records,
+		ETag:       AggregatesETag(records),
+		ModifiedAt: modifiedAt.UTC().Truncate(time.Second),
+	}
+}
+
+// AggregatesETag hashes the aggregates, such that equal aggregates in the same
+// order have the same ETag.
+func AggregatesETag(records []Aggregate) string {
+	hash := sha256.New()
+	buff := make([]byte, 8)
+	for _, record := range records {
+		binary.BigEndian.PutUint64(buff, uint64(record.OccurredAt.UnixMicro()))
+		hash.Write(buff)
+		// Strings are null terminated, so that they can't run together.
+		hash.Write([]byte(record.Geohash))
+		hash.Write([]byte{0})
+		hash.Write([]byte(record.IncidentType))
+		hash.Write([]byte{0})
+		binary.BigEndian.PutUint32(buff, uint32(record.Count))
+		hash.Write(buff[:4])
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:etagDigestLength])
+}
+
+// RepresentationETag returns the quoted, strong ETag of the aggregates encoded
+// in the given response format.
+func RepresentationETag(etag, format string) string {
+	return fmt.Sprintf(`"%s-%s"`, etag, format)
+}
+
+// CubeRepresentation names the representation of aggregates as a cube, for use
+// in ETags. Zero filled cubes also depend on the time range filled.
+func CubeRepresentation(format string, shape ShapeParams, timestamps []time.Time) string {
+	representation := fmt.Sprintf("%s-%s-%s", format, shape.Shape, shape.Layout)
+	if len(timestamps) > 0 {
+		representation = fmt.Sprintf("%s-%d-%d", representation, timestamps[0].Unix(), timestamps[len(timestamps)-1].Unix())
+	}
+	return representation
+}
+
+// IsNotModified returns whether the request's preconditions show that the
+// client already has the representation. If-Modified-Since is only considered
+// when If-None-Match is absent.
+func IsNotModified(r *http.Request, etag string, modifiedAt time.Time) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		return matchesETag(header, etag)
+	}
+
+	header := r.Header.Get("If-Modified-Since")
+	if header == "" || modifiedAt.IsZero() {
+		return false
+	}
+
+	since, err := http.ParseTime(header)
+	if err != nil {
+		return false
+	}
+	return !modifiedAt.Truncate(time.Second).After(since)
+}
+
+// matchesETag returns whether an If-None-Match header value matches the ETag,
+// using weak comparison.
+func matchesETag(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregatesETag(t *testing.T) {
+	records := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+	}
+	changed := []Aggregate{records[0], records[1]}
+	changed[1].Count = 2
+
+	assert.Equal(t, AggregatesETag(records), AggregatesETag(append([]Aggregate{}, records...)))
+	assert.NotEqual(t, AggregatesETag(records), AggregatesETag(changed))
+	assert.NotEqual(t, AggregatesETag(records), AggregatesETag(records[:1]))
+	assert.Len(t, AggregatesETag(nil), 2*etagDigestLength)
+}
+
+func TestRepresentationETag(t *testing.T) {
+	assert.Equal(t, `"abc-geojson"`, RepresentationETag("abc", FormatGeoJSON))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// AggregatesStreamWriter writes aggregates one at a time, for streaming
+// responses.
+type AggregatesStreamWriter interface {
+	Write(Aggregate) error
+	// Flush writes any buffered data to the underlying writer.
+	Flush() error
+}
+
+var csvHeader = []string{"occurred_at", "geohash", "incident_type", "count"}
+
+// CSVAggregatesWriter writes aggregates as CSV rows, preceded by a header row.
+type CSVAggregatesWriter struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+func NewCSVAggregatesWriter(w io.Writer) *CSVAggregatesWriter {
+	return &CSVAggregatesWriter{writer: csv.NewWriter(w)}
+}
+
+func (w *CSVAggregatesWriter) writeHeader() error {
+	if w.headerWritten {
+		return nil
+	}
+	w.headerWritten = true
+	return w.writer.Write(csvHeader)
+}
+
+func (w *CSVAggregatesWriter) Write(record Aggregate) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	return w.writer.Write([]string{
+		record.OccurredAt.Format(time.RFC3339),
+		record.Geohash,
+		record.IncidentType,
+		strconv.FormatInt(int64(record.Count), 10),
+	})
+}
+
+// Flush writes any buffered rows, including the header row if no aggregates
+// were written.
+func (w *CSVAggregatesWriter) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// NDJSONAggregatesWriter writes aggregates as newline-delimited JSON objects.
+type NDJSONAggregatesWriter struct {
+	encoder *json.Encoder
+}
+
+func NewNDJSONAggregatesWriter(w io.Writer) *NDJSONAggregatesWriter {
+	return &NDJSONAggregatesWriter{encoder: json.NewEncoder(w)}
+}
+
+func (w *NDJSONAggregatesWriter) Write(record Aggregate) error {
+	return w.encoder.Encode(record)
+}
+
+func (w *NDJSONAggregatesWriter) Flush() error {
+	return nil
+}
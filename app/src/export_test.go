@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSVAggregatesWriter(t *testing.T) {
+	var buff bytes.Buffer
+	writer := NewCSVAggregatesWriter(&buff)
+
+	assert.Nil(t, writer.Write(Aggregate{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1}))
+	assert.Nil(t, writer.Write(Aggregate{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "9q8yyqc", IncidentType: IncidentTypeTrafficCrash, Count: 2}))
+	assert.Nil(t, writer.Flush())
+
+	expected := "occurred_at,geohash,incident_type,count\n" +
+		"2025-01-01T13:00:00Z,9q8yyqb,police_incident,1\n" +
+		"2025-01-01T14:00:00Z,9q8yyqc,traffic_crash,2\n"
+	assert.Equal(t, expected, buff.String())
+}
+
+func TestCSVAggregatesWriterWhenEmpty(t *testing.T) {
+	var buff bytes.Buffer
+	writer := NewCSVAggregatesWriter(&buff)
+
+	assert.Nil(t, writer.Flush())
+	assert.Equal(t, "occurred_at,geohash,incident_type,count\n", buff.String())
+}
+
+func TestNDJSONAggregatesWriter(t *testing.T) {
+	var buff bytes.Buffer
+	writer := NewNDJSONAggregatesWriter(&buff)
+
+	assert.Nil(t, writer.Write(Aggregate{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1}))
+	assert.Nil(t, writer.Write(Aggregate{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "9q8yyqc", IncidentType: IncidentTypeTrafficCrash, Count: 2}))
+	assert.Nil(t, writer.Flush())
+
+	expected := `{"occurred_at":"2025-01-01T13:00:00Z","geohash":"9q8yyqb","incident_type":"police_incident","count":1}` + "\n" +
+		`{"occurred_at":"2025-01-01T14:00:00Z","geohash":"9q8yyqc","incident_type":"traffic_crash","count":2}` + "\n"
+	assert.Equal(t, expected, buff.String())
+}
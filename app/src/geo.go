@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/mmcloughlin/geohash"
+)
+
+// MaxCoverCells is the maximum number of geohash cells used to cover a
+// bounding box.
+const MaxCoverCells = 64
+
+type BoundingBox struct {
+	MinLon float64
+	MinLat float64
+	MaxLon float64
+	MaxLat float64
+}
+
+// Valid returns whether the bounding box has valid coordinates. Bounding boxes
+// which cross the antimeridian are not supported.
+func (b BoundingBox) Valid() bool {
+	return -180 <= b.MinLon && b.MinLon <= b.MaxLon && b.MaxLon <= 180 &&
+		-90 <= b.MinLat && b.MinLat <= b.MaxLat && b.MaxLat <= 90
+}
+
+// cellSize returns the width and height, in degrees, of a geohash cell at the
+// given precision.
+func cellSize(precision int) (float64, float64) {
+	bits := 5 * precision
+	lonBits := (bits + 1) / 2
+	latBits := bits / 2
+	return 360 / math.Exp2(float64(lonBits)), 180 / math.Exp2(float64(latBits))
+}
+
+// cellIndex returns the index of the cell containing `value`, along an axis
+// starting at `origin` and divided into cells of width `size`.
+func cellIndex(value, origin, size float64) int {
+	numCells := int(math.Round(-2 * origin / size))
+	idx := int(math.Floor((value - origin) / size))
+	return min(max(idx, 0), numCells-1)
+}
+
+type cellRange struct {
+	MinCol int
+	MaxCol int
+	MinRow int
+	MaxRow int
+}
+
+func (r cellRange) Len() int {
+	return (r.MaxCol - r.MinCol + 1) * (r.MaxRow - r.MinRow + 1)
+}
+
+func coverRange(bbox BoundingBox, precision int) cellRange {
+	width, height := cellSize(precision)
+	return cellRange{
+		MinCol: cellIndex(bbox.MinLon, -180, width),
+		MaxCol: cellIndex(bbox.MaxLon, -180, width),
+		MinRow: cellIndex(bbox.MinLat, -90, height),
+		MaxRow: cellIndex(bbox.MaxLat, -90, height),
+	}
+}
+
+// CoverBoundingBox returns the sorted geohash cells which intersect the
+// bounding box. The finest precision, no greater than `maxPrecision`, at which
+// the bounding box can be covered by at most `maxCells` cells is used.
+func CoverBoundingBox(bbox BoundingBox, maxPrecision, maxCells int) []string {
+	precision := maxPrecision
+	cover := coverRange(bbox, precision)
+	for precision > 1 && cover.Len() > maxCells {
+		precision--
+		cover = coverRange(bbox, precision)
+	}
+
+	width, height := cellSize(precision)
+	cells := make([]string, 0, cover.Len())
+	for row := cover.MinRow; row <= cover.MaxRow; row++ {
+		lat := -90 + (float64(row)+0.5)*height
+		for col := cover.MinCol; col <= cover.MaxCol; col++ {
+			lon := -180 + (float64(col)+0.5)*width
+			cells = append(cells, geohash.EncodeWithPrecision(lat, lon, uint(precision)))
+		}
+	}
+
+	slices.Sort(cells)
+	return cells
+}
+
+// GeohashCells returns disjoint geohash cells which cover the intersection of
+// the bounding box and the geohash prefix, either of which may be omitted. If
+// both are omitted, nil is returned to indicate that there is no spatial
+// filter.
+func GeohashCells(bbox *BoundingBox, prefix string) []string {
+	if bbox == nil {
+		if prefix == "" {
+			return nil
+		}
+		return []string{prefix}
+	}
+
+	cells := CoverBoundingBox(*bbox, MaxGeoPrecision, MaxCoverCells)
+	if prefix == "" {
+		return cells
+	}
+
+	intersection := []string{}
+	for _, cell := range cells {
+		if strings.HasPrefix(cell, prefix) {
+			intersection = append(intersection, cell)
+		} else if strings.HasPrefix(prefix, cell) {
+			// The prefix lies within a single cell of the cover.
+			return []string{prefix}
+		}
+	}
+	return intersection
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mmcloughlin/geohash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoundingBoxValid(t *testing.T) {
+	testCases := []struct {
+		BoundingBox BoundingBox
+		Expected    bool
+	}{
+		{BoundingBox: BoundingBox{MinLon: -122.5, MinLat: 37.7, MaxLon: -122.4, MaxLat: 37.8}, Expected: true},
+		{BoundingBox: BoundingBox{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90}, Expected: true},
+		{BoundingBox: BoundingBox{MinLon: -122.4, MinLat: 37.7, MaxLon: -122.5, MaxLat: 37.8}, Expected: false},
+		{BoundingBox: BoundingBox{MinLon: -122.5, MinLat: 37.8, MaxLon: -122.4, MaxLat: 37.7}, Expected: false},
+		{BoundingBox: BoundingBox{MinLon: -181, MinLat: 37.7, MaxLon: -122.4, MaxLat: 37.8}, Expected: false},
+		{BoundingBox: BoundingBox{MinLon: -122.5, MinLat: 37.7, MaxLon: -122.4, MaxLat: 91}, Expected: false},
+	}
+	for idx, testCase := range testCases {
+		assert.Equal(t, testCase.Expected, testCase.BoundingBox.Valid(), idx)
+	}
+}
+
+func TestCoverBoundingBoxWithinCell(t *testing.T) {
+	box := geohash.BoundingBox("9q8yyqb")
+	bbox := BoundingBox{
+		MinLon: box.MinLng + 0.0001,
+		MinLat: box.MinLat + 0.0001,
+		MaxLon: box.MaxLng - 0.0001,
+		MaxLat: box.MaxLat - 0.0001,
+	}
+
+	actual := CoverBoundingBox(bbox, 7, MaxCoverCells)
+	assert.Equal(t, []string{"9q8yyqb"}, actual)
+}
+
+func TestCoverBoundingBoxAcrossCells(t *testing.T) {
+	west := geohash.BoundingBox("9q8yyqb")
+	east := geohash.BoundingBox(geohash.Neighbor("9q8yyqb", geohash.East))
+	bbox := BoundingBox{
+		MinLon: west.MinLng + 0.0001,
+		MinLat: west.MinLat + 0.0001,
+		MaxLon: east.MaxLng - 0.0001,
+		MaxLat: west.MaxLat - 0.0001,
+	}
+
+	actual := CoverBoundingBox(bbox, 7, MaxCoverCells)
+	assert.Equal(t, []string{"9q8yyqb", "9q8yyqc"}, actual)
+}
+
+func TestCoverBoundingBoxCoarsensToMaxCells(t *testing.T) {
+	bbox := BoundingBox{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90}
+
+	actual := CoverBoundingBox(bbox, 7, MaxCoverCells)
+	assert.Len(t, actual, 32)
+	for _, cell := range actual {
+		assert.Len(t, cell, 1)
+	}
+}
+
+func TestGeohashCellsWhenNoFilter(t *testing.T) {
+	assert.Nil(t, GeohashCells(nil, ""))
+}
+
+func TestGeohashCellsWhenOnlyPrefix(t *testing.T) {
+	assert.Equal(t, []string{"9q8y"}, GeohashCells(nil, "9q8y"))
+}
+
+func TestGeohashCellsWhenPrefixWithinCell(t *testing.T) {
+	box := geohash.BoundingBox("9q8yy")
+	bbox := &BoundingBox{
+		MinLon: box.MinLng + 0.0001,
+		MinLat: box.MinLat + 0.0001,
+		MaxLon: box.MaxLng - 0.0001,
+		MaxLat: box.MaxLat - 0.0001,
+	}
+
+	actual := GeohashCells(bbox, "9q8yyqb")
+	assert.Equal(t, []string{"9q8yyqb"}, actual)
+}
+
+func TestGeohashCellsWhenCellsWithinPrefix(t *testing.T) {
+	west := geohash.BoundingBox("9q8yyqb")
+	east := geohash.BoundingBox(geohash.Neighbor("9q8yyqb", geohash.East))
+	bbox := &BoundingBox{
+		MinLon: west.MinLng + 0.0001,
+		MinLat: west.MinLat + 0.0001,
+		MaxLon: east.MaxLng - 0.0001,
+		MaxLat: west.MaxLat - 0.0001,
+	}
+
+	assert.Equal(t, []string{"9q8yyqb", "9q8yyqc"}, GeohashCells(bbox, "9q8yy"))
+	assert.Equal(t, []string{"9q8yyqc"}, GeohashCells(bbox, "9q8yyqc"))
+}
+
+func TestGeohashCellsWhenDisjoint(t *testing.T) {
+	box := geohash.BoundingBox("9q8yyqb")
+	bbox := &BoundingBox{
+		MinLon: box.MinLng + 0.0001,
+		MinLat: box.MinLat + 0.0001,
+		MaxLon: box.MaxLng - 0.0001,
+		MaxLat: box.MaxLat - 0.0001,
+	}
+
+	actual := GeohashCells(bbox, "u178")
+	assert.NotNil(t, actual)
+	assert.Empty(t, actual)
+}
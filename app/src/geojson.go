@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/mmcloughlin/geohash"
+)
+
+type GeoJSONPolygon struct {
+	Type        string         `json:"type"`
+	Coordinates [][][2]float64 `json:"coordinates"`
+}
+
+type GeoJSONProperties struct {
+	OccurredAt   time.Time `json:"occurred_at"`
+	Geohash      string    `json:"geohash"`
+	IncidentType string    `json:"incident_type"`
+	Count        int32     `json:"count"`
+}
+
+type GeoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   GeoJSONPolygon    `json:"geometry"`
+	Properties GeoJSONProperties `json:"properties"`
+}
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// GeohashPolygon returns the bounds of the geohash cell as a GeoJSON polygon.
+// The exterior ring is wound counterclockwise, per RFC 7946.
+func GeohashPolygon(hash string) GeoJSONPolygon {
+	box := geohash.BoundingBox(hash)
+	ring := [][2]float64{
+		{box.MinLng, box.MinLat},
+		{box.MaxLng, box.MinLat},
+		{box.MaxLng, box.MaxLat},
+		{box.MinLng, box.MaxLat},
+		{box.MinLng, box.MinLat},
+	}
+	return GeoJSONPolygon{Type: "Polygon", Coordinates: [][][2]float64{ring}}
+}
+
+func MakeFeatureCollection(records []Aggregate) GeoJSONFeatureCollection {
+	features := make([]GeoJSONFeature, len(records))
+	for idx, record := range records {
+		features[idx] = GeoJSONFeature{
+			Type:     "Feature",
+			Geometry: GeohashPolygon(record.Geohash),
+			Properties: GeoJSONProperties{
+				OccurredAt:   record.OccurredAt,
+				Geohash:      record.Geohash,
+				IncidentType: record.IncidentType,
+				Count:        record.Count,
+			},
+		}
+	}
+	return GeoJSONFeatureCollection{Type: "FeatureCollection", Features: features}
+}
+
+// EncodeAggregatesGeoJSON writes the records as a GeoJSON FeatureCollection,
+// with one Polygon feature per geohash cell.
+func EncodeAggregatesGeoJSON(records []Aggregate, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(MakeFeatureCollection(records))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeohashPolygon(t *testing.T) {
+	expected := GeoJSONPolygon{
+		Type: "Polygon",
+		Coordinates: [][][2]float64{{
+			{-122.420654296875, 37.786102294921875},
+			{-122.41928100585938, 37.786102294921875},
+			{-122.41928100585938, 37.7874755859375},
+			{-122.420654296875, 37.7874755859375},
+			{-122.420654296875, 37.786102294921875},
+		}},
+	}
+
+	actual := GeohashPolygon("9q8yyqb")
+	assert.Equal(t, expected, actual)
+}
+
+func TestEncodeAggregatesGeoJSON(t *testing.T) {
+	records := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypeFireIncident, Count: 2},
+	}
+
+	var buff bytes.Buffer
+	err := EncodeAggregatesGeoJSON(records, &buff)
+	require.Nil(t, err)
+
+	var actual GeoJSONFeatureCollection
+	err = json.Unmarshal(buff.Bytes(), &actual)
+	require.Nil(t, err)
+
+	assert.Equal(t, "FeatureCollection", actual.Type)
+	require.Len(t, actual.Features, 1)
+
+	feature := actual.Features[0]
+	assert.Equal(t, "Feature", feature.Type)
+	assert.Equal(t, GeohashPolygon("9q8yyqb"), feature.Geometry)
+	assert.Equal(t, GeoJSONProperties{
+		OccurredAt:   time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC),
+		Geohash:      "9q8yyqb",
+		IncidentType: IncidentTypeFireIncident,
+		Count:        2,
+	}, feature.Properties)
+}
+
+func TestEncodeAggregatesGeoJSONWhenEmpty(t *testing.T) {
+	var buff bytes.Buffer
+	err := EncodeAggregatesGeoJSON([]Aggregate{}, &buff)
+	require.Nil(t, err)
+	assert.JSONEq(t, `{"type": "FeatureCollection", "features": []}`, buff.String())
+}
@@ -2,13 +2,126 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
-func MakeGetAggregatesHandler(ctx context.Context, service *AggregatesService) func(http.ResponseWriter, *http.Request) {
+// ErrorResponse is the body of error responses which explain the error.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	// Estimated and maximum cost of over-budget queries, or size of oversized
+	// cubes.
+	Cost    int64 `json:"cost,omitempty"`
+	MaxCost int64 `json:"max_cost,omitempty"`
+}
+
+// WriteError writes the error response as JSON, with the given status code.
+func WriteError(w http.ResponseWriter, status int, response ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Unable to write error response", "error", err)
+	}
+}
+
+// WriteAggregates sets the content type for, and writes, the records in the
+// given response format.
+func WriteAggregates(w http.ResponseWriter, format string, records []Aggregate) error {
+	var encode func([]Aggregate, io.Writer) error
+	switch format {
+	case FormatGeoJSON:
+		w.Header().Set("Content-Type", "application/geo+json")
+		encode = EncodeAggregatesGeoJSON
+	case FormatProtobuf:
+		w.Header().Set("Content-Type", "application/protobuf")
+		encode = EncodeAggregatesProtobuf
+	case FormatArrow:
+		w.Header().Set("Content-Type", "application/vnd.apache.arrow.stream")
+		encode = EncodeAggregatesArrow
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		encode = EncodeAggregates
+	}
+
+	return encode(records, w)
+}
+
+// WriteCube sets the content type for, and writes, the cube as JSON.
+func WriteCube(w http.ResponseWriter, cube Cube) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(cube)
+}
+
+// writeTracker records whether any of the response has been written, and its
+// status code.
+type writeTracker struct {
+	http.ResponseWriter
+	written bool
+	status  int
+}
+
+func (w *writeTracker) WriteHeader(status int) {
+	if !w.written {
+		w.status = status
+	}
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *writeTracker) Write(data []byte) (int, error) {
+	if !w.written {
+		w.status = http.StatusOK
+	}
+	w.written = true
+	return w.ResponseWriter.Write(data)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (w *writeTracker) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// WriteAggregatesStream sets headers for, and streams, aggregates as a file
+// download in the given streaming format.
+func WriteAggregatesStream(ctx context.Context, w http.ResponseWriter, service *AggregatesService, format string, params AggregatesReqParams) error {
+	var writer AggregatesStreamWriter
+	switch format {
+	case FormatCSV:
+		w.Header().Set("Content-Type", "text/csv")
+		writer = NewCSVAggregatesWriter(w)
+	default:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		writer = NewNDJSONAggregatesWriter(w)
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="aggregates.%s"`, format))
+
+	// Exports last as long as the client reads, so aren't bounded by the
+	// server's write timeout. Writers without deadlines are left as they are.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	if err := service.StreamAggregates(ctx, params, writer.Write); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// MakeGetAggregatesHandler makes the handler for reading aggregates. Queries
+// whose estimated cost is over the limit are rejected, or coarsened if the
+// client opts in, unless they are streamed. If the limit is nil, queries are
+// not limited.
+func MakeGetAggregatesHandler(service *AggregatesService, costLimit *QueryCostLimit) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
 		query, err := url.ParseQuery(r.URL.RawQuery)
 		if err != nil {
 			w.WriteHeader(http.StatusUnprocessableEntity)
@@ -21,32 +134,208 @@ func MakeGetAggregatesHandler(ctx context.Context, service *AggregatesService) f
 			return
 		}
 
-		records, err := service.GetAggregates(ctx, params)
+		format, err := NegotiateFormat(query, r.Header.Get("Accept"))
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
+		// Cubes are only encoded as JSON.
+		shape, err := GetShapeParams(query)
+		if err != nil || shape.Shape == ShapeCube && format != FormatJSON {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
+		coarsen, err := GetCoarsen(query)
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
+		// Exports are streamed, so that large queries are not buffered, and are
+		// allowed a larger budget.
+		if costLimit != nil {
+			limit := costLimit.ForFormat(format)
+			if cost := limit.Estimate(params); cost > limit.MaxCost {
+				coarsened, ok := limit.Coarsen(params)
+				if !coarsen || !ok {
+					WriteError(w, http.StatusUnprocessableEntity, QueryCostError(cost, limit.MaxCost))
+					return
+				}
+				params = coarsened
+			}
+		}
+
+		// Cubes are checked against their limits before querying, but as their
+		// size is estimated, are checked again once rolled up.
+		if shape.Shape == ShapeCube && costLimit != nil {
+			if size, err := EstimateCubeSize(*costLimit, params, shape); err != nil {
+				WriteError(w, http.StatusUnprocessableEntity, CubeSizeError(err, size))
+				return
+			}
+		}
+
+		// Precisions may differ from those requested, if coarsened.
+		w.Header().Set("X-Time-Precision", TimePrecisionName(params.TimePrecision))
+		w.Header().Set("X-Geo-Precision", strconv.Itoa(params.GeoPrecision))
+		trace.SpanFromContext(ctx).SetAttributes(paramsAttributes(params)...)
+
+		if IsStreamingFormat(format) {
+			tracker := &writeTracker{ResponseWriter: w}
+			if err := WriteAggregatesStream(ctx, tracker, service, format, params); err != nil {
+				slog.Error("Unable to stream response data", "error", err)
+				if !tracker.written {
+					w.Header().Del("Content-Disposition")
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				// The response has been sent in part with a 200 status, so the
+				// connection is aborted for the client to see the export fail,
+				// rather than receive it truncated.
+				panic(http.ErrAbortHandler)
+			}
+			return
+		}
+
+		result, err := service.GetAggregates(ctx, params)
 		if err != nil {
 			slog.Error("Unable to fetch data", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		if err := EncodeAggregates(records, w); err != nil {
+		representation := format
+		var cube Cube
+		if shape.Shape == ShapeCube {
+			var timestamps []time.Time
+			if shape.ZeroFill {
+				timestamps, err = ZeroFillTimestamps(params, result.Aggregates)
+				if err != nil {
+					WriteError(w, http.StatusUnprocessableEntity, CubeSizeError(err, 0))
+					return
+				}
+			}
+
+			cube, err = RollupCube(result.Aggregates, shape.Layout, timestamps)
+			if err != nil {
+				WriteError(w, http.StatusUnprocessableEntity, CubeSizeError(err, 0))
+				return
+			}
+			representation = CubeRepresentation(format, shape, timestamps)
+		}
+
+		// Clients may keep responses, but must revalidate them before reuse, as
+		// aggregates change as incidents are recorded.
+		etag := RepresentationETag(result.ETag, representation)
+		w.Header().Set("ETag", etag)
+		if !result.ModifiedAt.IsZero() {
+			w.Header().Set("Last-Modified", result.ModifiedAt.UTC().Format(http.TimeFormat))
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Vary", "Accept")
+
+		if IsNotModified(r, etag, result.ModifiedAt) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		if shape.Shape == ShapeCube {
+			err = WriteCube(w, cube)
+		} else {
+			err = WriteAggregates(w, format, result.Aggregates)
+		}
+		if err != nil {
 			slog.Error("Unable to encode response data", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
 		return
 	}
 }
 
-func MakeInsertAggregatesHandler(ctx context.Context, service *AggregatesService) func(http.ResponseWriter, *http.Request) {
+// MakeGetTileHandler makes the handler for reading vector tiles. As tiles have
+// fixed precisions, queries whose estimated cost is over the limit are
+// rejected. If the limit is nil, queries are not limited.
+func MakeGetTileHandler(service *AggregatesService, costLimit *QueryCostLimit) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		records, err := DecodeAggregatesFromReader(r.Body)
+		ctx := r.Context()
+
+		tile, err := ParseTile(r.PathValue("z"), r.PathValue("x"), r.PathValue("y"))
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
+		query, err := url.ParseQuery(r.URL.RawQuery)
 		if err != nil {
 			w.WriteHeader(http.StatusUnprocessableEntity)
 			return
 		}
 
+		params, err := GetTileReqParams(tile, query)
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
+		if costLimit != nil {
+			if cost := costLimit.Estimate(params); cost > costLimit.MaxCost {
+				WriteError(w, http.StatusUnprocessableEntity, QueryCostError(cost, costLimit.MaxCost))
+				return
+			}
+		}
+		trace.SpanFromContext(ctx).SetAttributes(paramsAttributes(params)...)
+
+		result, err := service.GetAggregates(ctx, params)
+		if err != nil {
+			slog.Error("Unable to fetch data", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		data, err := EncodeTile(tile, SumByCell(result.Aggregates))
+		if err != nil {
+			slog.Error("Unable to encode tile", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+		if _, err := w.Write(data); err != nil {
+			slog.Error("Unable to write response data", "error", err)
+		}
+		return
+	}
+}
+
+// DecodeAggregatesRequest decodes aggregates from the request body, writing an
+// error response if the body is larger than maxBodyBytes, with a 413, or
+// invalid, with a 422.
+func DecodeAggregatesRequest(w http.ResponseWriter, r *http.Request, maxBodyBytes int64) ([]Aggregate, error) {
+	records, err := DecodeAggregatesFromReader(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return nil, err
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return nil, err
+	}
+	return records, nil
+}
+
+func MakeInsertAggregatesHandler(service *AggregatesService, maxBodyBytes int64) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		records, err := DecodeAggregatesRequest(w, r, maxBodyBytes)
+		if err != nil {
+			return
+		}
+
 		if err := service.InsertAggregates(ctx, records); err != nil {
 			slog.Error("Unable to write records", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -57,11 +346,12 @@ func MakeInsertAggregatesHandler(ctx context.Context, service *AggregatesService
 	}
 }
 
-func MakeUpsertAggregatesHandler(ctx context.Context, service *AggregatesService) func(http.ResponseWriter, *http.Request) {
+func MakeUpsertAggregatesHandler(service *AggregatesService, maxBodyBytes int64) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		records, err := DecodeAggregatesFromReader(r.Body)
+		ctx := r.Context()
+
+		records, err := DecodeAggregatesRequest(w, r, maxBodyBytes)
 		if err != nil {
-			w.WriteHeader(http.StatusUnprocessableEntity)
 			return
 		}
 
@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -14,6 +16,7 @@ import (
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/paulmach/orb/encoding/mvt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -46,25 +49,41 @@ func (suite *HandlersTestSuite) TearDownSuite() {
 	}
 }
 
+// WriteTestData inserts records as the app does, so that rollup tables are
+// kept up to date.
 func WriteTestData(ctx context.Context, conn *pgxpool.Pool, records []AggregateRow) error {
-	for _, record := range records {
-		if _, err := conn.Exec(
-			ctx,
-			"insert into aggregate_buckets (occurred_at, geo_id, incident_count) values ($1, $2, $3)",
-			record.OccurredAt,
-			record.Geohash,
-			record.Count,
-		); err != nil {
+	repo := &Repo{conn: conn}
+	return repo.InsertAggregateRows(ctx, records)
+}
+
+func DeleteTestData(ctx context.Context, conn *pgxpool.Pool) error {
+	for _, table := range append([]string{"aggregate_buckets"}, rollupTableNames()...) {
+		if _, err := conn.Exec(ctx, "delete from "+pgx.Identifier{table}.Sanitize()); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
-func DeleteTestData(ctx context.Context, conn *pgxpool.Pool) error {
-	_, err := conn.Exec(ctx, "delete from aggregate_buckets")
-	return err
+func rollupTableNames() []string {
+	names := make([]string, len(RollupTables))
+	for idx, table := range RollupTables {
+		names[idx] = table.Name
+	}
+	return names
+}
+
+func ReadRollupTable(ctx context.Context, conn *pgxpool.Pool, table RollupTable) ([]AggregateRow, error) {
+	rows, err := conn.Query(
+		ctx,
+		"select occurred_at, geo_id, incident_type, incident_count from "+table.Identifier()+" order by occurred_at, geo_id, incident_type",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[AggregateRow])
 }
 
 func (suite *HandlersTestSuite) TestGetAggregatesHandler() {
@@ -80,63 +99,133 @@ func (suite *HandlersTestSuite) TestGetAggregatesHandler() {
 			// No filtering or further aggregation.
 			RequestURL: "/aggregates",
 			Records: []AggregateRow{
-				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcdefg", Count: 1},
-				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcdefg", Count: 1},
-				{OccurredAt: time.Date(2025, 1, 3, 4, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 2},
-				{OccurredAt: time.Date(2025, 1, 7, 15, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 3},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident, Count: 1},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident, Count: 1},
+				{OccurredAt: time.Date(2025, 1, 3, 4, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident, Count: 2},
+				{OccurredAt: time.Date(2025, 1, 7, 15, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident, Count: 3},
 			},
 			Expected: []Aggregate{
-				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcdefg", Count: 2},
-				{OccurredAt: time.Date(2025, 1, 3, 4, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 2},
-				{OccurredAt: time.Date(2025, 1, 7, 15, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 3},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident, Count: 2},
+				{OccurredAt: time.Date(2025, 1, 3, 4, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident, Count: 2},
+				{OccurredAt: time.Date(2025, 1, 7, 15, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident, Count: 3},
 			},
 		}, {
 			// Filter to a time window.
 			RequestURL: "/aggregates?start_time=2025-01-02T00:00Z&end_time=2025-01-06T00:00Z",
 			Records: []AggregateRow{
-				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcdefg", Count: 1},
-				{OccurredAt: time.Date(2025, 1, 3, 4, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 2},
-				{OccurredAt: time.Date(2025, 1, 3, 4, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 2},
-				{OccurredAt: time.Date(2025, 1, 7, 15, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 3},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident, Count: 1},
+				{OccurredAt: time.Date(2025, 1, 3, 4, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident, Count: 2},
+				{OccurredAt: time.Date(2025, 1, 3, 4, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident, Count: 2},
+				{OccurredAt: time.Date(2025, 1, 7, 15, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident, Count: 3},
 			},
 			Expected: []Aggregate{
-				{OccurredAt: time.Date(2025, 1, 3, 4, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 4},
+				{OccurredAt: time.Date(2025, 1, 3, 4, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident, Count: 4},
 			},
 		}, {
 			// Rollup spatial dimension.
 			RequestURL: "/aggregates?geo_precision=6",
 			Records: []AggregateRow{
-				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde11", Count: 1},
-				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde12", Count: 1},
-				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde21", Count: 1},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde12", IncidentType: IncidentTypePoliceIncident, Count: 1},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde21", IncidentType: IncidentTypePoliceIncident, Count: 1},
 			},
 			Expected: []Aggregate{
-				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde1", Count: 2},
-				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde2", Count: 1},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde1", IncidentType: IncidentTypePoliceIncident, Count: 2},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde2", IncidentType: IncidentTypePoliceIncident, Count: 1},
 			},
 		}, {
 			// Rollup temporal dimension.
 			RequestURL: "/aggregates?time_precision=1h",
 			Records: []AggregateRow{
-				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde11", Count: 1},
-				{OccurredAt: time.Date(2025, 1, 1, 13, 8, 0, 0, time.UTC), Geohash: "abcde12", Count: 3},
-				{OccurredAt: time.Date(2025, 1, 1, 13, 9, 0, 0, time.UTC), Geohash: "abcde11", Count: 1},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 8, 0, 0, time.UTC), Geohash: "abcde12", IncidentType: IncidentTypePoliceIncident, Count: 3},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 9, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
 			},
 			Expected: []Aggregate{
-				{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcde11", Count: 2},
-				{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcde12", Count: 3},
+				{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 2},
+				{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcde12", IncidentType: IncidentTypePoliceIncident, Count: 3},
 			},
 		}, {
 			// Rollup spatial and temporal dimensions.
 			RequestURL: "/aggregates?time_precision=1h&geo_precision=6",
 			Records: []AggregateRow{
-				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde11", Count: 1},
-				{OccurredAt: time.Date(2025, 1, 1, 13, 8, 0, 0, time.UTC), Geohash: "abcde12", Count: 2},
-				{OccurredAt: time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC), Geohash: "abcde11", Count: 1},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 8, 0, 0, time.UTC), Geohash: "abcde12", IncidentType: IncidentTypePoliceIncident, Count: 2},
+				{OccurredAt: time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
 			},
 			Expected: []Aggregate{
-				{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcde1", Count: 3},
-				{OccurredAt: time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC), Geohash: "abcde1", Count: 1},
+				{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcde1", IncidentType: IncidentTypePoliceIncident, Count: 3},
+				{OccurredAt: time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC), Geohash: "abcde1", IncidentType: IncidentTypePoliceIncident, Count: 1},
+			},
+		}, {
+			// Rollup temporal dimension to local days.
+			RequestURL: "/aggregates?time_precision=24h&tz=America/Los_Angeles",
+			Records: []AggregateRow{
+				{OccurredAt: time.Date(2025, 1, 1, 7, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
+				{OccurredAt: time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 2},
+				{OccurredAt: time.Date(2025, 1, 2, 7, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 3},
+			},
+			Expected: []Aggregate{
+				{OccurredAt: time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
+				{OccurredAt: time.Date(2025, 1, 2, 8, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 5},
+			},
+		}, {
+			// Rollup temporal dimension to calendar months.
+			RequestURL: "/aggregates?time_precision=1mo&tz=UTC",
+			Records: []AggregateRow{
+				{OccurredAt: time.Date(2025, 1, 1, 7, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
+				{OccurredAt: time.Date(2025, 1, 31, 9, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 2},
+				{OccurredAt: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 3},
+				{OccurredAt: time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 4},
+			},
+			Expected: []Aggregate{
+				{OccurredAt: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 6},
+				{OccurredAt: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 4},
+			},
+		}, {
+			// Rollup from the daily rollup table, with rows at the edges of the
+			// time window from the finest-grained table.
+			RequestURL: "/aggregates?start_time=2025-01-01T12:00Z&end_time=2025-01-03T08:00Z&time_precision=1w&geo_precision=4&tz=America/Los_Angeles",
+			Records: []AggregateRow{
+				{OccurredAt: time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 2},
+				{OccurredAt: time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC), Geohash: "abcdf11", IncidentType: IncidentTypePoliceIncident, Count: 3},
+				{OccurredAt: time.Date(2025, 1, 3, 9, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 4},
+			},
+			Expected: []Aggregate{
+				{OccurredAt: time.Date(2025, 1, 6, 8, 0, 0, 0, time.UTC), Geohash: "abcd", IncidentType: IncidentTypePoliceIncident, Count: 5},
+			},
+		}, {
+			// Filter to incident types.
+			RequestURL: "/aggregates?incident_types=police_incident,traffic_crash",
+			Records: []AggregateRow{
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident, Count: 1},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeTrafficCrash, Count: 2},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentType311Case, Count: 3},
+			},
+			Expected: []Aggregate{
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident, Count: 1},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeTrafficCrash, Count: 2},
+			},
+		}, {
+			// Filter to a geohash prefix.
+			RequestURL: "/aggregates?geohash_prefix=9q8yy",
+			Records: []AggregateRow{
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "9q8yzqb", IncidentType: IncidentTypePoliceIncident, Count: 2},
+			},
+			Expected: []Aggregate{
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
+			},
+		}, {
+			// Filter to a bounding box, within geohash cell 9q8yyqb.
+			RequestURL: "/aggregates?bbox=-122.4204,37.7862,-122.4196,37.7870",
+			Records: []AggregateRow{
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "9q8yyqc", IncidentType: IncidentTypePoliceIncident, Count: 2},
+			},
+			Expected: []Aggregate{
+				{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
 			},
 		},
 	}
@@ -145,11 +234,14 @@ func (suite *HandlersTestSuite) TestGetAggregatesHandler() {
 		WriteTestData(context.Background(), suite.Conn, testCase.Records)
 
 		cache := new(mockCache)
-		cache.On("Get", mock.Anything, mock.Anything).Return([]Aggregate{}, ErrNoSuchKey)
-		cache.On("Set", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		cache.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+		cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil)
+		cache.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		cache.On("GetMany", mock.Anything, mock.Anything).Return(nil, nil, nil)
+		cache.On("SetMany", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 		service := NewAggregatesService(repo, cache)
-		handler := MakeGetAggregatesHandler(context.Background(), service)
+		handler := MakeGetAggregatesHandler(service, nil)
 
 		req := httptest.NewRequest(http.MethodGet, testCase.RequestURL, nil)
 		w := httptest.NewRecorder()
@@ -173,26 +265,536 @@ func (suite *HandlersTestSuite) TestGetAggregatesHandler() {
 	}
 }
 
+func TestGetAggregatesHandlerWhenGeoJSON(t *testing.T) {
+	rows := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
+	}
+
+	repo := new(mockRepo)
+	repo.On("GetAggregateRows", mock.Anything, mock.Anything).Return(rows, nil)
+
+	cache := new(mockCache)
+	cache.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+	cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil)
+	cache.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	service := NewAggregatesService(repo, cache)
+	handler := MakeGetAggregatesHandler(service, nil)
+
+	for _, testCase := range []struct {
+		RequestURL string
+		Accept     string
+	}{
+		{RequestURL: "/aggregates?format=geojson", Accept: ""},
+		{RequestURL: "/aggregates", Accept: "application/geo+json"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, testCase.RequestURL, nil)
+		req.Header.Set("Accept", testCase.Accept)
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		result := w.Result()
+		defer result.Body.Close()
+
+		require.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "application/geo+json", result.Header.Get("Content-Type"))
+
+		var actual GeoJSONFeatureCollection
+		err := json.NewDecoder(result.Body).Decode(&actual)
+		require.Nil(t, err)
+		require.Len(t, actual.Features, 1)
+		assert.Equal(t, GeohashPolygon("9q8yyqb"), actual.Features[0].Geometry)
+		assert.Equal(t, int32(1), actual.Features[0].Properties.Count)
+	}
+}
+
+func TestGetAggregatesHandlerWhenConditional(t *testing.T) {
+	records := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
+	}
+	cached := NewAggregatesResult(records, time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC))
+	etag := RepresentationETag(cached.ETag, FormatJSON)
+
+	repo := new(mockRepo)
+
+	cache := new(mockCache)
+	cache.On("Get", mock.Anything, mock.Anything).Return(cached, nil)
+
+	service := NewAggregatesService(repo, cache)
+	handler := MakeGetAggregatesHandler(service, nil)
+
+	for _, testCase := range []struct {
+		Name           string
+		Header         string
+		Value          string
+		ExpectedStatus int
+	}{
+		{Name: "Unconditional", ExpectedStatus: http.StatusOK},
+		{Name: "Matching ETag", Header: "If-None-Match", Value: etag, ExpectedStatus: http.StatusNotModified},
+		{Name: "Matching weak ETag", Header: "If-None-Match", Value: `"other", W/` + etag, ExpectedStatus: http.StatusNotModified},
+		{Name: "Other ETag", Header: "If-None-Match", Value: `"other"`, ExpectedStatus: http.StatusOK},
+		{Name: "Not modified since", Header: "If-Modified-Since", Value: "Wed, 01 Jan 2025 14:00:00 GMT", ExpectedStatus: http.StatusNotModified},
+		{Name: "Modified since", Header: "If-Modified-Since", Value: "Wed, 01 Jan 2025 13:59:59 GMT", ExpectedStatus: http.StatusOK},
+	} {
+		t.Run(testCase.Name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/aggregates", nil)
+			if testCase.Header != "" {
+				req.Header.Set(testCase.Header, testCase.Value)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			result := w.Result()
+			defer result.Body.Close()
+
+			assert.Equal(t, testCase.ExpectedStatus, result.StatusCode)
+			assert.Equal(t, etag, result.Header.Get("ETag"))
+			assert.Equal(t, "Wed, 01 Jan 2025 14:00:00 GMT", result.Header.Get("Last-Modified"))
+			assert.Equal(t, "no-cache", result.Header.Get("Cache-Control"))
+
+			body, err := io.ReadAll(result.Body)
+			require.Nil(t, err)
+			assert.Equal(t, testCase.ExpectedStatus == http.StatusOK, len(body) > 0)
+		})
+	}
+}
+
+func TestGetAggregatesHandlerWhenModifiedAtUnknown(t *testing.T) {
+	records := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
+	}
+
+	repo := new(mockRepo)
+
+	cache := new(mockCache)
+	cache.On("Get", mock.Anything, mock.Anything).Return(NewAggregatesResult(records, time.Time{}), nil)
+
+	service := NewAggregatesService(repo, cache)
+	handler := MakeGetAggregatesHandler(service, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/aggregates", nil)
+	req.Header.Set("If-Modified-Since", "Wed, 01 Jan 2025 14:00:00 GMT")
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	result := w.Result()
+	defer result.Body.Close()
+
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.NotEmpty(t, result.Header.Get("ETag"))
+	assert.Empty(t, result.Header.Get("Last-Modified"))
+}
+
+func TestGetAggregatesHandlerWhenCube(t *testing.T) {
+	records := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypeFireIncident, Count: 2},
+	}
+
+	repo := new(mockRepo)
+
+	cache := new(mockCache)
+	cache.On("Get", mock.Anything, mock.Anything).Return(NewAggregatesResult(records, time.Now()), nil)
+
+	service := NewAggregatesService(repo, cache)
+	handler := MakeGetAggregatesHandler(service, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/aggregates?shape=cube", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	result := w.Result()
+	defer result.Body.Close()
+
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "application/json", result.Header.Get("Content-Type"))
+
+	var actual struct {
+		Timestamps []time.Time `json:"timestamps"`
+		Geohashes  []string    `json:"geohashes"`
+		Counts     [][]int32   `json:"counts"`
+	}
+	err := json.NewDecoder(result.Body).Decode(&actual)
+	require.Nil(t, err)
+	assert.Equal(t, []time.Time{records[0].OccurredAt}, actual.Timestamps)
+	assert.Equal(t, []string{"9q8yyqb"}, actual.Geohashes)
+	assert.Equal(t, [][]int32{{3}}, actual.Counts)
+}
+
+func TestGetAggregatesHandlerWhenInvalidShape(t *testing.T) {
+	service := NewAggregatesService(new(mockRepo), new(mockCache))
+	handler := MakeGetAggregatesHandler(service, nil)
+
+	for _, requestURL := range []string{"/aggregates?shape=wide", "/aggregates?shape=cube&format=geojson"} {
+		req := httptest.NewRequest(http.MethodGet, requestURL, nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Result().StatusCode, requestURL)
+	}
+}
+
+func TestGetAggregatesHandlerWhenOverCostLimit(t *testing.T) {
+	service := NewAggregatesService(new(mockRepo), new(mockCache))
+	limit := testQueryCostLimit
+	limit.MaxCost = 1000
+	handler := MakeGetAggregatesHandler(service, &limit)
+
+	req := httptest.NewRequest(http.MethodGet, "/aggregates?start_time=2025-01-01T00:00Z&end_time=2025-01-02T00:00Z&geohash_prefix=9q8yy&coarsen=false", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	result := w.Result()
+	defer result.Body.Close()
+
+	require.Equal(t, http.StatusUnprocessableEntity, result.StatusCode)
+	assert.Equal(t, "application/json", result.Header.Get("Content-Type"))
+
+	var actual ErrorResponse
+	err := json.NewDecoder(result.Body).Decode(&actual)
+	require.Nil(t, err)
+	assert.Equal(t, int64(1441*1024), actual.Cost)
+	assert.Equal(t, int64(1000), actual.MaxCost)
+	assert.NotEmpty(t, actual.Error)
+}
+
+func TestGetAggregatesHandlerWhenCubeTooLarge(t *testing.T) {
+	repo := new(mockRepo)
+	cache := new(mockCache)
+	service := NewAggregatesService(repo, cache)
+	limit := testQueryCostLimit
+	limit.MaxCost = 100_000_000
+	handler := MakeGetAggregatesHandler(service, &limit)
+
+	req := httptest.NewRequest(http.MethodGet, "/aggregates?start_time=2025-01-01T00:00Z&end_time=2025-01-11T00:00Z&geohash_prefix=9q8yy&shape=cube", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	result := w.Result()
+	defer result.Body.Close()
+
+	require.Equal(t, http.StatusUnprocessableEntity, result.StatusCode)
+
+	var actual ErrorResponse
+	err := json.NewDecoder(result.Body).Decode(&actual)
+	require.Nil(t, err)
+	assert.Equal(t, int64(14401*1024), actual.Cost)
+	assert.Equal(t, int64(MaxDenseCubeCells), actual.MaxCost)
+	assert.Contains(t, actual.Error, "layout=csr")
+	// The query isn't run.
+	cache.AssertNotCalled(t, "Get")
+	repo.AssertNotCalled(t, "GetAggregateRows")
+}
+
+func TestGetAggregatesHandlerWhenExportOverCostLimit(t *testing.T) {
+	service := NewAggregatesService(new(mockRepo), new(mockCache))
+	limit := testQueryCostLimit
+	limit.MaxCost = 1000
+	limit.MaxExportCost = 10000
+	handler := MakeGetAggregatesHandler(service, &limit)
+
+	req := httptest.NewRequest(http.MethodGet, "/aggregates?start_time=2025-01-01T00:00Z&end_time=2025-01-02T00:00Z&geohash_prefix=9q8yy&format=csv", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	result := w.Result()
+	defer result.Body.Close()
+
+	require.Equal(t, http.StatusUnprocessableEntity, result.StatusCode)
+	assert.Empty(t, result.Header.Get("Content-Disposition"))
+
+	var actual ErrorResponse
+	err := json.NewDecoder(result.Body).Decode(&actual)
+	require.Nil(t, err)
+	assert.Equal(t, int64(1441*1024), actual.Cost)
+	assert.Equal(t, int64(10000), actual.MaxCost)
+}
+
+func TestGetAggregatesHandlerWhenCoarsened(t *testing.T) {
+	records := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Geohash: "9q8yyq", IncidentType: IncidentTypePoliceIncident, Count: 1},
+	}
+
+	cache := new(mockCache)
+	cache.On("Get", mock.Anything, mock.MatchedBy(func(params AggregatesReqParams) bool {
+		return params.TimePrecision == FixedTimePrecision(time.Hour) && params.GeoPrecision == 6
+	})).Return(NewAggregatesResult(records, time.Now()), nil)
+
+	service := NewAggregatesService(new(mockRepo), cache)
+	limit := testQueryCostLimit
+	limit.MaxCost = 500
+	handler := MakeGetAggregatesHandler(service, &limit)
+
+	req := httptest.NewRequest(http.MethodGet, "/aggregates?start_time=2025-01-01T00:00Z&end_time=2025-01-01T12:00Z&geohash_prefix=9q8yy&tz=UTC&coarsen=true", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	result := w.Result()
+	defer result.Body.Close()
+
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "1h", result.Header.Get("X-Time-Precision"))
+	assert.Equal(t, "6", result.Header.Get("X-Geo-Precision"))
+}
+
+func TestGetAggregatesHandlerWhenStreamingFormat(t *testing.T) {
+	rows := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
+	}
+
+	repo := new(mockRepo)
+	repo.On("StreamAggregateRows", mock.Anything, mock.Anything, mock.Anything).Return(rows, nil)
+
+	service := NewAggregatesService(repo, new(mockCache))
+	handler := MakeGetAggregatesHandler(service, nil)
+
+	testCases := []struct {
+		RequestURL          string
+		Accept              string
+		ExpectedType        string
+		ExpectedDisposition string
+		ExpectedBody        string
+	}{
+		{
+			RequestURL:          "/aggregates?format=csv",
+			ExpectedType:        "text/csv",
+			ExpectedDisposition: `attachment; filename="aggregates.csv"`,
+			ExpectedBody:        "occurred_at,geohash,incident_type,count\n2025-01-01T13:00:00Z,9q8yyqb,police_incident,1\n",
+		}, {
+			RequestURL:          "/aggregates",
+			Accept:              "application/x-ndjson",
+			ExpectedType:        "application/x-ndjson",
+			ExpectedDisposition: `attachment; filename="aggregates.ndjson"`,
+			ExpectedBody:        `{"occurred_at":"2025-01-01T13:00:00Z","geohash":"9q8yyqb","incident_type":"police_incident","count":1}` + "\n",
+		},
+	}
+	for idx, testCase := range testCases {
+		req := httptest.NewRequest(http.MethodGet, testCase.RequestURL, nil)
+		req.Header.Set("Accept", testCase.Accept)
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		result := w.Result()
+		defer result.Body.Close()
+
+		require.Equal(t, http.StatusOK, result.StatusCode, idx)
+		assert.Equal(t, testCase.ExpectedType, result.Header.Get("Content-Type"), idx)
+		assert.Equal(t, testCase.ExpectedDisposition, result.Header.Get("Content-Disposition"), idx)
+
+		data, err := io.ReadAll(result.Body)
+		require.Nil(t, err)
+		assert.Equal(t, testCase.ExpectedBody, string(data), idx)
+	}
+}
+
+func TestGetAggregatesHandlerWhenStreamingFails(t *testing.T) {
+	// Aggregates are written once their time bucket is complete.
+	rows := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
+	}
+	streamErr := errors.New("Error")
+
+	// Nothing has been sent, so the failure is reported with a status code.
+	repo := new(mockRepo)
+	repo.On("StreamAggregateRows", mock.Anything, mock.Anything, mock.Anything).Return([]AggregateRow{}, streamErr)
+	handler := MakeGetAggregatesHandler(NewAggregatesService(repo, new(mockCache)), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/aggregates?format=ndjson", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+	assert.Empty(t, w.Result().Header.Get("Content-Disposition"))
+
+	// Rows have been sent, so the response is aborted.
+	repo = new(mockRepo)
+	repo.On("StreamAggregateRows", mock.Anything, mock.Anything, mock.Anything).Return(rows, streamErr)
+	handler = MakeGetAggregatesHandler(NewAggregatesService(repo, new(mockCache)), nil)
+
+	req = httptest.NewRequest(http.MethodGet, "/aggregates?format=ndjson&tz=UTC", nil)
+	w = httptest.NewRecorder()
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() { handler(w, req) })
+}
+
+func TestGetAggregatesHandlerWhenInvalidFormat(t *testing.T) {
+	service := NewAggregatesService(new(mockRepo), new(mockCache))
+	handler := MakeGetAggregatesHandler(service, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/aggregates?format=xml", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Result().StatusCode)
+}
+
+func TestGetAggregatesHandlerWhenRequestCancelled(t *testing.T) {
+	repo := new(mockRollupRepo)
+	repo.On("RollupAggregateRows", mock.Anything, mock.Anything, mock.Anything).Return([]AggregateRow{}, nil).Maybe()
+
+	cache := new(mockCache)
+	cache.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+	cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil).Maybe()
+	cache.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	service := NewAggregatesService(repo, cache)
+	handler := MakeGetAggregatesHandler(service, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/aggregates?end_time=2025-01-01T12:00Z&tz=UTC", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+	cache.AssertCalled(t, "Get", ctx, mock.Anything)
+}
+
+func TestGetTileHandler(t *testing.T) {
+	rows := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
+	}
+
+	repo := new(mockRepo)
+	repo.On("GetAggregateRows", mock.Anything, mock.Anything).Return(rows, nil)
+
+	cache := new(mockCache)
+	cache.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+	cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil)
+	cache.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	service := NewAggregatesService(repo, cache)
+	handler := MakeGetTileHandler(service, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/tiles/13/1310/3166.mvt?start_time=2025-01-01T00:00Z", nil)
+	req.SetPathValue("z", "13")
+	req.SetPathValue("x", "1310")
+	req.SetPathValue("y", "3166.mvt")
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	result := w.Result()
+	defer result.Body.Close()
+
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "application/vnd.mapbox-vector-tile", result.Header.Get("Content-Type"))
+
+	data, err := io.ReadAll(result.Body)
+	require.Nil(t, err)
+
+	layers, err := mvt.Unmarshal(data)
+	require.Nil(t, err)
+	require.Len(t, layers, 1)
+	require.Len(t, layers[0].Features, 1)
+	assert.Equal(t, "9q8yyqb", layers[0].Features[0].Properties["geohash"])
+
+	params := cache.Calls[0].Arguments.Get(1).(AggregatesReqParams)
+	assert.Equal(t, TileGeoPrecision(13), params.GeoPrecision)
+	assert.NotNil(t, params.BoundingBox)
+}
+
+func TestGetTileHandlerWhenOverCostLimit(t *testing.T) {
+	service := NewAggregatesService(new(mockRepo), new(mockCache))
+	limit := testQueryCostLimit
+	limit.MaxCost = 1000
+	handler := MakeGetTileHandler(service, &limit)
+
+	req := httptest.NewRequest(http.MethodGet, "/tiles/13/1310/3166.mvt?start_time=2024-01-01T00:00Z&end_time=2025-01-01T00:00Z", nil)
+	req.SetPathValue("z", "13")
+	req.SetPathValue("x", "1310")
+	req.SetPathValue("y", "3166.mvt")
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	result := w.Result()
+	defer result.Body.Close()
+
+	require.Equal(t, http.StatusUnprocessableEntity, result.StatusCode)
+	assert.Equal(t, "application/json", result.Header.Get("Content-Type"))
+}
+
+func TestGetTileHandlerWhenInvalidTile(t *testing.T) {
+	service := NewAggregatesService(new(mockRepo), new(mockCache))
+	handler := MakeGetTileHandler(service, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/tiles/1/2/0.mvt", nil)
+	req.SetPathValue("z", "1")
+	req.SetPathValue("x", "2")
+	req.SetPathValue("y", "0.mvt")
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Result().StatusCode)
+}
+
+func TestInsertAggregatesHandlerWhenBodyTooLarge(t *testing.T) {
+	service := NewAggregatesService(new(mockRepo), new(mockCache))
+	handler := MakeInsertAggregatesHandler(service, 8)
+
+	body := strings.NewReader(`[{"occurred_at": "2025-01-14T00:00:00Z", "geohash": "9q8yyqb", "incident_type": "police_incident", "count": 2}]`)
+	req := httptest.NewRequest(http.MethodPost, "/aggregates", body)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Result().StatusCode)
+}
+
+func (suite *HandlersTestSuite) TestGetAggregatesHandlerWhenStreamingFormat() {
+	t := suite.T()
+	repo := &Repo{conn: suite.Conn}
+
+	records := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 8, 0, 0, time.UTC), Geohash: "abcde12", IncidentType: IncidentTypePoliceIncident, Count: 2},
+		{OccurredAt: time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
+	}
+
+	WriteTestData(context.Background(), suite.Conn, records)
+	defer DeleteTestData(context.Background(), suite.Conn)
+
+	service := NewAggregatesService(repo, new(mockCache))
+	handler := MakeGetAggregatesHandler(service, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/aggregates?format=csv&time_precision=1h&geo_precision=6", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	result := w.Result()
+	defer result.Body.Close()
+
+	require.Equal(t, http.StatusOK, result.StatusCode)
+
+	data, err := io.ReadAll(result.Body)
+	require.Nil(t, err)
+
+	expected := "occurred_at,geohash,incident_type,count\n" +
+		"2025-01-01T14:00:00Z,abcde1,police_incident,3\n" +
+		"2025-01-01T15:00:00Z,abcde1,police_incident,1\n"
+	assert.Equal(t, expected, string(data))
+}
+
 func (suite *HandlersTestSuite) TestInsertAggregatesHandler() {
 	t := suite.T()
 	repo := &Repo{conn: suite.Conn}
 
 	records := []AggregateRow{
-		{OccurredAt: time.Date(2025, 1, 13, 1, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 1},
-		{OccurredAt: time.Date(2025, 1, 14, 1, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 1},
+		{OccurredAt: time.Date(2025, 1, 13, 1, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 14, 1, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
 	}
 
 	WriteTestData(context.Background(), suite.Conn, records)
 	defer DeleteTestData(context.Background(), suite.Conn)
 
 	cache := new(mockCache)
-	cache.On("Get", mock.Anything, mock.Anything).Return([]Aggregate{}, ErrNoSuchKey)
-	cache.On("Set", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	cache.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+	cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil)
+	cache.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	cache.On("Invalidate", mock.Anything, mock.Anything).Return(nil)
 
 	service := NewAggregatesService(repo, cache)
-	handler := MakeInsertAggregatesHandler(context.Background(), service)
+	handler := MakeInsertAggregatesHandler(service, 1<<20)
 
-	body := strings.NewReader(`[{"occurred_at": "2025-01-14T00:00:00Z", "geohash": "abcdefg", "count": 2}]`)
+	body := strings.NewReader(`[{"occurred_at": "2025-01-14T00:00:00Z", "geohash": "9q8yyqb", "incident_type": "police_incident", "count": 2}]`)
 	req := httptest.NewRequest(http.MethodPost, "/aggregates", body)
 	w := httptest.NewRecorder()
 	handler(w, req)
@@ -202,16 +804,25 @@ func (suite *HandlersTestSuite) TestInsertAggregatesHandler() {
 
 	require.Equal(t, http.StatusOK, result.StatusCode)
 
-	rows, err := suite.Conn.Query(context.Background(), "select occurred_at, geo_id, incident_count from aggregate_buckets")
+	rows, err := suite.Conn.Query(context.Background(), "select occurred_at, geo_id, incident_type, incident_count from aggregate_buckets")
 	require.Nil(t, err)
 	defer rows.Close()
 
 	actual, err := pgx.CollectRows(rows, pgx.RowToStructByName[AggregateRow])
 	require.Nil(t, err)
 	expected := append(records,
-		AggregateRow{OccurredAt: time.Date(2025, 1, 14, 0, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 2},
+		AggregateRow{OccurredAt: time.Date(2025, 1, 14, 0, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 2},
 	)
 	assert.Equal(t, expected, actual)
+
+	actualHourly, err := ReadRollupTable(context.Background(), suite.Conn, RollupTables[1])
+	require.Nil(t, err)
+	expectedHourly := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 13, 1, 0, 0, 0, time.UTC), Geohash: "9q8yy", IncidentType: IncidentTypePoliceIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 14, 0, 0, 0, 0, time.UTC), Geohash: "9q8yy", IncidentType: IncidentTypePoliceIncident, Count: 2},
+		{OccurredAt: time.Date(2025, 1, 14, 1, 0, 0, 0, time.UTC), Geohash: "9q8yy", IncidentType: IncidentTypePoliceIncident, Count: 1},
+	}
+	assert.Equal(t, expectedHourly, actualHourly)
 }
 
 func (suite *HandlersTestSuite) TestUpsertAggregatesHandler() {
@@ -219,22 +830,24 @@ func (suite *HandlersTestSuite) TestUpsertAggregatesHandler() {
 	repo := &Repo{conn: suite.Conn}
 
 	records := []AggregateRow{
-		{OccurredAt: time.Date(2025, 1, 13, 1, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 1},
-		{OccurredAt: time.Date(2025, 1, 13, 1, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 2},
-		{OccurredAt: time.Date(2025, 1, 14, 1, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 1},
+		{OccurredAt: time.Date(2025, 1, 13, 1, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 13, 1, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 2},
+		{OccurredAt: time.Date(2025, 1, 14, 1, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
 	}
 
 	WriteTestData(context.Background(), suite.Conn, records)
 	defer DeleteTestData(context.Background(), suite.Conn)
 
 	cache := new(mockCache)
-	cache.On("Get", mock.Anything, mock.Anything).Return([]Aggregate{}, ErrNoSuchKey)
-	cache.On("Set", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	cache.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+	cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil)
+	cache.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	cache.On("Invalidate", mock.Anything, mock.Anything).Return(nil)
 
 	service := NewAggregatesService(repo, cache)
-	handler := MakeUpsertAggregatesHandler(context.Background(), service)
+	handler := MakeUpsertAggregatesHandler(service, 1<<20)
 
-	body := strings.NewReader(`[{"occurred_at": "2025-01-13T01:00:00Z", "geohash": "abcdefg", "count": 2}]`)
+	body := strings.NewReader(`[{"occurred_at": "2025-01-13T01:00:00Z", "geohash": "9q8yyqb", "incident_type": "police_incident", "count": 2}]`)
 	req := httptest.NewRequest(http.MethodPut, "/aggregates", body)
 	w := httptest.NewRecorder()
 	handler(w, req)
@@ -244,17 +857,44 @@ func (suite *HandlersTestSuite) TestUpsertAggregatesHandler() {
 
 	require.Equal(t, http.StatusOK, result.StatusCode)
 
-	rows, err := suite.Conn.Query(context.Background(), "select occurred_at, geo_id, incident_count from aggregate_buckets")
+	rows, err := suite.Conn.Query(context.Background(), "select occurred_at, geo_id, incident_type, incident_count from aggregate_buckets")
 	require.Nil(t, err)
 	defer rows.Close()
 
 	actual, err := pgx.CollectRows(rows, pgx.RowToStructByName[AggregateRow])
 	require.Nil(t, err)
 	expected := []AggregateRow{
-		{OccurredAt: time.Date(2025, 1, 14, 1, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 1},
-		{OccurredAt: time.Date(2025, 1, 13, 1, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 2},
+		{OccurredAt: time.Date(2025, 1, 14, 1, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 13, 1, 0, 0, 0, time.UTC), Geohash: "9q8yyqb", IncidentType: IncidentTypePoliceIncident, Count: 2},
 	}
 	assert.Equal(t, expected, actual)
+
+	actualHourly, err := ReadRollupTable(context.Background(), suite.Conn, RollupTables[1])
+	require.Nil(t, err)
+	expectedHourly := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 13, 1, 0, 0, 0, time.UTC), Geohash: "9q8yy", IncidentType: IncidentTypePoliceIncident, Count: 2},
+		{OccurredAt: time.Date(2025, 1, 14, 1, 0, 0, 0, time.UTC), Geohash: "9q8yy", IncidentType: IncidentTypePoliceIncident, Count: 1},
+	}
+	assert.Equal(t, expectedHourly, actualHourly)
+}
+
+func (suite *HandlersTestSuite) TestGetAggregatesHandlerWhenQueryTimeout() {
+	t := suite.T()
+	repo := &Repo{conn: suite.Conn, QueryTimeout: time.Nanosecond}
+
+	cache := new(mockCache)
+	cache.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+	cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil)
+
+	service := NewAggregatesService(repo, cache)
+	_, err := service.GetAggregates(context.Background(), AggregatesReqParams{
+		EndTime:       time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+		TimePrecision: DefaultTimePrecision,
+		Location:      time.UTC,
+		GeoPrecision:  DefaultGeoPrecision,
+	})
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
 }
 
 func TestHandlersTestSuite(t *testing.T) {
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// In-memory size of an Aggregate, excluding the bytes of its strings.
+const aggregateSize = 64
+
+func aggregatesSize(records []Aggregate) int64 {
+	size := int64(0)
+	for _, record := range records {
+		size += aggregateSize + int64(len(record.Geohash)+len(record.IncidentType))
+	}
+	return size
+}
+
+type localCacheEntry struct {
+	key       string
+	params    AggregatesReqParams
+	result    AggregatesResult
+	size      int64
+	expiresAt time.Time
+}
+
+// CacheStats counts lookups in the in-memory cache.
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// TieredCache is a Cacher which keeps recently used aggregates in memory, in
+// front of a shared Cacher, such as Cache. Entries are evicted least recently
+// used first once either the entry or byte limit is exceeded.
+//
+// Writes are invalidated in memory only on the replica which handled them, so
+// entries are kept briefly to bound how long other replicas serve outdated
+// aggregates.
+type TieredCache struct {
+	remote     Cacher
+	TTL        time.Duration
+	MaxEntries int
+	MaxBytes   int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// Entries ordered from most to least recently used.
+	order *list.List
+	bytes int64
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func NewTieredCache(remote Cacher, ttl time.Duration, maxEntries int, maxBytes int64) *TieredCache {
+	return &TieredCache{
+		remote:     remote,
+		TTL:        ttl,
+		MaxEntries: maxEntries,
+		MaxBytes:   maxBytes,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (c *TieredCache) Stats() CacheStats {
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
+
+// lookup gets unexpired aggregates from memory, counting the hit or miss.
+func (c *TieredCache) lookup(params AggregatesReqParams) (AggregatesResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[ParamsKey(params)]
+	if ok && time.Now().After(elem.Value.(*localCacheEntry).expiresAt) {
+		c.remove(elem)
+		ok = false
+	}
+	if !ok {
+		c.misses.Add(1)
+		return AggregatesResult{}, false
+	}
+
+	c.hits.Add(1)
+	c.order.MoveToFront(elem)
+	return elem.Value.(*localCacheEntry).result, true
+}
+
+// store puts aggregates in memory, evicting the least recently used entries
+// as needed. Aggregates larger than the byte limit are not stored.
+func (c *TieredCache) store(params AggregatesReqParams, result AggregatesResult) {
+	if result.Aggregates == nil {
+		result.Aggregates = []Aggregate{}
+	}
+	entry := &localCacheEntry{
+		key:       ParamsKey(params),
+		params:    params,
+		result:    result,
+		size:      aggregatesSize(result.Aggregates),
+		expiresAt: time.Now().Add(c.TTL),
+	}
+	if entry.size > c.MaxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[entry.key]; ok {
+		c.remove(elem)
+	}
+	c.entries[entry.key] = c.order.PushFront(entry)
+	c.bytes += entry.size
+
+	for len(c.entries) > c.MaxEntries || c.bytes > c.MaxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove removes an entry from memory. The caller must hold the lock.
+func (c *TieredCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*localCacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+}
+
+// Get gets aggregates from memory, or else from the shared cache. Stale
+// aggregates from the shared cache are not kept in memory.
+func (c *TieredCache) Get(ctx context.Context, params AggregatesReqParams) (AggregatesResult, error) {
+	if result, ok := c.lookup(params); ok {
+		return result, nil
+	}
+
+	result, err := c.remote.Get(ctx, params)
+	if err == nil {
+		c.store(params, result)
+	}
+	return result, err
+}
+
+func (c *TieredCache) Versions(ctx context.Context, params []AggregatesReqParams) ([]CacheVersion, error) {
+	return c.remote.Versions(ctx, params)
+}
+
+// Set sets aggregates in the shared cache, and in memory unless their version
+// has changed.
+func (c *TieredCache) Set(ctx context.Context, params AggregatesReqParams, result AggregatesResult, version CacheVersion) error {
+	err := c.remote.Set(ctx, params, result, version)
+	if !errors.Is(err, ErrVersionChanged) {
+		c.store(params, result)
+	}
+	return err
+}
+
+// GetMany gets aggregates from memory, and those not in memory from the shared
+// cache. Stale aggregates from the shared cache are not kept in memory.
+func (c *TieredCache) GetMany(ctx context.Context, params []AggregatesReqParams) ([][]Aggregate, []bool, error) {
+	results := make([][]Aggregate, len(params))
+	stale := make([]bool, len(params))
+	missing := []int{}
+	for idx, p := range params {
+		if result, ok := c.lookup(p); ok {
+			results[idx] = result.Aggregates
+			continue
+		}
+		missing = append(missing, idx)
+	}
+
+	if len(missing) == 0 {
+		return results, stale, nil
+	}
+
+	missingParams := make([]AggregatesReqParams, len(missing))
+	for idx, paramsIdx := range missing {
+		missingParams[idx] = params[paramsIdx]
+	}
+
+	remoteResults, remoteStale, err := c.remote.GetMany(ctx, missingParams)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for idx, paramsIdx := range missing {
+		records := remoteResults[idx]
+		if records != nil && !remoteStale[idx] {
+			c.store(params[paramsIdx], AggregatesResult{Aggregates: records})
+		}
+		results[paramsIdx] = records
+		stale[paramsIdx] = remoteStale[idx]
+	}
+	return results, stale, nil
+}
+
+// SetMany sets aggregates in the shared cache, and in memory unless any of
+// their versions has changed.
+func (c *TieredCache) SetMany(ctx context.Context, params []AggregatesReqParams, results [][]Aggregate, versions []CacheVersion) error {
+	err := c.remote.SetMany(ctx, params, results, versions)
+	if !errors.Is(err, ErrVersionChanged) {
+		for idx, p := range params {
+			c.store(p, AggregatesResult{Aggregates: results[idx]})
+		}
+	}
+	return err
+}
+
+// overlapsPartitions reports whether the params' time range overlaps any of the
+// ordered time partitions.
+func overlapsPartitions(params AggregatesReqParams, partitions []time.Time) bool {
+	first := params.StartTime.UTC().Truncate(CachePartitionDuration)
+	idx, _ := slices.BinarySearchFunc(partitions, first, time.Time.Compare)
+	return idx < len(partitions) && !partitions[idx].After(params.EndTime)
+}
+
+// Invalidate removes aggregates from memory whose time range overlaps the time
+// partition of any of the times, and from the shared cache.
+func (c *TieredCache) Invalidate(ctx context.Context, times []time.Time) error {
+	// Aggregates are invalidated by time partition, as in the shared cache, so
+	// that each entry is checked against the few partitions written rather than
+	// every time.
+	partitions := WrittenPartitions(times)
+
+	c.mu.Lock()
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if overlapsPartitions(elem.Value.(*localCacheEntry).params, partitions) {
+			c.remove(elem)
+		}
+		elem = next
+	}
+	c.mu.Unlock()
+
+	return c.remote.Invalidate(ctx, times)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func makeLocalCacheParams(day int) AggregatesReqParams {
+	return AggregatesReqParams{
+		StartTime:     time.Date(2025, 1, day, 0, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(2025, 1, day+1, 0, 0, 0, 0, time.UTC),
+		TimePrecision: FixedTimePrecision(time.Hour),
+		Location:      time.UTC,
+		GeoPrecision:  DefaultGeoPrecision,
+	}
+}
+
+func TestTieredCacheGet(t *testing.T) {
+	records := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+	}
+	params := makeLocalCacheParams(1)
+
+	remote := new(mockCache)
+	remote.On("Get", mock.Anything, mock.Anything).Return(NewAggregatesResult(records, time.Now()), nil).Once()
+
+	cache := NewTieredCache(remote, time.Minute, 10, 1<<20)
+	ctx := context.Background()
+
+	for range 2 {
+		actual, err := cache.Get(ctx, params)
+		assert.Nil(t, err)
+		assert.Equal(t, records, actual.Aggregates)
+		assert.Equal(t, AggregatesETag(records), actual.ETag)
+	}
+	remote.AssertNumberOfCalls(t, "Get", 1)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1}, cache.Stats())
+}
+
+func TestTieredCacheGetWhenStale(t *testing.T) {
+	params := makeLocalCacheParams(1)
+
+	remote := new(mockCache)
+	remote.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrStaleKey)
+
+	cache := NewTieredCache(remote, time.Minute, 10, 1<<20)
+	ctx := context.Background()
+
+	for range 2 {
+		_, err := cache.Get(ctx, params)
+		assert.ErrorIs(t, err, ErrStaleKey)
+	}
+	remote.AssertNumberOfCalls(t, "Get", 2)
+}
+
+func TestTieredCacheGetWhenExpired(t *testing.T) {
+	params := makeLocalCacheParams(1)
+
+	remote := new(mockCache)
+	remote.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	remote.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+
+	cache := NewTieredCache(remote, 0, 10, 1<<20)
+	ctx := context.Background()
+
+	assert.Nil(t, cache.Set(ctx, params, AggregatesResult{}, CacheVersion{}))
+	_, err := cache.Get(ctx, params)
+
+	assert.ErrorIs(t, err, ErrNoSuchKey)
+	assert.Equal(t, CacheStats{Misses: 1}, cache.Stats())
+}
+
+func TestTieredCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	record := Aggregate{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1}
+	recordSize := aggregatesSize([]Aggregate{record})
+
+	type testCase struct {
+		Name       string
+		MaxEntries int
+		MaxBytes   int64
+	}
+
+	testCases := []testCase{
+		{Name: "Entry limit", MaxEntries: 2, MaxBytes: 10 * recordSize},
+		{Name: "Byte limit", MaxEntries: 10, MaxBytes: 2 * recordSize},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			remote := new(mockCache)
+			remote.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			remote.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+
+			cache := NewTieredCache(remote, time.Minute, tc.MaxEntries, tc.MaxBytes)
+			ctx := context.Background()
+
+			assert.Nil(t, cache.Set(ctx, makeLocalCacheParams(1), AggregatesResult{Aggregates: []Aggregate{record}}, CacheVersion{}))
+			assert.Nil(t, cache.Set(ctx, makeLocalCacheParams(2), AggregatesResult{Aggregates: []Aggregate{record}}, CacheVersion{}))
+			// Use the first entry, so that the second is least recently used.
+			_, err := cache.Get(ctx, makeLocalCacheParams(1))
+			assert.Nil(t, err)
+			assert.Nil(t, cache.Set(ctx, makeLocalCacheParams(3), AggregatesResult{Aggregates: []Aggregate{record}}, CacheVersion{}))
+
+			_, err = cache.Get(ctx, makeLocalCacheParams(1))
+			assert.Nil(t, err)
+			_, err = cache.Get(ctx, makeLocalCacheParams(2))
+			assert.ErrorIs(t, err, ErrNoSuchKey)
+		})
+	}
+}
+
+func TestTieredCacheGetMany(t *testing.T) {
+	cached := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+	}
+	remoteCached := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 2, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 2},
+	}
+	remoteStale := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 3, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 3},
+	}
+	params := []AggregatesReqParams{makeLocalCacheParams(1), makeLocalCacheParams(2), makeLocalCacheParams(3), makeLocalCacheParams(4)}
+
+	remote := new(mockCache)
+	remote.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	remote.On("GetMany", mock.Anything, params[1:]).Return([][]Aggregate{remoteCached, remoteStale, nil}, []bool{false, true, false}, nil)
+	remote.On("GetMany", mock.Anything, params[2:3]).Return([][]Aggregate{remoteStale}, []bool{true}, nil)
+
+	cache := NewTieredCache(remote, time.Minute, 10, 1<<20)
+	ctx := context.Background()
+
+	assert.Nil(t, cache.Set(ctx, params[0], AggregatesResult{Aggregates: cached}, CacheVersion{}))
+	actual, stale, err := cache.GetMany(ctx, params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, [][]Aggregate{cached, remoteCached, remoteStale, nil}, actual)
+	assert.Equal(t, []bool{false, false, true, false}, stale)
+	remote.AssertCalled(t, "GetMany", ctx, params[1:])
+
+	// Fresh aggregates from the shared cache are kept in memory, and stale
+	// aggregates are not.
+	actual, stale, err = cache.GetMany(ctx, params[:3])
+	assert.Nil(t, err)
+	assert.Equal(t, [][]Aggregate{cached, remoteCached, remoteStale}, actual)
+	assert.Equal(t, []bool{false, false, true}, stale)
+	remote.AssertCalled(t, "GetMany", ctx, params[2:3])
+	remote.AssertNumberOfCalls(t, "GetMany", 2)
+}
+
+func TestTieredCacheSetWhenVersionChanged(t *testing.T) {
+	params := makeLocalCacheParams(1)
+
+	remote := new(mockCache)
+	remote.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(ErrVersionChanged)
+	remote.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+
+	cache := NewTieredCache(remote, time.Minute, 10, 1<<20)
+	ctx := context.Background()
+
+	err := cache.Set(ctx, params, AggregatesResult{}, CacheVersion{Generation: 1})
+	assert.ErrorIs(t, err, ErrVersionChanged)
+
+	// Outdated aggregates aren't kept in memory.
+	_, err = cache.Get(ctx, params)
+	assert.ErrorIs(t, err, ErrNoSuchKey)
+}
+
+func TestTieredCacheInvalidate(t *testing.T) {
+	times := []time.Time{time.Date(2025, 1, 2, 13, 0, 0, 0, time.UTC)}
+
+	remote := new(mockCache)
+	remote.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	remote.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+	remote.On("Invalidate", mock.Anything, mock.Anything).Return(nil)
+
+	cache := NewTieredCache(remote, time.Minute, 10, 1<<20)
+	ctx := context.Background()
+
+	for day := 1; day <= 3; day++ {
+		assert.Nil(t, cache.Set(ctx, makeLocalCacheParams(day), AggregatesResult{}, CacheVersion{}))
+	}
+
+	unbounded := makeLocalCacheParams(3)
+	unbounded.StartTime = time.Time{}
+	assert.Nil(t, cache.Set(ctx, unbounded, AggregatesResult{}, CacheVersion{}))
+	assert.Nil(t, cache.Invalidate(ctx, times))
+
+	// Time ranges ending at the start of the written partition overlap it.
+	for day, expected := range map[int]error{1: ErrNoSuchKey, 2: ErrNoSuchKey, 3: nil} {
+		_, err := cache.Get(ctx, makeLocalCacheParams(day))
+		assert.ErrorIs(t, err, expected, day)
+	}
+	_, err := cache.Get(ctx, unbounded)
+	assert.ErrorIs(t, err, ErrNoSuchKey)
+	remote.AssertCalled(t, "Invalidate", ctx, times)
+}
@@ -2,44 +2,154 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata" // Embed timezone data for the `tz` parameter.
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
 	config, err := NewConfig()
 	if err != nil {
 		slog.Error("Unable to read config variables", "error", err)
-		os.Exit(1)
+		return 1
 	}
 
+	shutdownTracing, err := InitTracing(context.Background(), "app", config.TracesExporter, config.TracesFile)
+	if err != nil {
+		slog.Error("Unable to configure tracing", "error", err)
+		return 1
+	}
+	defer shutdownTracing(context.Background())
+
 	pool, err := pgxpool.New(context.Background(), config.DatabaseURL)
 	if err != nil {
 		slog.Error("Unable to connect to database", "error", err)
-		os.Exit(1)
+		return 1
 	}
 	defer pool.Close()
 
-	repo := &Repo{conn: pool}
+	repo := &Repo{conn: pool, QueryTimeout: config.QueryTimeout}
 
-	cache := NewCacheFromURL(config.CacheURL, config.CachePrefix, config.CacheTTL)
+	cache := NewCacheFromURL(config.CacheURL, config.CachePrefix, config.CacheTTL, config.CacheStaleTTL)
 	defer cache.Close()
 
-	service := NewAggregatesService(repo, cache)
+	localCache := NewTieredCache(cache, config.LocalCacheTTL, config.LocalCacheMaxEntries, config.LocalCacheMaxBytes)
+	// Served at /debug/vars on the admin port.
+	expvar.Publish("local_cache", expvar.Func(func() any { return localCache.Stats() }))
+
+	prometheus.MustRegister(
+		NewPoolCollector(pool),
+		prometheus.NewCounterFunc(
+			prometheus.CounterOpts{Name: "local_cache_hits_total", Help: "Hits in the in-memory cache."},
+			func() float64 { return float64(localCache.Stats().Hits) },
+		),
+		prometheus.NewCounterFunc(
+			prometheus.CounterOpts{Name: "local_cache_misses_total", Help: "Misses in the in-memory cache."},
+			func() float64 { return float64(localCache.Stats().Misses) },
+		),
+	)
+
+	service := NewAggregatesService(repo, localCache)
+
+	auth, err := NewAuthenticator(config.AuthMethod, config.AuthKeys, config.InsertMaxBytes)
+	if err != nil {
+		slog.Error("Unable to configure auth", "error", err)
+		return 1
+	}
+
+	limiter := NewRateLimiter(config.RateLimit, config.RateLimitBurst, time.Now)
+	authFailureLimiter := NewRateLimiter(config.AuthFailureRateLimit, config.AuthFailureRateLimitBurst, time.Now)
+
+	// The default mux isn't used, as expvar registers /debug/vars on it.
+	mux := http.NewServeMux()
+
+	getAggregatesHandler := http.HandlerFunc(MakeGetAggregatesHandler(service, &config.QueryCostLimit))
+	mux.Handle("GET /aggregates", TraceRoute("/aggregates", InstrumentRoute("/aggregates", LimitAuthFailures(authFailureLimiter, RequireScope(auth, ScopeRead, RateLimit(limiter, getAggregatesHandler))))))
 
-	getAggregatesHandler := http.HandlerFunc(MakeGetAggregatesHandler(context.Background(), service))
-	http.Handle("GET /aggregates", getAggregatesHandler)
+	getTileHandler := http.HandlerFunc(MakeGetTileHandler(service, &config.QueryCostLimit))
+	mux.Handle("GET /tiles/{z}/{x}/{y}", TraceRoute("/tiles/{z}/{x}/{y}", InstrumentRoute("/tiles/{z}/{x}/{y}", LimitAuthFailures(authFailureLimiter, RequireScope(auth, ScopeRead, RateLimit(limiter, getTileHandler))))))
 
-	insertAggregatesHandler := http.HandlerFunc(MakeInsertAggregatesHandler(context.Background(), service))
-	http.Handle("POST /aggregates", insertAggregatesHandler)
+	insertAggregatesHandler := http.HandlerFunc(MakeInsertAggregatesHandler(service, config.InsertMaxBytes))
+	mux.Handle("POST /aggregates", TraceRoute("/aggregates", InstrumentRoute("/aggregates", LimitAuthFailures(authFailureLimiter, RequireScope(auth, ScopeWrite, insertAggregatesHandler)))))
 
-	upsertAggregatesHandler := http.HandlerFunc(MakeUpsertAggregatesHandler(context.Background(), service))
-	http.Handle("PUT /aggregates", upsertAggregatesHandler)
+	upsertAggregatesHandler := http.HandlerFunc(MakeUpsertAggregatesHandler(service, config.InsertMaxBytes))
+	mux.Handle("PUT /aggregates", TraceRoute("/aggregates", InstrumentRoute("/aggregates", LimitAuthFailures(authFailureLimiter, RequireScope(auth, ScopeWrite, upsertAggregatesHandler)))))
+
+	// Health checks are unauthenticated, for use by orchestrators.
+	mux.HandleFunc("GET /healthz", HandleLiveness)
+	mux.HandleFunc("GET /readyz", MakeReadinessHandler(map[string]HealthCheck{
+		"postgres": pool.Ping,
+		"redis":    cache.Ping,
+	}))
+
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%s", config.Port),
+		Handler:      mux,
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		IdleTimeout:  config.IdleTimeout,
+	}
+
+	// Metrics are unauthenticated, so are served on a separate port which isn't
+	// exposed publicly.
+	adminMux := http.NewServeMux()
+	adminMux.Handle("GET /metrics", promhttp.Handler())
+	adminMux.Handle("GET /debug/vars", expvar.Handler())
+
+	adminServer := &http.Server{
+		Addr:         fmt.Sprintf(":%s", config.AdminPort),
+		Handler:      adminMux,
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		IdleTimeout:  config.IdleTimeout,
+	}
+
+	signalCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 2)
+	go func() {
+		slog.Info(fmt.Sprintf("Listening on port %s...", config.Port))
+		serveErr <- server.ListenAndServe()
+	}()
+	go func() {
+		slog.Info(fmt.Sprintf("Serving metrics on port %s...", config.AdminPort))
+		serveErr <- adminServer.ListenAndServe()
+	}()
+	defer adminServer.Close()
+
+	select {
+	case err := <-serveErr:
+		slog.Error("Unable to serve", "error", err)
+		return 1
+	case <-signalCtx.Done():
+		slog.Info("Shutting down...")
+	}
+
+	// Stop accepting connections, and wait for in-flight requests to complete
+	// before the pool and cache are closed.
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("Unable to drain connections", "error", err)
+		server.Close()
+		return 1
+	}
 
-	slog.Info(fmt.Sprintf("Listening on port %s...", config.Port))
-	http.ListenAndServe(fmt.Sprintf(":%s", config.Port), nil)
+	slog.Info("Shut down")
+	return 0
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestInstrumentRoute(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := InstrumentRoute("/test", next)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	count := testutil.CollectAndCount(requestDuration.MustCurryWith(map[string]string{"route": "/test", "method": "get", "code": "418"}))
+	assert.Equal(t, 1, count)
+}
+
+func TestAggregatesServiceCountsCacheLookups(t *testing.T) {
+	params := AggregatesReqParams{
+		StartTime:     time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC),
+		TimePrecision: FixedTimePrecision(time.Hour),
+		Location:      time.UTC,
+		GeoPrecision:  DefaultGeoPrecision,
+	}
+
+	cache := new(mockCache)
+	cache.On("Get", mock.Anything, mock.Anything).Return(NewAggregatesResult([]Aggregate{}, time.Now()), nil)
+	service := NewAggregatesService(new(mockRepo), cache)
+
+	hits := cacheLookups.WithLabelValues(cacheLookupWhole, cacheResultHit)
+	before := testutil.ToFloat64(hits)
+
+	_, err := service.GetAggregates(context.Background(), params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, before+1, testutil.ToFloat64(hits))
+}
@@ -11,11 +11,21 @@ type mockRepo struct {
 	mock.Mock
 }
 
-func (m *mockRepo) GetAggregateRows(ctx context.Context, startTime, endTime time.Time) ([]AggregateRow, error) {
-	args := m.Called(ctx, startTime, endTime)
+func (m *mockRepo) GetAggregateRows(ctx context.Context, filter RowsFilter) ([]AggregateRow, error) {
+	args := m.Called(ctx, filter)
 	return args.Get(0).([]AggregateRow), args.Error(1)
 }
 
+func (m *mockRepo) StreamAggregateRows(ctx context.Context, filter RowsFilter, fn func(AggregateRow) error) error {
+	args := m.Called(ctx, filter, fn)
+	for _, row := range args.Get(0).([]AggregateRow) {
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
 func (m *mockRepo) InsertAggregateRows(ctx context.Context, records []AggregateRow) error {
 	args := m.Called(ctx, records)
 	return args.Error(0)
@@ -26,16 +36,69 @@ func (m *mockRepo) UpsertAggregateRows(ctx context.Context, records []AggregateR
 	return args.Error(0)
 }
 
+// mockRollupRepo is a mockRepo which also rolls up aggregates itself.
+type mockRollupRepo struct {
+	mockRepo
+}
+
+func (m *mockRollupRepo) RollupAggregateRows(ctx context.Context, filter RowsFilter, rollup RowsRollup) ([]AggregateRow, error) {
+	args := m.Called(ctx, filter, rollup)
+	return args.Get(0).([]AggregateRow), args.Error(1)
+}
+
+func (m *mockRollupRepo) StreamRollupAggregateRows(ctx context.Context, filter RowsFilter, rollup RowsRollup, fn func(AggregateRow) error) error {
+	args := m.Called(ctx, filter, rollup, fn)
+	for _, row := range args.Get(0).([]AggregateRow) {
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
 type mockCache struct {
 	mock.Mock
 }
 
-func (m *mockCache) Get(ctx context.Context, params AggregatesReqParams) ([]Aggregate, error) {
+func (m *mockCache) Get(ctx context.Context, params AggregatesReqParams) (AggregatesResult, error) {
 	args := m.Called(ctx, params)
-	return args.Get(0).([]Aggregate), args.Error(1)
+	return args.Get(0).(AggregatesResult), args.Error(1)
+}
+
+// Versions returns zero versions for all params if no return value is given.
+func (m *mockCache) Versions(ctx context.Context, params []AggregatesReqParams) ([]CacheVersion, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return make([]CacheVersion, len(params)), args.Error(1)
+	}
+	return args.Get(0).([]CacheVersion), args.Error(1)
+}
+
+func (m *mockCache) Set(ctx context.Context, params AggregatesReqParams, result AggregatesResult, version CacheVersion) error {
+	args := m.Called(ctx, params, result, version)
+	return args.Error(0)
+}
+
+// GetMany returns misses for all params if no aggregates are given, and fresh
+// aggregates if no staleness is given.
+func (m *mockCache) GetMany(ctx context.Context, params []AggregatesReqParams) ([][]Aggregate, []bool, error) {
+	args := m.Called(ctx, params)
+	results, stale := make([][]Aggregate, len(params)), make([]bool, len(params))
+	if args.Get(0) != nil {
+		results = args.Get(0).([][]Aggregate)
+	}
+	if args.Get(1) != nil {
+		stale = args.Get(1).([]bool)
+	}
+	return results, stale, args.Error(2)
+}
+
+func (m *mockCache) SetMany(ctx context.Context, params []AggregatesReqParams, results [][]Aggregate, versions []CacheVersion) error {
+	args := m.Called(ctx, params, results, versions)
+	return args.Error(0)
 }
 
-func (m *mockCache) Set(ctx context.Context, params AggregatesReqParams, records []Aggregate) error {
-	args := m.Called(ctx, params, records)
+func (m *mockCache) Invalidate(ctx context.Context, times []time.Time) error {
+	args := m.Called(ctx, times)
 	return args.Error(0)
 }
@@ -0,0 +1,90 @@
+package main
+
+//go:generate protoc --proto_path=../proto --go_out=. --go_opt=paths=source_relative aggregates.proto cache.proto
+
+import (
+	"io"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// MapToProto stores the aggregates by column, with geohashes and incident types
+// replaced by indexes into lists of their distinct values.
+func MapToProto(records []Aggregate) *Aggregates {
+	message := &Aggregates{
+		OccurredAt:        make([]int64, len(records)),
+		GeohashIndex:      make([]uint32, len(records)),
+		IncidentTypeIndex: make([]uint32, len(records)),
+		Count:             make([]int32, len(records)),
+	}
+
+	geohashIndexes := make(map[string]uint32)
+	incidentTypeIndexes := make(map[string]uint32)
+	for idx, record := range records {
+		geohashIdx, ok := geohashIndexes[record.Geohash]
+		if !ok {
+			geohashIdx = uint32(len(message.Geohashes))
+			geohashIndexes[record.Geohash] = geohashIdx
+			message.Geohashes = append(message.Geohashes, record.Geohash)
+		}
+
+		incidentTypeIdx, ok := incidentTypeIndexes[record.IncidentType]
+		if !ok {
+			incidentTypeIdx = uint32(len(message.IncidentTypes))
+			incidentTypeIndexes[record.IncidentType] = incidentTypeIdx
+			message.IncidentTypes = append(message.IncidentTypes, record.IncidentType)
+		}
+
+		message.OccurredAt[idx] = record.OccurredAt.Unix()
+		message.GeohashIndex[idx] = geohashIdx
+		message.IncidentTypeIndex[idx] = incidentTypeIdx
+		message.Count[idx] = record.Count
+	}
+	return message
+}
+
+func MapFromProto(message *Aggregates) ([]Aggregate, error) {
+	occurredAt, counts := message.GetOccurredAt(), message.GetCount()
+	geohashes, geohashIndexes := message.GetGeohashes(), message.GetGeohashIndex()
+	incidentTypes, incidentTypeIndexes := message.GetIncidentTypes(), message.GetIncidentTypeIndex()
+
+	n := len(occurredAt)
+	if len(geohashIndexes) != n || len(incidentTypeIndexes) != n || len(counts) != n {
+		return nil, ErrInvalidAggregates
+	}
+
+	records := make([]Aggregate, n)
+	for idx := range records {
+		geohashIdx, incidentTypeIdx := geohashIndexes[idx], incidentTypeIndexes[idx]
+		if int(geohashIdx) >= len(geohashes) || int(incidentTypeIdx) >= len(incidentTypes) {
+			return nil, ErrInvalidAggregates
+		}
+
+		records[idx] = Aggregate{
+			OccurredAt:   time.Unix(occurredAt[idx], 0).UTC(),
+			Geohash:      geohashes[geohashIdx],
+			IncidentType: incidentTypes[incidentTypeIdx],
+			Count:        counts[idx],
+		}
+	}
+	return records, nil
+}
+
+func EncodeAggregatesProtobuf(records []Aggregate, w io.Writer) error {
+	data, err := proto.Marshal(MapToProto(records))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func DecodeAggregatesProtobuf(data []byte) ([]Aggregate, error) {
+	var message Aggregates
+	if err := proto.Unmarshal(data, &message); err != nil {
+		return nil, err
+	}
+	return MapFromProto(&message)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapToProto(t *testing.T) {
+	records := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefh", IncidentType: IncidentTypeFireIncident, Count: 2},
+		{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident, Count: 3},
+	}
+
+	actual := MapToProto(records)
+
+	assert.Equal(t, []string{"abcdefg", "abcdefh"}, actual.Geohashes)
+	assert.Equal(t, []string{IncidentTypeFireIncident, IncidentTypePoliceIncident}, actual.IncidentTypes)
+	assert.Equal(t, []int64{1735736400, 1735736400, 1735740000}, actual.OccurredAt)
+	assert.Equal(t, []uint32{0, 1, 0}, actual.GeohashIndex)
+	assert.Equal(t, []uint32{0, 0, 1}, actual.IncidentTypeIndex)
+	assert.Equal(t, []int32{1, 2, 3}, actual.Count)
+}
+
+func TestEncodeAggregatesProtobuf(t *testing.T) {
+	records := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcdefh", IncidentType: IncidentTypePoliceIncident, Count: 2},
+	}
+
+	var buff bytes.Buffer
+	err := EncodeAggregatesProtobuf(records, &buff)
+	require.Nil(t, err)
+
+	actual, err := DecodeAggregatesProtobuf(buff.Bytes())
+	require.Nil(t, err)
+	assert.Equal(t, records, actual)
+}
+
+func TestMapFromProtoWhenInvalid(t *testing.T) {
+	for _, message := range []*Aggregates{
+		{OccurredAt: []int64{0}, GeohashIndex: []uint32{0}, IncidentTypeIndex: []uint32{0}},
+		{OccurredAt: []int64{0}, GeohashIndex: []uint32{0}, IncidentTypeIndex: []uint32{0}, Count: []int32{1}},
+	} {
+		_, err := MapFromProto(message)
+		assert.ErrorIs(t, err, ErrInvalidAggregates)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Buckets are swept at most this often, to drop those of idle clients.
+const rateLimitSweepInterval = time.Minute
+
+type tokenBucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// RateLimiter limits the rate of requests per client with token buckets. Each
+// client's bucket holds up to Burst tokens and is refilled at Rate tokens per
+// second, and each request takes a token.
+type RateLimiter struct {
+	Rate  float64
+	Burst float64
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	sweptAt time.Time
+}
+
+func NewRateLimiter(rate float64, burst int, now func() time.Time) *RateLimiter {
+	return &RateLimiter{
+		Rate:    rate,
+		Burst:   float64(burst),
+		now:     now,
+		buckets: make(map[string]*tokenBucket),
+		sweptAt: now(),
+	}
+}
+
+// Allow takes a token from the client's bucket. If the bucket is empty, false
+// is returned along with how long until a token is available.
+func (l *RateLimiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.sweptAt) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{Tokens: l.Burst, UpdatedAt: now}
+		l.buckets[client] = bucket
+	}
+
+	bucket.Tokens = l.refill(bucket, now)
+	bucket.UpdatedAt = now
+	if wait := l.wait(bucket.Tokens); wait > 0 {
+		return false, wait
+	}
+
+	bucket.Tokens--
+	return true, 0
+}
+
+// Wait returns how long until a token is available in the client's bucket,
+// which is zero if one is, without taking it.
+func (l *RateLimiter) Wait(client string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		return 0
+	}
+	return l.wait(l.refill(bucket, l.now()))
+}
+
+func (l *RateLimiter) wait(tokens float64) time.Duration {
+	if tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tokens) / l.Rate * float64(time.Second))
+}
+
+func (l *RateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	elapsed := now.Sub(bucket.UpdatedAt).Seconds()
+	return min(l.Burst, bucket.Tokens+elapsed*l.Rate)
+}
+
+// sweep drops full buckets, which are equivalent to having none.
+func (l *RateLimiter) sweep(now time.Time) {
+	for client, bucket := range l.buckets {
+		if l.refill(bucket, now) >= l.Burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.sweptAt = now
+}
+
+// ClientID identifies the client making the request by its API key, if
+// authenticated, or its IP address otherwise, such as when its authentication
+// failed.
+func ClientID(r *http.Request) string {
+	if key, ok := APIKeyFromContext(r.Context()); ok {
+		return "key:" + key.ID
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// RateLimit wraps the handler, such that requests from clients exceeding the
+// rate limit are rejected with a 429. Authenticated requests are limited per
+// API key, so RateLimit should be wrapped by RequireScope.
+func RateLimit(limiter *RateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, wait := limiter.Allow(ClientID(r))
+		if !allowed {
+			writeRateLimited(w, wait)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LimitAuthFailures wraps the handler, such that clients whose requests have
+// failed authentication at more than the limiter's rate are rejected with a
+// 429, before being authenticated. As such clients aren't identified, they are
+// limited per IP address, so LimitAuthFailures should wrap RequireScope.
+func LimitAuthFailures(limiter *RateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := ClientID(r)
+		if wait := limiter.Wait(client); wait > 0 {
+			writeRateLimited(w, wait)
+			return
+		}
+
+		tracker := &writeTracker{ResponseWriter: w}
+		next.ServeHTTP(tracker, r)
+		if tracker.status == http.StatusUnauthorized {
+			limiter.Allow(client)
+		}
+	})
+}
+
+func writeRateLimited(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	WriteError(w, http.StatusTooManyRequests, ErrorResponse{Error: "Rate limit exceeded"})
+}
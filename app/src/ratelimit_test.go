@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(2, 2, func() time.Time { return now })
+
+	for range 2 {
+		allowed, _ := limiter.Allow("a")
+		assert.True(t, allowed)
+	}
+
+	allowed, wait := limiter.Allow("a")
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// Clients have separate buckets.
+	allowed, _ = limiter.Allow("b")
+	assert.True(t, allowed)
+
+	now = now.Add(500 * time.Millisecond)
+	allowed, _ = limiter.Allow("a")
+	assert.True(t, allowed)
+}
+
+func TestRateLimiterSweepsIdleClients(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(1, 1, func() time.Time { return now })
+
+	limiter.Allow("a")
+	now = now.Add(rateLimitSweepInterval)
+	limiter.Allow("b")
+
+	assert.Len(t, limiter.buckets, 1)
+	assert.Contains(t, limiter.buckets, "b")
+}
+
+func TestClientID(t *testing.T) {
+	req := httptest.NewRequest("GET", "/aggregates", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	assert.Equal(t, "ip:192.0.2.1", ClientID(req))
+
+	req = req.WithContext(context.WithValue(req.Context(), apiKeyContextKey{}, testAPIKeys[0]))
+	assert.Equal(t, "key:reader", ClientID(req))
+}
+
+func TestRateLimit(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(0.5, 1, func() time.Time { return now })
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := RateLimit(limiter, next)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/aggregates", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/aggregates", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error": "Rate limit exceeded"}`, w.Body.String())
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(2, 1, func() time.Time { return now })
+
+	assert.Equal(t, time.Duration(0), limiter.Wait("a"))
+
+	limiter.Allow("a")
+	assert.Equal(t, 500*time.Millisecond, limiter.Wait("a"))
+	// Waiting doesn't take a token.
+	assert.Equal(t, 500*time.Millisecond, limiter.Wait("a"))
+
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, time.Duration(0), limiter.Wait("a"))
+}
+
+func TestLimitAuthFailures(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(0.5, 1, func() time.Time { return now })
+	auth, _ := NewAuthenticator(AuthMethodAPIKey, testAPIKeys, 1<<20)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := LimitAuthFailures(limiter, RequireScope(auth, ScopeRead, next))
+
+	request := func(addr, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/aggregates", nil)
+		req.RemoteAddr = addr
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// Authenticated requests aren't limited.
+	for range 2 {
+		assert.Equal(t, http.StatusOK, request("192.0.2.1:1234", "Bearer reader-secret").Code)
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request("192.0.2.1:1234", "Bearer forged").Code)
+
+	// Once the address has failed too often, its requests are rejected before
+	// they are authenticated.
+	w := request("192.0.2.1:1234", "Bearer reader-secret")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	// Other addresses are limited separately.
+	assert.Equal(t, http.StatusOK, request("192.0.2.2:1234", "Bearer reader-secret").Code)
+
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, http.StatusOK, request("192.0.2.1:1234", "Bearer reader-secret").Code)
+}
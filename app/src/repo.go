@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -9,65 +10,437 @@ import (
 )
 
 type AggregateRow struct {
-	OccurredAt time.Time `db:"occurred_at"`
-	Geohash    string    `db:"geo_id"`
-	Count      int32     `db:"incident_count"`
+	OccurredAt   time.Time `db:"occurred_at"`
+	Geohash      string    `db:"geo_id"`
+	IncidentType string    `db:"incident_type"`
+	Count        int32     `db:"incident_count"`
 }
 
 type Repo struct {
 	conn *pgxpool.Pool
+	// Maximum duration of queries, other than streamed queries, which last as
+	// long as the client reads. Not limited if zero.
+	QueryTimeout time.Duration
+}
+
+// withQueryTimeout bounds the query by the repo's query timeout, if any.
+func (r *Repo) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.QueryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, r.QueryTimeout)
+}
+
+// RowsFilter specifies which aggregate rows to fetch.
+type RowsFilter struct {
+	StartTime time.Time
+	EndTime   time.Time
+	// Incident types to filter to. All incident types are included if empty.
+	IncidentTypes []string
+	// Disjoint geohash cells to filter to. Not filtered on if nil.
+	Cells []string
 }
 
 const getAggregatesQuery = `
 select
     occurred_at,
     geo_id,
+    incident_type,
+    sum(incident_count) as incident_count
+from aggregate_buckets
+where
+    occurred_at >= $1
+    and occurred_at <= $2
+    and (coalesce(cardinality($3::text[]), 0) = 0 or incident_type = any($3))
+group by occurred_at, geo_id, incident_type
+order by occurred_at, geo_id, incident_type
+`
+
+// Rows are matched to each cell using a range over `geo_id`, so that every
+// cell is an index range scan.
+const getAggregatesWithinCellsQuery = `
+select
+    buckets.occurred_at,
+    buckets.geo_id,
+    buckets.incident_type,
+    sum(buckets.incident_count) as incident_count
+from unnest($4::text[]) as cells (cell)
+cross join lateral (
+    select occurred_at, geo_id, incident_type, incident_count
+    from aggregate_buckets
+    where
+        geo_id >= cells.cell
+        and geo_id < cells.cell || '~'
+        and occurred_at >= $1
+        and occurred_at <= $2
+        and (coalesce(cardinality($3::text[]), 0) = 0 or incident_type = any($3))
+) as buckets
+group by buckets.occurred_at, buckets.geo_id, buckets.incident_type
+order by buckets.occurred_at, buckets.geo_id, buckets.incident_type
+`
+
+func (r *Repo) queryAggregateRows(ctx context.Context, filter RowsFilter) (pgx.Rows, error) {
+	if filter.Cells == nil {
+		return r.conn.Query(ctx, getAggregatesQuery, filter.StartTime, filter.EndTime, filter.IncidentTypes)
+	}
+	return r.conn.Query(ctx, getAggregatesWithinCellsQuery, filter.StartTime, filter.EndTime, filter.IncidentTypes, filter.Cells)
+}
+
+// RowsRollup specifies how aggregate rows are rolled up in the database.
+type RowsRollup struct {
+	TimePrecision TimePrecision
+	GeoPrecision  int
+	Location      *time.Location
+}
+
+var calendarUnitFields = map[CalendarUnit]string{
+	CalendarWeek:    "week",
+	CalendarMonth:   "month",
+	CalendarQuarter: "quarter",
+	CalendarYear:    "year",
+}
+
+// bucketTimeArgs returns the arguments to the `bucket_time` database function,
+// for the rollup's time precision and location.
+func (r RowsRollup) bucketTimeArgs() []any {
+	if r.TimePrecision.IsCalendar() {
+		return []any{nil, calendarUnitFields[r.TimePrecision.Unit], r.Location.String()}
+	}
+	return []any{r.TimePrecision.Duration, nil, r.Location.String()}
+}
+
+const rollupAggregatesQuery = `
+select
+    bucket_time(occurred_at, $4::interval, $5::text, $6::text) as occurred_at,
+    left(geo_id, $7) as geo_id,
+    incident_type,
     sum(incident_count) as incident_count
 from aggregate_buckets
-where occurred_at >= $1 and occurred_at <= $2
-group by occurred_at, geo_id
-order by occurred_at, geo_id
+where
+    occurred_at >= $1
+    and occurred_at <= $2
+    and (coalesce(cardinality($3::text[]), 0) = 0 or incident_type = any($3))
+group by 1, 2, 3
+order by 1, 2, 3
+`
+
+const rollupAggregatesWithinCellsQuery = `
+select
+    bucket_time(buckets.occurred_at, $4::interval, $5::text, $6::text) as occurred_at,
+    left(buckets.geo_id, $7) as geo_id,
+    buckets.incident_type,
+    sum(buckets.incident_count) as incident_count
+from unnest($8::text[]) as cells (cell)
+cross join lateral (
+    select occurred_at, geo_id, incident_type, incident_count
+    from aggregate_buckets
+    where
+        geo_id >= cells.cell
+        and geo_id < cells.cell || '~'
+        and occurred_at >= $1
+        and occurred_at <= $2
+        and (coalesce(cardinality($3::text[]), 0) = 0 or incident_type = any($3))
+) as buckets
+group by 1, 2, 3
+order by 1, 2, 3
+`
+
+// Rows within the rollup table's bounds, $8 and $9, are read from the rollup
+// table, and rows outside of them from `aggregate_buckets`.
+const rollupAggregatesFromTableQuery = `
+select
+    bucket_time(buckets.occurred_at, $4::interval, $5::text, $6::text) as occurred_at,
+    left(buckets.geo_id, $7) as geo_id,
+    buckets.incident_type,
+    sum(buckets.incident_count) as incident_count
+from (
+    select occurred_at, geo_id, incident_type, incident_count
+    from %[1]s
+    where
+        occurred_at > $8
+        and occurred_at <= $9
+        and (coalesce(cardinality($3::text[]), 0) = 0 or incident_type = any($3))
+    union all
+    select occurred_at, geo_id, incident_type, incident_count
+    from aggregate_buckets
+    where
+        (occurred_at >= $1 and occurred_at <= $8 or occurred_at > $9 and occurred_at <= $2)
+        and (coalesce(cardinality($3::text[]), 0) = 0 or incident_type = any($3))
+) as buckets
+group by 1, 2, 3
+order by 1, 2, 3
+`
+
+const rollupAggregatesFromTableWithinCellsQuery = `
+select
+    bucket_time(buckets.occurred_at, $4::interval, $5::text, $6::text) as occurred_at,
+    left(buckets.geo_id, $7) as geo_id,
+    buckets.incident_type,
+    sum(buckets.incident_count) as incident_count
+from unnest($10::text[]) as cells (cell)
+cross join lateral (
+    select occurred_at, geo_id, incident_type, incident_count
+    from %[1]s
+    where
+        geo_id >= cells.cell
+        and geo_id < cells.cell || '~'
+        and occurred_at > $8
+        and occurred_at <= $9
+        and (coalesce(cardinality($3::text[]), 0) = 0 or incident_type = any($3))
+    union all
+    select occurred_at, geo_id, incident_type, incident_count
+    from aggregate_buckets
+    where
+        geo_id >= cells.cell
+        and geo_id < cells.cell || '~'
+        and (occurred_at >= $1 and occurred_at <= $8 or occurred_at > $9 and occurred_at <= $2)
+        and (coalesce(cardinality($3::text[]), 0) = 0 or incident_type = any($3))
+) as buckets
+group by 1, 2, 3
+order by 1, 2, 3
 `
 
-func (r *Repo) GetAggregateRows(ctx context.Context, startTime, endTime time.Time) ([]AggregateRow, error) {
-	rows, err := r.conn.Query(ctx, getAggregatesQuery, startTime, endTime)
+// queryRollupAggregateRows rolls up rows from the coarsest rollup table which
+// can serve the query, falling back to `aggregate_buckets` otherwise.
+func (r *Repo) queryRollupAggregateRows(ctx context.Context, filter RowsFilter, rollup RowsRollup) (pgx.Rows, error) {
+	args := []any{filter.StartTime, filter.EndTime, filter.IncidentTypes}
+	args = append(args, rollup.bucketTimeArgs()...)
+	args = append(args, rollup.GeoPrecision)
+
+	table, ok := FindRollupTable(filter, rollup)
+	if ok {
+		lower, upper := table.Bounds(filter.StartTime, filter.EndTime)
+		if lower.Before(upper) {
+			args = append(args, lower, upper)
+			if filter.Cells == nil {
+				return r.conn.Query(ctx, fmt.Sprintf(rollupAggregatesFromTableQuery, table.Identifier()), args...)
+			}
+			args = append(args, filter.Cells)
+			return r.conn.Query(ctx, fmt.Sprintf(rollupAggregatesFromTableWithinCellsQuery, table.Identifier()), args...)
+		}
+	}
+
+	if filter.Cells == nil {
+		return r.conn.Query(ctx, rollupAggregatesQuery, args...)
+	}
+	args = append(args, filter.Cells)
+	return r.conn.Query(ctx, rollupAggregatesWithinCellsQuery, args...)
+}
+
+func collectAggregateRows(query string, rows pgx.Rows, err error) ([]AggregateRow, error) {
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	return pgx.CollectRows(rows, pgx.RowToStructByName[AggregateRow])
+	records, err := pgx.CollectRows(rows, pgx.RowToStructByName[AggregateRow])
+	queryRows.WithLabelValues(query).Observe(float64(len(records)))
+	return records, err
+}
+
+func forEachAggregateRow(query string, rows pgx.Rows, err error, fn func(AggregateRow) error) error {
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	numRows := 0
+	defer func() { queryRows.WithLabelValues(query).Observe(float64(numRows)) }()
+
+	for rows.Next() {
+		numRows++
+		row, err := pgx.RowToStructByName[AggregateRow](rows)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetAggregateRows fetches aggregates matching the given filter.
+func (r *Repo) GetAggregateRows(ctx context.Context, filter RowsFilter) ([]AggregateRow, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	ctx, span := startQuerySpan(ctx, "Repo.GetAggregateRows")
+	rows, err := r.queryAggregateRows(ctx, filter)
+	records, err := collectAggregateRows(queryAggregates, rows, err)
+	endSpan(span, err)
+	return records, err
+}
+
+// StreamAggregateRows fetches aggregates matching the given filter, calling
+// `fn` with each row as it is read, in order of occurrence time. Iteration
+// stops at the first error returned by `fn`.
+func (r *Repo) StreamAggregateRows(ctx context.Context, filter RowsFilter, fn func(AggregateRow) error) error {
+	ctx, span := startQuerySpan(ctx, "Repo.StreamAggregateRows")
+	rows, err := r.queryAggregateRows(ctx, filter)
+	err = forEachAggregateRow(queryAggregates, rows, err, fn)
+	endSpan(span, err)
+	return err
+}
+
+// RollupAggregateRows fetches aggregates matching the given filter, rolled up
+// to the given time and geohash precisions.
+func (r *Repo) RollupAggregateRows(ctx context.Context, filter RowsFilter, rollup RowsRollup) ([]AggregateRow, error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	ctx, span := startQuerySpan(ctx, "Repo.RollupAggregateRows")
+	rows, err := r.queryRollupAggregateRows(ctx, filter, rollup)
+	records, err := collectAggregateRows(queryRollupAggregates, rows, err)
+	endSpan(span, err)
+	return records, err
+}
+
+// StreamRollupAggregateRows fetches aggregates matching the given filter,
+// rolled up to the given time and geohash precisions, calling `fn` with each
+// row as it is read, in order of occurrence time. Iteration stops at the first
+// error returned by `fn`.
+func (r *Repo) StreamRollupAggregateRows(ctx context.Context, filter RowsFilter, rollup RowsRollup, fn func(AggregateRow) error) error {
+	ctx, span := startQuerySpan(ctx, "Repo.StreamRollupAggregateRows")
+	rows, err := r.queryRollupAggregateRows(ctx, filter, rollup)
+	err = forEachAggregateRow(queryRollupAggregates, rows, err, fn)
+	endSpan(span, err)
+	return err
+}
+
+// Rollup table rows are incremented by the rolled up counts of the inserted
+// rows.
+const incrementRollupTableStmt = `
+insert into %[1]s (occurred_at, geo_id, incident_type, incident_count)
+select
+    bucket_time(occurred_at, $1::interval, null, $2::text),
+    left(geo_id, $3),
+    incident_type,
+    sum(incident_count)
+from unnest($4::timestamp[], $5::text[], $6::text[], $7::int[]) as rows (occurred_at, geo_id, incident_type, incident_count)
+group by 1, 2, 3
+on conflict (occurred_at, geo_id, incident_type) do update
+set incident_count = %[1]s.incident_count + excluded.incident_count
+`
+
+// Rollup table rows which the upserted rows fall into are recomputed from
+// `aggregate_buckets`, as the replaced counts are unknown.
+const recomputeRollupTableStmt = `
+insert into %[1]s (occurred_at, geo_id, incident_type, incident_count)
+select
+    affected.occurred_at,
+    affected.geo_id,
+    affected.incident_type,
+    sum(buckets.incident_count)
+from (
+    select distinct
+        bucket_time(occurred_at, $1::interval, null, $2::text) as occurred_at,
+        left(geo_id, $3) as geo_id,
+        incident_type
+    from unnest($4::timestamp[], $5::text[], $6::text[]) as rows (occurred_at, geo_id, incident_type)
+) as affected
+join aggregate_buckets as buckets on
+    -- Daylight saving time transitions lengthen buckets by up to an hour.
+    buckets.occurred_at > affected.occurred_at - $1::interval - interval '1 hour'
+    and buckets.occurred_at <= affected.occurred_at
+    and bucket_time(buckets.occurred_at, $1::interval, null, $2::text) = affected.occurred_at
+    and buckets.geo_id >= affected.geo_id
+    and buckets.geo_id < affected.geo_id || '~'
+    and buckets.incident_type = affected.incident_type
+group by 1, 2, 3
+on conflict (occurred_at, geo_id, incident_type) do update
+set incident_count = excluded.incident_count
+`
+
+// aggregateColumns returns the records' values as columns, to be passed as
+// arrays to `unnest`.
+func aggregateColumns(records []AggregateRow) ([]time.Time, []string, []string, []int32) {
+	occurredAts := make([]time.Time, len(records))
+	geohashes := make([]string, len(records))
+	incidentTypes := make([]string, len(records))
+	counts := make([]int32, len(records))
+	for idx, record := range records {
+		occurredAts[idx] = record.OccurredAt
+		geohashes[idx] = record.Geohash
+		incidentTypes[idx] = record.IncidentType
+		counts[idx] = record.Count
+	}
+	return occurredAts, geohashes, incidentTypes, counts
 }
 
-func (r *Repo) InsertAggregateRows(ctx context.Context, records []AggregateRow) error {
+func (r *Repo) InsertAggregateRows(ctx context.Context, records []AggregateRow) (err error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	ctx, span := startQuerySpan(ctx, "Repo.InsertAggregateRows")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
 	rows := make([][]any, len(records))
 	for idx, record := range records {
-		row := make([]any, 3)
+		row := make([]any, 4)
 		row[0] = record.OccurredAt
 		row[1] = record.Geohash
-		row[2] = record.Count
+		row[2] = record.IncidentType
+		row[3] = record.Count
 		rows[idx] = row
 	}
 
-	_, err := r.conn.CopyFrom(
+	_, err = tx.CopyFrom(
 		ctx,
 		pgx.Identifier([]string{"aggregate_buckets"}),
-		[]string{"occurred_at", "geo_id", "incident_count"},
+		[]string{"occurred_at", "geo_id", "incident_type", "incident_count"},
 		pgx.CopyFromRows(rows),
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	occurredAts, geohashes, incidentTypes, counts := aggregateColumns(records)
+	batch := &pgx.Batch{}
+	for _, table := range RollupTables {
+		batch.Queue(
+			fmt.Sprintf(incrementRollupTableStmt, table.Identifier()),
+			table.TimePrecision,
+			table.Location.String(),
+			table.GeoPrecision,
+			occurredAts,
+			geohashes,
+			incidentTypes,
+			counts,
+		)
+	}
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
 const upsertAggregateStmt = `
 with delete_existing as (
     delete from aggregate_buckets
-    where occurred_at = $1 and geo_id = $2
+    where occurred_at = $1 and geo_id = $2 and incident_type = $3
 )
-insert into aggregate_buckets (occurred_at, geo_id, incident_count)
-values ($1, $2, $3)
+insert into aggregate_buckets (occurred_at, geo_id, incident_type, incident_count)
+values ($1, $2, $3, $4)
 `
 
-func (r *Repo) UpsertAggregateRows(ctx context.Context, records []AggregateRow) error {
+func (r *Repo) UpsertAggregateRows(ctx context.Context, records []AggregateRow) (err error) {
+	ctx, cancel := r.withQueryTimeout(ctx)
+	defer cancel()
+
+	ctx, span := startQuerySpan(ctx, "Repo.UpsertAggregateRows")
+	defer func() { endSpan(span, err) }()
+
 	tx, err := r.conn.Begin(ctx)
 	if err != nil {
 		return err
@@ -76,7 +449,21 @@ func (r *Repo) UpsertAggregateRows(ctx context.Context, records []AggregateRow)
 
 	batch := &pgx.Batch{}
 	for _, record := range records {
-		batch.Queue(upsertAggregateStmt, record.OccurredAt, record.Geohash, record.Count)
+		batch.Queue(upsertAggregateStmt, record.OccurredAt, record.Geohash, record.IncidentType, record.Count)
+	}
+
+	// Rollup tables are recomputed after all rows have been upserted.
+	occurredAts, geohashes, incidentTypes, _ := aggregateColumns(records)
+	for _, table := range RollupTables {
+		batch.Queue(
+			fmt.Sprintf(recomputeRollupTableStmt, table.Identifier()),
+			table.TimePrecision,
+			table.Location.String(),
+			table.GeoPrecision,
+			occurredAts,
+			geohashes,
+			incidentTypes,
+		)
 	}
 
 	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
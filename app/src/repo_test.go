@@ -0,0 +1,16 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRowsRollupBucketTimeArgs(t *testing.T) {
+	fixed := RowsRollup{TimePrecision: FixedTimePrecision(time.Hour), Location: time.UTC}
+	assert.Equal(t, []any{time.Hour, nil, "UTC"}, fixed.bucketTimeArgs())
+
+	calendar := RowsRollup{TimePrecision: CalendarTimePrecision(CalendarQuarter), Location: time.UTC}
+	assert.Equal(t, []any{nil, "quarter", "UTC"}, calendar.bucketTimeArgs())
+}
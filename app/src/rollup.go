@@ -4,22 +4,107 @@ import (
 	"time"
 )
 
-func BucketTime(t time.Time, precision time.Duration) time.Time {
-	truncated := t.Truncate(precision)
-	if truncated == t {
-		return truncated
+// BucketTime rounds the given time up to `precision`, such that the given time
+// occurred no later than the returned time. Buckets are aligned to the wall
+// clock in the given location, so that e.g. 24h buckets correspond to local
+// days across daylight saving time transitions, and calendar buckets start at
+// local midnight.
+func BucketTime(t time.Time, precision TimePrecision, loc *time.Location) time.Time {
+	if precision.IsCalendar() {
+		return bucketCalendarTime(t, precision.Unit, loc)
 	}
-	return truncated.Add(precision)
+	return bucketFixedTime(t, precision.Duration, loc)
+}
+
+func bucketFixedTime(t time.Time, precision time.Duration, loc *time.Location) time.Time {
+	return alignFixedTime(t, precision, loc, true)
+}
+
+// TruncateTime rounds the given time down to `precision`, such that the
+// returned time is the latest bucket boundary no later than the given time.
+// Boundaries are the same as for BucketTime.
+func TruncateTime(t time.Time, precision TimePrecision, loc *time.Location) time.Time {
+	if precision.IsCalendar() {
+		start, _ := calendarPeriod(t, precision.Unit, loc)
+		return start.UTC()
+	}
+	return alignFixedTime(t, precision.Duration, loc, false)
+}
+
+// alignFixedTime rounds the given time, either up or down, to a multiple of
+// `precision` on the wall clock in the given location.
+func alignFixedTime(t time.Time, precision time.Duration, loc *time.Location, roundUp bool) time.Time {
+	_, offset := t.In(loc).Zone()
+	shift := time.Duration(offset) * time.Second
+
+	wall := t.Add(shift)
+	truncated := wall.Truncate(precision)
+	if roundUp && !truncated.Equal(wall) {
+		truncated = truncated.Add(precision)
+	}
+	bucket := truncated.Add(-shift)
+
+	_, bucketOffset := bucket.In(loc).Zone()
+	if bucketOffset != offset {
+		// A daylight saving time transition occurs within the bucket, so align
+		// the bucket to the wall clock on the other side of the transition,
+		// unless that wall clock time was skipped over.
+		adjusted := bucket.Add(time.Duration(offset-bucketOffset) * time.Second)
+		if roundUp && !adjusted.Before(t) || !roundUp && !adjusted.After(t) {
+			bucket = adjusted
+		}
+	}
+
+	return bucket.UTC()
+}
+
+// calendarPeriod returns the bounds of the calendar period containing the
+// given time. Weeks are ISO weeks, starting on Monday, and quarters start in
+// January, April, July and October.
+func calendarPeriod(t time.Time, unit CalendarUnit, loc *time.Location) (start, end time.Time) {
+	local := t.In(loc)
+	year, month, day := local.Date()
+
+	switch unit {
+	case CalendarWeek:
+		daysSinceMonday := (int(local.Weekday()) + 6) % 7
+		start = time.Date(year, month, day-daysSinceMonday, 0, 0, 0, 0, loc)
+		end = start.AddDate(0, 0, 7)
+	case CalendarMonth:
+		start = time.Date(year, month, 1, 0, 0, 0, 0, loc)
+		end = start.AddDate(0, 1, 0)
+	case CalendarQuarter:
+		quarterMonth := month - (month-1)%3
+		start = time.Date(year, quarterMonth, 1, 0, 0, 0, 0, loc)
+		end = start.AddDate(0, 3, 0)
+	case CalendarYear:
+		start = time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+		end = start.AddDate(1, 0, 0)
+	default:
+		panic("Unknown calendar unit")
+	}
+	return
+}
+
+// bucketCalendarTime rounds the given time up to the start of the next
+// calendar period, unless it is the start of a period.
+func bucketCalendarTime(t time.Time, unit CalendarUnit, loc *time.Location) time.Time {
+	start, end := calendarPeriod(t, unit, loc)
+	if start.Equal(t) {
+		return start.UTC()
+	}
+	return end.UTC()
 }
 
 func BucketGeo(geohash string, precision int) string {
 	return geohash[:precision]
 }
 
-func Rollup(rows []AggregateRow, timePrecision time.Duration, geoPrecision int) []Aggregate {
+func Rollup(rows []AggregateRow, timePrecision TimePrecision, geoPrecision int, loc *time.Location) []Aggregate {
 	type Bucket struct {
-		OccurredAt time.Time
-		Geohash    string
+		OccurredAt   time.Time
+		Geohash      string
+		IncidentType string
 	}
 
 	// Maintain ordering of `records` while rolling up.
@@ -28,13 +113,15 @@ func Rollup(rows []AggregateRow, timePrecision time.Duration, geoPrecision int)
 	rollupIndex := -1
 	for _, row := range rows {
 		bucket := Bucket{
-			OccurredAt: BucketTime(row.OccurredAt, timePrecision),
-			Geohash:    BucketGeo(row.Geohash, geoPrecision),
+			OccurredAt:   BucketTime(row.OccurredAt, timePrecision, loc),
+			Geohash:      BucketGeo(row.Geohash, geoPrecision),
+			IncidentType: row.IncidentType,
 		}
 		rollup := Aggregate{
-			OccurredAt: bucket.OccurredAt,
-			Geohash:    bucket.Geohash,
-			Count:      row.Count,
+			OccurredAt:   bucket.OccurredAt,
+			Geohash:      bucket.Geohash,
+			IncidentType: bucket.IncidentType,
+			Count:        row.Count,
 		}
 
 		if rollupIndex == -1 {
@@ -56,3 +143,75 @@ func Rollup(rows []AggregateRow, timePrecision time.Duration, geoPrecision int)
 
 	return rollups[:rollupIndex+1]
 }
+
+type rollupKey struct {
+	Geohash      string
+	IncidentType string
+}
+
+// StreamingRollup rolls up rows incrementally, as they are added. Rows must be
+// added in order of occurrence time, so that only aggregates for the current
+// time bucket need to be held. Aggregates are emitted in the same order as by
+// Rollup.
+type StreamingRollup struct {
+	TimePrecision TimePrecision
+	GeoPrecision  int
+	Location      *time.Location
+
+	emit       func(Aggregate) error
+	bucketTime time.Time
+	pending    []Aggregate
+	indexes    map[rollupKey]int
+}
+
+func NewStreamingRollup(timePrecision TimePrecision, geoPrecision int, loc *time.Location, emit func(Aggregate) error) *StreamingRollup {
+	return &StreamingRollup{
+		TimePrecision: timePrecision,
+		GeoPrecision:  geoPrecision,
+		Location:      loc,
+		emit:          emit,
+		pending:       []Aggregate{},
+		indexes:       make(map[rollupKey]int),
+	}
+}
+
+// Add rolls up the row. If the row belongs to a later time bucket than the
+// previously added rows, aggregates for the previous time bucket are emitted.
+func (r *StreamingRollup) Add(row AggregateRow) error {
+	bucketTime := BucketTime(row.OccurredAt, r.TimePrecision, r.Location)
+	if !bucketTime.Equal(r.bucketTime) {
+		if err := r.Flush(); err != nil {
+			return err
+		}
+		r.bucketTime = bucketTime
+	}
+
+	key := rollupKey{Geohash: BucketGeo(row.Geohash, r.GeoPrecision), IncidentType: row.IncidentType}
+	idx, ok := r.indexes[key]
+	if ok {
+		r.pending[idx].Count += row.Count
+		return nil
+	}
+
+	r.indexes[key] = len(r.pending)
+	r.pending = append(r.pending, Aggregate{
+		OccurredAt:   bucketTime,
+		Geohash:      key.Geohash,
+		IncidentType: key.IncidentType,
+		Count:        row.Count,
+	})
+	return nil
+}
+
+// Flush emits aggregates for the current time bucket.
+func (r *StreamingRollup) Flush() error {
+	for _, aggregate := range r.pending {
+		if err := r.emit(aggregate); err != nil {
+			return err
+		}
+	}
+
+	r.pending = r.pending[:0]
+	clear(r.indexes)
+	return nil
+}
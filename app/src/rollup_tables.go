@@ -0,0 +1,118 @@
+package main
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// RollupTable is a table of aggregates pre-aggregated to a coarser time and
+// geohash precision than `aggregate_buckets`, which is kept up to date as
+// aggregates are written. Time buckets are aligned to the wall clock in the
+// table's location, as with BucketTime.
+type RollupTable struct {
+	Name          string
+	TimePrecision time.Duration
+	GeoPrecision  int
+	Location      *time.Location
+}
+
+// RollupTables are ordered from coarsest to finest, and must match the tables
+// created by the database migrations.
+var RollupTables = []RollupTable{
+	{Name: "aggregate_buckets_daily", TimePrecision: time.Duration(24) * time.Hour, GeoPrecision: 4, Location: DefaultLocation},
+	{Name: "aggregate_buckets_hourly", TimePrecision: time.Hour, GeoPrecision: 5, Location: time.UTC},
+}
+
+// Timezone offsets are only compared from the Unix epoch onwards, as many
+// timezones used irregular local mean time offsets historically, and no
+// incidents are recorded before then.
+var offsetsComparedSince = time.Unix(0, 0).UTC()
+
+func (t RollupTable) Identifier() string {
+	return pgx.Identifier{t.Name}.Sanitize()
+}
+
+// Serves returns whether rows matching the filter can be rolled up from the
+// table. The table's buckets must nest within the rollup's buckets, and be no
+// coarser than the cells filtered to.
+func (t RollupTable) Serves(filter RowsFilter, rollup RowsRollup) bool {
+	if rollup.GeoPrecision > t.GeoPrecision {
+		return false
+	}
+
+	for _, cell := range filter.Cells {
+		if len(cell) > t.GeoPrecision {
+			return false
+		}
+	}
+
+	// Calendar periods start at local midnight, which is a boundary of any
+	// precision which divides a day.
+	precision := rollup.TimePrecision.Duration
+	if rollup.TimePrecision.IsCalendar() {
+		precision = time.Duration(24) * time.Hour
+	}
+	if precision%t.TimePrecision != 0 {
+		return false
+	}
+
+	if rollup.Location.String() == t.Location.String() {
+		return true
+	}
+	return OffsetsAligned(rollup.Location, t.Location, filter.StartTime, filter.EndTime, t.TimePrecision)
+}
+
+// Bounds returns the table bucket boundaries nearest to the start and end
+// times, within the time range. Table rows occurring after `lower` and no later
+// than `upper` cover exactly the same time range as the underlying rows.
+func (t RollupTable) Bounds(start, end time.Time) (lower, upper time.Time) {
+	lower = BucketTime(start, FixedTimePrecision(t.TimePrecision), t.Location)
+	upper = TruncateTime(end, FixedTimePrecision(t.TimePrecision), t.Location)
+	return
+}
+
+// FindRollupTable returns the coarsest rollup table which can serve the
+// filter and rollup, if any.
+func FindRollupTable(filter RowsFilter, rollup RowsRollup) (RollupTable, bool) {
+	for _, table := range RollupTables {
+		if table.Serves(filter, rollup) {
+			return table, true
+		}
+	}
+	return RollupTable{}, false
+}
+
+// OffsetsAligned returns whether the UTC offsets of two locations differ by a
+// multiple of `precision` throughout the time range, such that wall clock
+// multiples of `precision` in one location are also so in the other.
+func OffsetsAligned(a, b *time.Location, start, end time.Time, precision time.Duration) bool {
+	t := start
+	if t.Before(offsetsComparedSince) {
+		t = offsetsComparedSince
+	}
+
+	for !t.After(end) {
+		_, offsetA := t.In(a).Zone()
+		_, offsetB := t.In(b).Zone()
+		if (time.Duration(offsetA-offsetB)*time.Second)%precision != 0 {
+			return false
+		}
+
+		// Advance to the next transition in either location.
+		_, endA := t.In(a).ZoneBounds()
+		_, endB := t.In(b).ZoneBounds()
+		switch {
+		case endA.IsZero() && endB.IsZero():
+			return true
+		case endA.IsZero():
+			t = endB
+		case endB.IsZero() || endA.Before(endB):
+			t = endA
+		default:
+			t = endB
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateTime(t *testing.T) {
+	loc := MustLoadLocation("America/Los_Angeles")
+
+	type testCase struct {
+		Name      string
+		Timestamp time.Time
+		Precision time.Duration
+		Expected  time.Time
+	}
+
+	testCases := []testCase{
+		{
+			Name:      "Hour",
+			Timestamp: time.Date(2025, 1, 1, 13, 5, 0, 0, loc),
+			Precision: time.Hour,
+			Expected:  time.Date(2025, 1, 1, 13, 0, 0, 0, loc),
+		},
+		{
+			Name:      "Local day at boundary",
+			Timestamp: time.Date(2025, 1, 2, 0, 0, 0, 0, loc),
+			Precision: 24 * time.Hour,
+			Expected:  time.Date(2025, 1, 2, 0, 0, 0, 0, loc),
+		},
+		{
+			Name:      "Local day with spring forward",
+			Timestamp: time.Date(2025, 3, 9, 12, 0, 0, 0, loc),
+			Precision: 24 * time.Hour,
+			Expected:  time.Date(2025, 3, 9, 0, 0, 0, 0, loc),
+		},
+		{
+			Name:      "Local day with fall back",
+			Timestamp: time.Date(2025, 11, 2, 12, 0, 0, 0, loc),
+			Precision: 24 * time.Hour,
+			Expected:  time.Date(2025, 11, 2, 0, 0, 0, 0, loc),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			actual := TruncateTime(tc.Timestamp, FixedTimePrecision(tc.Precision), loc)
+			assert.Equal(t, tc.Expected.UTC(), actual)
+		})
+	}
+}
+
+func TestOffsetsAligned(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	losAngeles := MustLoadLocation("America/Los_Angeles")
+	kolkata := MustLoadLocation("Asia/Kolkata")
+
+	assert.True(t, OffsetsAligned(losAngeles, time.UTC, start, end, time.Hour))
+	assert.True(t, OffsetsAligned(losAngeles, time.UTC, time.Time{}, end, time.Hour))
+	assert.False(t, OffsetsAligned(kolkata, time.UTC, start, end, time.Hour))
+	assert.False(t, OffsetsAligned(losAngeles, time.UTC, start, end, 24*time.Hour))
+}
+
+func TestFindRollupTable(t *testing.T) {
+	losAngeles := MustLoadLocation("America/Los_Angeles")
+	kolkata := MustLoadLocation("Asia/Kolkata")
+	filter := RowsFilter{
+		StartTime: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	type testCase struct {
+		Name     string
+		Filter   RowsFilter
+		Rollup   RowsRollup
+		Expected string
+	}
+
+	testCases := []testCase{
+		{
+			Name:     "Daily",
+			Filter:   filter,
+			Rollup:   RowsRollup{TimePrecision: CalendarTimePrecision(CalendarMonth), GeoPrecision: 4, Location: losAngeles},
+			Expected: "aggregate_buckets_daily",
+		},
+		{
+			Name:     "Hourly when finer geohash precision",
+			Filter:   filter,
+			Rollup:   RowsRollup{TimePrecision: CalendarTimePrecision(CalendarMonth), GeoPrecision: 5, Location: losAngeles},
+			Expected: "aggregate_buckets_hourly",
+		},
+		{
+			Name:     "Hourly when other timezone",
+			Filter:   filter,
+			Rollup:   RowsRollup{TimePrecision: FixedTimePrecision(24 * time.Hour), GeoPrecision: 4, Location: time.UTC},
+			Expected: "aggregate_buckets_hourly",
+		},
+		{
+			Name:     "Hourly when finer time precision",
+			Filter:   filter,
+			Rollup:   RowsRollup{TimePrecision: FixedTimePrecision(6 * time.Hour), GeoPrecision: 4, Location: losAngeles},
+			Expected: "aggregate_buckets_hourly",
+		},
+		{
+			Name:   "None when finer cells",
+			Filter: RowsFilter{StartTime: filter.StartTime, EndTime: filter.EndTime, Cells: []string{"9q8yyq"}},
+			Rollup: RowsRollup{TimePrecision: FixedTimePrecision(time.Hour), GeoPrecision: 4, Location: losAngeles},
+		},
+		{
+			Name:   "None when finer time precision",
+			Filter: filter,
+			Rollup: RowsRollup{TimePrecision: FixedTimePrecision(15 * time.Minute), GeoPrecision: 4, Location: losAngeles},
+		},
+		{
+			Name:   "None when misaligned timezone",
+			Filter: filter,
+			Rollup: RowsRollup{TimePrecision: FixedTimePrecision(time.Hour), GeoPrecision: 4, Location: kolkata},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			table, ok := FindRollupTable(tc.Filter, tc.Rollup)
+			assert.Equal(t, tc.Expected != "", ok)
+			assert.Equal(t, tc.Expected, table.Name)
+		})
+	}
+}
+
+func TestRollupTableBounds(t *testing.T) {
+	table := RollupTables[0]
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	end := time.Date(2025, 1, 3, 9, 0, 0, 0, time.UTC)
+
+	lower, upper := table.Bounds(start, end)
+	assert.Equal(t, time.Date(2025, 1, 2, 8, 0, 0, 0, time.UTC), lower)
+	assert.Equal(t, time.Date(2025, 1, 3, 8, 0, 0, 0, time.UTC), upper)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -8,18 +9,150 @@ import (
 )
 
 func TestBucketTime(t *testing.T) {
-	precision := time.Hour
+	precision := FixedTimePrecision(time.Hour)
 	expected := time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC)
 
 	for _, timestamp := range []time.Time{
 		time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC),
 		time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC),
 	} {
-		actual := BucketTime(timestamp, precision)
+		actual := BucketTime(timestamp, precision, time.UTC)
 		assert.Equal(t, expected, actual)
 	}
 }
 
+func TestBucketTimeInLocation(t *testing.T) {
+	loc := MustLoadLocation("America/Los_Angeles")
+
+	type testCase struct {
+		Name      string
+		Timestamp time.Time
+		Precision TimePrecision
+		Expected  time.Time
+	}
+
+	testCases := []testCase{
+		{
+			Name:      "Local day",
+			Timestamp: time.Date(2025, 1, 1, 20, 0, 0, 0, loc),
+			Precision: FixedTimePrecision(24 * time.Hour),
+			Expected:  time.Date(2025, 1, 2, 0, 0, 0, 0, loc),
+		},
+		{
+			Name:      "Local day at boundary",
+			Timestamp: time.Date(2025, 1, 2, 0, 0, 0, 0, loc),
+			Precision: FixedTimePrecision(24 * time.Hour),
+			Expected:  time.Date(2025, 1, 2, 0, 0, 0, 0, loc),
+		},
+		{
+			Name:      "Local day with spring forward",
+			Timestamp: time.Date(2025, 3, 9, 1, 0, 0, 0, loc),
+			Precision: FixedTimePrecision(24 * time.Hour),
+			Expected:  time.Date(2025, 3, 10, 0, 0, 0, 0, loc),
+		},
+		{
+			Name:      "Local day with fall back",
+			Timestamp: time.Date(2025, 11, 2, 0, 30, 0, 0, loc),
+			Precision: FixedTimePrecision(24 * time.Hour),
+			Expected:  time.Date(2025, 11, 3, 0, 0, 0, 0, loc),
+		},
+		{
+			Name:      "Quarter day with spring forward",
+			Timestamp: time.Date(2025, 3, 9, 1, 30, 0, 0, loc),
+			Precision: FixedTimePrecision(6 * time.Hour),
+			Expected:  time.Date(2025, 3, 9, 6, 0, 0, 0, loc),
+		},
+		{
+			Name:      "Skipped hour with spring forward",
+			Timestamp: time.Date(2025, 3, 9, 1, 30, 0, 0, loc),
+			Precision: FixedTimePrecision(time.Hour),
+			Expected:  time.Date(2025, 3, 9, 3, 0, 0, 0, loc),
+		},
+		{
+			Name:      "Repeated hour with fall back",
+			Timestamp: time.Date(2025, 11, 2, 9, 30, 0, 0, time.UTC), // 01:30 PST.
+			Precision: FixedTimePrecision(time.Hour),
+			Expected:  time.Date(2025, 11, 2, 10, 0, 0, 0, time.UTC), // 02:00 PST.
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			actual := BucketTime(tc.Timestamp, tc.Precision, loc)
+			assert.Equal(t, tc.Expected.UTC(), actual)
+		})
+	}
+}
+
+func TestBucketTimeWithCalendarPrecision(t *testing.T) {
+	loc := MustLoadLocation("America/Los_Angeles")
+
+	type testCase struct {
+		Name      string
+		Timestamp time.Time
+		Unit      CalendarUnit
+		Expected  time.Time
+	}
+
+	testCases := []testCase{
+		{
+			Name:      "ISO week",
+			Timestamp: time.Date(2025, 1, 1, 13, 0, 0, 0, loc), // Wednesday.
+			Unit:      CalendarWeek,
+			Expected:  time.Date(2025, 1, 6, 0, 0, 0, 0, loc),
+		},
+		{
+			Name:      "ISO week from Sunday",
+			Timestamp: time.Date(2025, 1, 5, 23, 0, 0, 0, loc),
+			Unit:      CalendarWeek,
+			Expected:  time.Date(2025, 1, 6, 0, 0, 0, 0, loc),
+		},
+		{
+			Name:      "ISO week at boundary",
+			Timestamp: time.Date(2025, 1, 6, 0, 0, 0, 0, loc),
+			Unit:      CalendarWeek,
+			Expected:  time.Date(2025, 1, 6, 0, 0, 0, 0, loc),
+		},
+		{
+			Name:      "ISO week in UTC on local boundary",
+			Timestamp: time.Date(2025, 1, 6, 2, 0, 0, 0, time.UTC), // Sunday in local time.
+			Unit:      CalendarWeek,
+			Expected:  time.Date(2025, 1, 6, 0, 0, 0, 0, loc),
+		},
+		{
+			Name:      "Month",
+			Timestamp: time.Date(2025, 2, 14, 13, 0, 0, 0, loc),
+			Unit:      CalendarMonth,
+			Expected:  time.Date(2025, 3, 1, 0, 0, 0, 0, loc),
+		},
+		{
+			Name:      "Month with daylight saving time transition",
+			Timestamp: time.Date(2025, 3, 14, 13, 0, 0, 0, loc),
+			Unit:      CalendarMonth,
+			Expected:  time.Date(2025, 4, 1, 0, 0, 0, 0, loc),
+		},
+		{
+			Name:      "Quarter",
+			Timestamp: time.Date(2025, 5, 14, 13, 0, 0, 0, loc),
+			Unit:      CalendarQuarter,
+			Expected:  time.Date(2025, 7, 1, 0, 0, 0, 0, loc),
+		},
+		{
+			Name:      "Year",
+			Timestamp: time.Date(2025, 12, 31, 23, 0, 0, 0, loc),
+			Unit:      CalendarYear,
+			Expected:  time.Date(2026, 1, 1, 0, 0, 0, 0, loc),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			actual := BucketTime(tc.Timestamp, CalendarTimePrecision(tc.Unit), loc)
+			assert.Equal(t, tc.Expected.UTC(), actual)
+		})
+	}
+}
+
 func TestBucketGeo(t *testing.T) {
 	geohash := "abcdefg"
 	precision := 5
@@ -28,20 +161,62 @@ func TestBucketGeo(t *testing.T) {
 }
 
 func TestRollup(t *testing.T) {
-	timePrecision := time.Hour
+	timePrecision := FixedTimePrecision(time.Hour)
 	geoPrecision := 6
 	records := []AggregateRow{
-		{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde11", Count: 1},
-		{OccurredAt: time.Date(2025, 1, 1, 13, 8, 0, 0, time.UTC), Geohash: "abcde21", Count: 2},
-		{OccurredAt: time.Date(2025, 1, 1, 13, 9, 0, 0, time.UTC), Geohash: "abcde11", Count: 1},
-		{OccurredAt: time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC), Geohash: "abcde11", Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 8, 0, 0, time.UTC), Geohash: "abcde21", IncidentType: IncidentTypePoliceIncident, Count: 2},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 9, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 9, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypeTrafficCrash, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
 	}
 	expected := []Aggregate{
-		{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcde1", Count: 2},
-		{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcde2", Count: 2},
-		{OccurredAt: time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC), Geohash: "abcde1", Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcde1", IncidentType: IncidentTypePoliceIncident, Count: 2},
+		{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcde2", IncidentType: IncidentTypePoliceIncident, Count: 2},
+		{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcde1", IncidentType: IncidentTypeTrafficCrash, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC), Geohash: "abcde1", IncidentType: IncidentTypePoliceIncident, Count: 1},
 	}
 
-	actual := Rollup(records, timePrecision, geoPrecision)
+	actual := Rollup(records, timePrecision, geoPrecision, time.UTC)
 	assert.Equal(t, expected, actual)
 }
+
+func TestStreamingRollup(t *testing.T) {
+	timePrecision := FixedTimePrecision(time.Hour)
+	geoPrecision := 6
+	records := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 8, 0, 0, time.UTC), Geohash: "abcde21", IncidentType: IncidentTypePoliceIncident, Count: 2},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 9, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 9, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypeTrafficCrash, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC), Geohash: "abcde11", IncidentType: IncidentTypePoliceIncident, Count: 1},
+	}
+
+	actual := []Aggregate{}
+	rollup := NewStreamingRollup(timePrecision, geoPrecision, time.UTC, func(record Aggregate) error {
+		actual = append(actual, record)
+		return nil
+	})
+	for _, record := range records {
+		assert.Nil(t, rollup.Add(record))
+	}
+
+	// Aggregates are only emitted once their time bucket is complete.
+	assert.Len(t, actual, 3)
+
+	assert.Nil(t, rollup.Flush())
+	assert.Equal(t, Rollup(records, timePrecision, geoPrecision, time.UTC), actual)
+}
+
+func TestStreamingRollupWhenEmitError(t *testing.T) {
+	emitErr := errors.New("Emit error")
+	rollup := NewStreamingRollup(FixedTimePrecision(time.Hour), 6, time.UTC, func(record Aggregate) error {
+		return emitErr
+	})
+
+	err := rollup.Add(AggregateRow{OccurredAt: time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC), Geohash: "abcde11", Count: 1})
+	assert.Nil(t, err)
+
+	err = rollup.Add(AggregateRow{OccurredAt: time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC), Geohash: "abcde11", Count: 1})
+	assert.ErrorIs(t, emitErr, err)
+}
@@ -5,23 +5,75 @@ import (
 	"errors"
 	"io"
 	"net/url"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/mmcloughlin/geohash"
+)
+
+const (
+	IncidentType311Case        = "311_case"
+	IncidentTypeFireEMSCall    = "fire_ems_call"
+	IncidentTypeFireIncident   = "fire_incident"
+	IncidentTypePoliceIncident = "police_incident"
+	IncidentTypeTrafficCrash   = "traffic_crash"
 )
 
 const (
-	DefaultTimePrecision = time.Minute
-	DefaultGeoPrecision  = 7
-	MinGeoPrecision      = 1
-	MaxGeoPrecision      = 7
-	timestampLayout      = "2006-01-02T15:04Z"
+	FormatJSON     = "json"
+	FormatGeoJSON  = "geojson"
+	FormatCSV      = "csv"
+	FormatNDJSON   = "ndjson"
+	FormatProtobuf = "protobuf"
+	FormatArrow    = "arrow"
 )
 
+const (
+	DefaultGeoPrecision = 7
+	DefaultTimezone     = "America/Los_Angeles"
+	MinGeoPrecision     = 1
+	MaxGeoPrecision     = 7
+	timestampLayout     = "2006-01-02T15:04Z"
+	// Length of the geohashes aggregates are stored with, which must be fine
+	// enough to be queried at any precision.
+	MinAggregateGeohashLength = MaxGeoPrecision
+	MaxAggregateGeohashLength = 12
+)
+
+var DefaultTimePrecision = FixedTimePrecision(time.Minute)
+
 var (
 	ErrInvalidTimePrecision = errors.New("Invalid time precision")
 	ErrInvalidGeoPrecision  = errors.New("Invalid geohash precision")
+	ErrInvalidIncidentType  = errors.New("Invalid incident type")
+	ErrInvalidBoundingBox   = errors.New("Invalid bounding box")
+	ErrInvalidGeohashPrefix = errors.New("Invalid geohash prefix")
+	ErrInvalidFormat        = errors.New("Invalid response format")
+	ErrInvalidTimezone      = errors.New("Invalid timezone")
+	ErrInvalidAggregates    = errors.New("Invalid aggregates")
+	ErrInvalidGeohash       = errors.New("Invalid geohash")
 )
 
+var DefaultLocation = MustLoadLocation(DefaultTimezone)
+
+func MustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+var IncidentTypes = []string{
+	IncidentType311Case,
+	IncidentTypeFireEMSCall,
+	IncidentTypeFireIncident,
+	IncidentTypePoliceIncident,
+	IncidentTypeTrafficCrash,
+}
+
 func GetParam[T any](params url.Values, name string, defaultValue T, parse func(string) (T, error)) (T, error) {
 	value := params.Get(name)
 	if value == "" {
@@ -34,14 +86,62 @@ func ParseTimestamp(s string) (time.Time, error) {
 	return time.Parse(timestampLayout, s)
 }
 
-func ParseTimePrecision(s string) (precision time.Duration, err error) {
-	acceptedValues := map[string]time.Duration{
-		"1m":  time.Minute,
-		"15m": time.Duration(15) * time.Minute,
-		"1h":  time.Hour,
-		"6h":  time.Duration(6) * time.Hour,
-		"12h": time.Duration(12) * time.Hour,
-		"24h": time.Duration(24) * time.Hour,
+// CalendarUnit is a calendar period, which varies in duration.
+type CalendarUnit int
+
+const (
+	NoCalendarUnit CalendarUnit = iota
+	CalendarWeek
+	CalendarMonth
+	CalendarQuarter
+	CalendarYear
+)
+
+var calendarUnitNames = map[CalendarUnit]string{
+	CalendarWeek:    "1w",
+	CalendarMonth:   "1mo",
+	CalendarQuarter: "1q",
+	CalendarYear:    "1y",
+}
+
+// TimePrecision is the width of a time bucket, either a fixed duration or a
+// calendar period.
+type TimePrecision struct {
+	Duration time.Duration
+	Unit     CalendarUnit
+}
+
+func FixedTimePrecision(d time.Duration) TimePrecision {
+	return TimePrecision{Duration: d}
+}
+
+func CalendarTimePrecision(unit CalendarUnit) TimePrecision {
+	return TimePrecision{Unit: unit}
+}
+
+func (p TimePrecision) IsCalendar() bool {
+	return p.Unit != NoCalendarUnit
+}
+
+func (p TimePrecision) String() string {
+	if p.IsCalendar() {
+		return calendarUnitNames[p.Unit]
+	}
+	return p.Duration.String()
+}
+
+func ParseTimePrecision(s string) (precision TimePrecision, err error) {
+	acceptedValues := map[string]TimePrecision{
+		"1m":  FixedTimePrecision(time.Minute),
+		"15m": FixedTimePrecision(time.Duration(15) * time.Minute),
+		"1h":  FixedTimePrecision(time.Hour),
+		"6h":  FixedTimePrecision(time.Duration(6) * time.Hour),
+		"12h": FixedTimePrecision(time.Duration(12) * time.Hour),
+		"24h": FixedTimePrecision(time.Duration(24) * time.Hour),
+		"1w":  CalendarTimePrecision(CalendarWeek),
+		"1mo": CalendarTimePrecision(CalendarMonth),
+		"1q":  CalendarTimePrecision(CalendarQuarter),
+		"1y":  CalendarTimePrecision(CalendarYear),
 	}
 
 	precision, ok := acceptedValues[s]
@@ -52,6 +152,16 @@ func ParseTimePrecision(s string) (precision time.Duration, err error) {
 	return
 }
 
+// ParseTimezone parses an IANA timezone name, e.g. America/Los_Angeles. The
+// server's local timezone is not accepted, as it is unknown to the database.
+func ParseTimezone(s string) (*time.Location, error) {
+	loc, err := time.LoadLocation(s)
+	if err != nil || loc == time.Local {
+		return nil, ErrInvalidTimezone
+	}
+	return loc, nil
+}
+
 func ParseGeoPrecision(s string) (int, error) {
 	precision, err := strconv.Atoi(s)
 	if err != nil {
@@ -65,11 +175,107 @@ func ParseGeoPrecision(s string) (int, error) {
 	return precision, nil
 }
 
+// ParseIncidentTypes parses a comma-separated list of incident types. The
+// returned types are sorted and deduplicated, so that equivalent lists compare
+// equal.
+func ParseIncidentTypes(s string) ([]string, error) {
+	incidentTypes := strings.Split(s, ",")
+	for _, incidentType := range incidentTypes {
+		if !slices.Contains(IncidentTypes, incidentType) {
+			return nil, ErrInvalidIncidentType
+		}
+	}
+
+	slices.Sort(incidentTypes)
+	return slices.Compact(incidentTypes), nil
+}
+
+// ParseBoundingBox parses a bounding box given as
+// `minLon,minLat,maxLon,maxLat`.
+func ParseBoundingBox(s string) (*BoundingBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, ErrInvalidBoundingBox
+	}
+
+	values := make([]float64, len(parts))
+	for idx, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[idx] = value
+	}
+
+	bbox := &BoundingBox{MinLon: values[0], MinLat: values[1], MaxLon: values[2], MaxLat: values[3]}
+	if !bbox.Valid() {
+		return nil, ErrInvalidBoundingBox
+	}
+
+	return bbox, nil
+}
+
+func ParseGeohashPrefix(s string) (string, error) {
+	if len(s) > MaxGeoPrecision || geohash.Validate(s) != nil {
+		return "", ErrInvalidGeohashPrefix
+	}
+	return s, nil
+}
+
+func ParseFormat(s string) (string, error) {
+	if !slices.Contains([]string{FormatJSON, FormatGeoJSON, FormatCSV, FormatNDJSON, FormatProtobuf, FormatArrow}, s) {
+		return "", ErrInvalidFormat
+	}
+	return s, nil
+}
+
+// NegotiateFormat determines the response format from the `format` query
+// parameter, if given, or otherwise from the media types in the Accept header.
+// Defaults to JSON.
+func NegotiateFormat(params url.Values, accept string) (string, error) {
+	if params.Has("format") {
+		return ParseFormat(params.Get("format"))
+	}
+
+	mediaTypes := map[string]string{
+		"application/json":                    FormatJSON,
+		"application/geo+json":                FormatGeoJSON,
+		"text/csv":                            FormatCSV,
+		"application/x-ndjson":                FormatNDJSON,
+		"application/protobuf":                FormatProtobuf,
+		"application/x-protobuf":              FormatProtobuf,
+		"application/vnd.apache.arrow.stream": FormatArrow,
+	}
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(mediaRange, ";")
+		format, ok := mediaTypes[strings.TrimSpace(mediaType)]
+		if ok {
+			return format, nil
+		}
+	}
+
+	return FormatJSON, nil
+}
+
+// IsStreamingFormat returns whether responses in the given format are streamed
+// rather than buffered.
+func IsStreamingFormat(format string) bool {
+	return format == FormatCSV || format == FormatNDJSON
+}
+
 type AggregatesReqParams struct {
 	StartTime     time.Time
 	EndTime       time.Time
-	TimePrecision time.Duration
-	GeoPrecision  int
+	TimePrecision TimePrecision
+	// Location whose wall clock time buckets are aligned to.
+	Location     *time.Location
+	GeoPrecision int
+	// Incident types to filter to. All incident types are included if empty.
+	IncidentTypes []string
+	// Bounding box to filter to. Not filtered on if nil.
+	BoundingBox *BoundingBox
+	// Geohash prefix to filter to. Not filtered on if empty.
+	GeohashPrefix string
 }
 
 func SetDefaultEndTime(t time.Time, now func() time.Time) time.Time {
@@ -97,14 +303,35 @@ func GetAggregatesReqParams(params url.Values) (p AggregatesReqParams, err error
 		return
 	}
 
+	p.Location, err = GetParam(params, "tz", DefaultLocation, ParseTimezone)
+	if err != nil {
+		return
+	}
+
 	p.GeoPrecision, err = GetParam(params, "geo_precision", DefaultGeoPrecision, ParseGeoPrecision)
+	if err != nil {
+		return
+	}
+
+	p.IncidentTypes, err = GetParam(params, "incident_types", nil, ParseIncidentTypes)
+	if err != nil {
+		return
+	}
+
+	p.BoundingBox, err = GetParam(params, "bbox", nil, ParseBoundingBox)
+	if err != nil {
+		return
+	}
+
+	p.GeohashPrefix, err = GetParam(params, "geohash_prefix", "", ParseGeohashPrefix)
 	return
 }
 
 type Aggregate struct {
-	OccurredAt time.Time `json:"occurred_at"`
-	Geohash    string    `json:"geohash"`
-	Count      int32     `json:"count"`
+	OccurredAt   time.Time `json:"occurred_at"`
+	Geohash      string    `json:"geohash"`
+	IncidentType string    `json:"incident_type"`
+	Count        int32     `json:"count"`
 }
 
 func EncodeAggregates(records []Aggregate, w io.Writer) error {
@@ -118,10 +345,30 @@ func DecodeAggregates(s string) ([]Aggregate, error) {
 	return records, err
 }
 
+// ValidateAggregate checks that an aggregate can be written, i.e. that it is of
+// a known incident type and its geohash is valid.
+func ValidateAggregate(record Aggregate) error {
+	if !slices.Contains(IncidentTypes, record.IncidentType) {
+		return ErrInvalidIncidentType
+	}
+	if len(record.Geohash) < MinAggregateGeohashLength || len(record.Geohash) > MaxAggregateGeohashLength || geohash.Validate(record.Geohash) != nil {
+		return ErrInvalidGeohash
+	}
+	return nil
+}
+
 func DecodeAggregatesFromReader(r io.Reader) ([]Aggregate, error) {
 	var records []Aggregate
 	decoder := json.NewDecoder(r)
 	decoder.DisallowUnknownFields()
-	err := decoder.Decode(&records)
-	return records, err
+	if err := decoder.Decode(&records); err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		if err := ValidateAggregate(record); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -44,7 +45,14 @@ func TestParseTimestampWhenInvalidFormat(t *testing.T) {
 func TestParseTimePrecision(t *testing.T) {
 	actual, err := ParseTimePrecision("1m")
 	assert.Nil(t, err)
-	assert.Equal(t, time.Minute, actual)
+	assert.Equal(t, FixedTimePrecision(time.Minute), actual)
+}
+
+func TestParseTimePrecisionWhenCalendarUnit(t *testing.T) {
+	actual, err := ParseTimePrecision("1mo")
+	assert.Nil(t, err)
+	assert.Equal(t, CalendarTimePrecision(CalendarMonth), actual)
+	assert.Equal(t, "1mo", actual.String())
 }
 
 func TestParseTimePrecisionWhenUnacceptedValue(t *testing.T) {
@@ -52,6 +60,19 @@ func TestParseTimePrecisionWhenUnacceptedValue(t *testing.T) {
 	assert.ErrorIs(t, ErrInvalidTimePrecision, err)
 }
 
+func TestParseTimezone(t *testing.T) {
+	actual, err := ParseTimezone("America/New_York")
+	assert.Nil(t, err)
+	assert.Equal(t, "America/New_York", actual.String())
+}
+
+func TestParseTimezoneWhenUnacceptedValue(t *testing.T) {
+	for _, value := range []string{"America/Nowhere", "Local"} {
+		_, err := ParseTimezone(value)
+		assert.ErrorIs(t, ErrInvalidTimezone, err)
+	}
+}
+
 func TestParseGeoPrecision(t *testing.T) {
 	expected := 1
 	actual, err := ParseGeoPrecision("1")
@@ -71,6 +92,89 @@ func TestParseGeoPrecisionWhenUnacceptedValue(t *testing.T) {
 	}
 }
 
+func TestParseIncidentTypes(t *testing.T) {
+	expected := []string{IncidentTypePoliceIncident, IncidentTypeTrafficCrash}
+	actual, err := ParseIncidentTypes("traffic_crash,police_incident,traffic_crash")
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestParseIncidentTypesWhenUnacceptedValue(t *testing.T) {
+	for _, s := range []string{"unknown", "police_incident,", "police_incident,unknown"} {
+		_, err := ParseIncidentTypes(s)
+		assert.ErrorIs(t, ErrInvalidIncidentType, err)
+	}
+}
+
+func TestParseBoundingBox(t *testing.T) {
+	expected := &BoundingBox{MinLon: -122.5, MinLat: 37.7, MaxLon: -122.4, MaxLat: 37.8}
+	actual, err := ParseBoundingBox("-122.5,37.7,-122.4,37.8")
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestParseBoundingBoxWhenUnparsableValue(t *testing.T) {
+	_, err := ParseBoundingBox("-122.5,37.7,-122.4,north")
+	assert.NotNil(t, err)
+}
+
+func TestParseBoundingBoxWhenUnacceptedValue(t *testing.T) {
+	for _, s := range []string{"-122.5,37.7,-122.4", "-122.4,37.7,-122.5,37.8", "-122.5,37.7,-122.4,91"} {
+		_, err := ParseBoundingBox(s)
+		assert.ErrorIs(t, ErrInvalidBoundingBox, err)
+	}
+}
+
+func TestParseGeohashPrefix(t *testing.T) {
+	actual, err := ParseGeohashPrefix("9q8yy")
+	assert.Nil(t, err)
+	assert.Equal(t, "9q8yy", actual)
+}
+
+func TestParseGeohashPrefixWhenUnacceptedValue(t *testing.T) {
+	for _, s := range []string{"9q8yyqb9", "9q8ya"} {
+		_, err := ParseGeohashPrefix(s)
+		assert.ErrorIs(t, ErrInvalidGeohashPrefix, err)
+	}
+}
+
+func TestParseFormatWhenUnacceptedValue(t *testing.T) {
+	_, err := ParseFormat("xml")
+	assert.ErrorIs(t, ErrInvalidFormat, err)
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	testCases := []struct {
+		Query    string
+		Accept   string
+		Expected string
+	}{
+		{Query: "", Accept: "", Expected: FormatJSON},
+		{Query: "", Accept: "*/*", Expected: FormatJSON},
+		{Query: "", Accept: "application/json", Expected: FormatJSON},
+		{Query: "", Accept: "application/geo+json", Expected: FormatGeoJSON},
+		{Query: "", Accept: "text/html, application/geo+json;q=0.9", Expected: FormatGeoJSON},
+		{Query: "format=geojson", Accept: "", Expected: FormatGeoJSON},
+		{Query: "format=json", Accept: "application/geo+json", Expected: FormatJSON},
+		{Query: "", Accept: "application/x-protobuf", Expected: FormatProtobuf},
+		{Query: "format=protobuf", Accept: "", Expected: FormatProtobuf},
+		{Query: "", Accept: "application/vnd.apache.arrow.stream", Expected: FormatArrow},
+		{Query: "format=arrow", Accept: "", Expected: FormatArrow},
+	}
+	for idx, testCase := range testCases {
+		params, _ := url.ParseQuery(testCase.Query)
+		actual, err := NegotiateFormat(params, testCase.Accept)
+		assert.Nil(t, err, idx)
+		assert.Equal(t, testCase.Expected, actual, idx)
+	}
+}
+
+func TestNegotiateFormatWhenUnacceptedValue(t *testing.T) {
+	params, _ := url.ParseQuery("format=xml")
+	_, err := NegotiateFormat(params, "application/geo+json")
+	assert.ErrorIs(t, ErrInvalidFormat, err)
+}
+
 func TestSetDefaultEndTime(t *testing.T) {
 	endTime := time.Date(2025, 1, 1, 13, 5, 0, 0, time.UTC)
 	actual := SetDefaultEndTime(endTime, time.Now)
@@ -91,15 +195,23 @@ func TestGetAggregatesReqParams(t *testing.T) {
 	params.Set("start_time", "2025-01-01T13:00Z")
 	params.Set("end_time", "2025-01-01T13:00Z")
 	params.Set("time_precision", "15m")
+	params.Set("tz", "UTC")
 	params.Set("geo_precision", "5")
+	params.Set("incident_types", "traffic_crash,police_incident")
+	params.Set("bbox", "-122.5,37.7,-122.4,37.8")
+	params.Set("geohash_prefix", "9q8yy")
 
 	actual, err := GetAggregatesReqParams(params)
 
 	assert.Nil(t, err)
 	assert.Equal(t, time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), actual.StartTime)
 	assert.Equal(t, time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), actual.EndTime)
-	assert.Equal(t, time.Duration(15)*time.Minute, actual.TimePrecision)
+	assert.Equal(t, FixedTimePrecision(time.Duration(15)*time.Minute), actual.TimePrecision)
+	assert.Equal(t, time.UTC, actual.Location)
 	assert.Equal(t, 5, actual.GeoPrecision)
+	assert.Equal(t, []string{IncidentTypePoliceIncident, IncidentTypeTrafficCrash}, actual.IncidentTypes)
+	assert.Equal(t, &BoundingBox{MinLon: -122.5, MinLat: 37.7, MaxLon: -122.4, MaxLat: 37.8}, actual.BoundingBox)
+	assert.Equal(t, "9q8yy", actual.GeohashPrefix)
 }
 
 func TestGetAggregatesReqParamsWhenEmpty(t *testing.T) {
@@ -110,5 +222,34 @@ func TestGetAggregatesReqParamsWhenEmpty(t *testing.T) {
 	assert.True(t, actual.StartTime.IsZero())
 	assert.False(t, actual.EndTime.IsZero())
 	assert.Equal(t, DefaultTimePrecision, actual.TimePrecision)
+	assert.Equal(t, DefaultLocation, actual.Location)
 	assert.Equal(t, DefaultGeoPrecision, actual.GeoPrecision)
+	assert.Empty(t, actual.IncidentTypes)
+	assert.Nil(t, actual.BoundingBox)
+	assert.Equal(t, "", actual.GeohashPrefix)
+}
+
+func TestDecodeAggregatesFromReader(t *testing.T) {
+	type testCase struct {
+		Body          string
+		ExpectedError error
+	}
+
+	testCases := []testCase{
+		{Body: `[{"occurred_at": "2025-01-14T00:00:00Z", "geohash": "9q8yyqb", "incident_type": "police_incident", "count": 2}]`},
+		{Body: `[{"occurred_at": "2025-01-14T00:00:00Z", "geohash": "9q8yyqb", "incident_type": "", "count": 2}]`, ExpectedError: ErrInvalidIncidentType},
+		{Body: `[{"occurred_at": "2025-01-14T00:00:00Z", "geohash": "9q8yyqb", "incident_type": "unknown", "count": 2}]`, ExpectedError: ErrInvalidIncidentType},
+		// Too coarse to be queried at every precision.
+		{Body: `[{"occurred_at": "2025-01-14T00:00:00Z", "geohash": "9q8yy", "incident_type": "police_incident", "count": 2}]`, ExpectedError: ErrInvalidGeohash},
+		{Body: `[{"occurred_at": "2025-01-14T00:00:00Z", "geohash": "abcdefg", "incident_type": "police_incident", "count": 2}]`, ExpectedError: ErrInvalidGeohash},
+		{Body: `[{"occurred_at": "2025-01-14T00:00:00Z", "geohash": "9q8yyqb9q8yyqb", "incident_type": "police_incident", "count": 2}]`, ExpectedError: ErrInvalidGeohash},
+	}
+	for _, testCase := range testCases {
+		_, err := DecodeAggregatesFromReader(strings.NewReader(testCase.Body))
+		if testCase.ExpectedError != nil {
+			assert.ErrorIs(t, err, testCase.ExpectedError)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
 }
@@ -4,60 +4,345 @@ import (
 	"context"
 	"errors"
 	"log/slog"
+	"slices"
 	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
 type Repoer interface {
-	GetAggregateRows(context.Context, time.Time, time.Time) ([]AggregateRow, error)
+	GetAggregateRows(context.Context, RowsFilter) ([]AggregateRow, error)
+	StreamAggregateRows(context.Context, RowsFilter, func(AggregateRow) error) error
 	InsertAggregateRows(context.Context, []AggregateRow) error
 	UpsertAggregateRows(context.Context, []AggregateRow) error
 }
 
+// RollupRepoer is implemented by repositories which can roll up aggregates
+// themselves, so that only rolled up rows are fetched. Aggregates are otherwise
+// rolled up by the service.
+type RollupRepoer interface {
+	RollupAggregateRows(context.Context, RowsFilter, RowsRollup) ([]AggregateRow, error)
+	StreamRollupAggregateRows(context.Context, RowsFilter, RowsRollup, func(AggregateRow) error) error
+}
+
 type Cacher interface {
-	Get(context.Context, AggregatesReqParams) ([]Aggregate, error)
-	Set(context.Context, AggregatesReqParams, []Aggregate) error
+	// Get returns ErrNoSuchKey if there is no cached entry, or the stale
+	// aggregates along with ErrStaleKey if the entry has expired.
+	Get(context.Context, AggregatesReqParams) (AggregatesResult, error)
+	// Versions returns the version of the aggregates for each of the params,
+	// which is read before the aggregates and passed to Set or SetMany.
+	Versions(context.Context, []AggregatesReqParams) ([]CacheVersion, error)
+	// Set returns ErrVersionChanged, without caching the aggregates, if
+	// aggregates were written since the version was read.
+	Set(context.Context, AggregatesReqParams, AggregatesResult, CacheVersion) error
+	// GetMany returns nil aggregates for params without a cached entry, and
+	// reports which of the entries have expired.
+	GetMany(context.Context, []AggregatesReqParams) ([][]Aggregate, []bool, error)
+	SetMany(context.Context, []AggregatesReqParams, [][]Aggregate, []CacheVersion) error
+	// Invalidate removes cached aggregates whose time range overlaps any of
+	// the given times.
+	Invalidate(context.Context, []time.Time) error
 }
 
 type AggregatesService struct {
 	repo  Repoer
 	cache Cacher
+	// Coalesces concurrent fetches of the same aggregates, so that a single
+	// query serves every request waiting on them.
+	group singleflight.Group
 }
 
 func NewAggregatesService(repo Repoer, cache Cacher) *AggregatesService {
 	return &AggregatesService{repo: repo, cache: cache}
 }
 
-func (s *AggregatesService) GetAggregates(ctx context.Context, params AggregatesReqParams) ([]Aggregate, error) {
-	cachedRecords, err := s.cache.Get(ctx, params)
+func MakeRowsFilter(params AggregatesReqParams) RowsFilter {
+	return RowsFilter{
+		StartTime:     params.StartTime,
+		EndTime:       params.EndTime,
+		IncidentTypes: params.IncidentTypes,
+		Cells:         GeohashCells(params.BoundingBox, params.GeohashPrefix),
+	}
+}
+
+func MakeRowsRollup(params AggregatesReqParams) RowsRollup {
+	return RowsRollup{
+		TimePrecision: params.TimePrecision,
+		GeoPrecision:  params.GeoPrecision,
+		Location:      params.Location,
+	}
+}
+
+// getRolledUpAggregates fetches aggregates, rolling them up in the repository
+// if supported.
+func (s *AggregatesService) getRolledUpAggregates(ctx context.Context, params AggregatesReqParams) ([]Aggregate, error) {
+	if repo, ok := s.repo.(RollupRepoer); ok {
+		rows, err := repo.RollupAggregateRows(ctx, MakeRowsFilter(params), MakeRowsRollup(params))
+		if err != nil {
+			return nil, err
+		}
+		return MapToAggregates(rows), nil
+	}
+
+	rows, err := s.repo.GetAggregateRows(ctx, MakeRowsFilter(params))
+	if err != nil {
+		return nil, err
+	}
+	return Rollup(rows, params.TimePrecision, params.GeoPrecision, params.Location), nil
+}
+
+// getCacheVersions reads the versions of the aggregates for the params, before
+// they are fetched. If the versions can't be read, zero versions are returned,
+// which are only current if no aggregates have been written, so outdated
+// aggregates are still not cached.
+func (s *AggregatesService) getCacheVersions(ctx context.Context, params []AggregatesReqParams) []CacheVersion {
+	versions, err := s.cache.Versions(ctx, params)
+	if err != nil {
+		slog.Error("Error reading from cache", "error", err)
+		return make([]CacheVersion, len(params))
+	}
+	return versions
+}
+
+// coalesce calls `fn`, unless a call with the same key is already in flight,
+// in which case it waits for and shares that call's result. As the call is
+// shared, it is not cancelled along with `ctx`, but the caller stops waiting.
+func coalesce[T any](ctx context.Context, group *singleflight.Group, key string, fn func(context.Context) (T, error)) (T, error) {
+	sharedCtx := context.WithoutCancel(ctx)
+	ch := group.DoChan(key, func() (any, error) {
+		return fn(sharedCtx)
+	})
+
+	select {
+	case result := <-ch:
+		return result.Val.(T), result.Err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// GetAggregates fetches rolled up aggregates. The request is split into
+// chunks, such that complete chunks are cached and shared with overlapping
+// requests, unless the request can't be split, in which case it is cached
+// whole. Stale cached aggregates are served while they are refreshed in the
+// background.
+func (s *AggregatesService) GetAggregates(ctx context.Context, params AggregatesReqParams) (AggregatesResult, error) {
+	if plan, ok := PlanChunks(params, time.Now()); ok {
+		return s.getChunkedAggregates(ctx, params, plan)
+	}
+
+	cachedResult, err := s.cache.Get(ctx, params)
 
 	if err == nil {
-		return cachedRecords, nil
+		cacheLookups.WithLabelValues(cacheLookupWhole, cacheResultHit).Inc()
+		return cachedResult, nil
 	}
 
+	if errors.Is(err, ErrStaleKey) {
+		cacheLookups.WithLabelValues(cacheLookupWhole, cacheResultStale).Inc()
+		s.refreshAggregates(ctx, params)
+		return cachedResult, nil
+	}
+
+	cacheLookups.WithLabelValues(cacheLookupWhole, cacheResultMiss).Inc()
+
 	if !errors.Is(err, ErrNoSuchKey) {
 		slog.Error("Error reading from cache", "error", err, "params", params)
 	}
 
-	rows, err := s.repo.GetAggregateRows(ctx, params.StartTime, params.EndTime)
+	result, err := s.fetchAggregates(ctx, params)
+	if err != nil {
+		return AggregatesResult{Aggregates: []Aggregate{}}, err
+	}
+	return result, nil
+}
+
+// fetchAggregates fetches rolled up aggregates from the repository and caches
+// them, coalescing concurrent fetches of the same aggregates.
+func (s *AggregatesService) fetchAggregates(ctx context.Context, params AggregatesReqParams) (AggregatesResult, error) {
+	return coalesce(ctx, &s.group, "aggregates:"+ParamsKey(params), func(ctx context.Context) (AggregatesResult, error) {
+		versions := s.getCacheVersions(ctx, []AggregatesReqParams{params})
+
+		records, err := s.getRolledUpAggregates(ctx, params)
+		if err != nil {
+			return AggregatesResult{}, err
+		}
+
+		result := NewAggregatesResult(records, versions[0].ModifiedAt)
+		if err := ignoreVersionChanged(s.cache.Set(ctx, params, result, versions[0])); err != nil {
+			slog.Error("Error updating cache", "error", err, "params", params)
+		}
+		return result, nil
+	})
+}
+
+// refreshAggregates refetches and caches aggregates in the background. The
+// refresh outlives the request which triggered it.
+func (s *AggregatesService) refreshAggregates(ctx context.Context, params AggregatesReqParams) {
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		if _, err := s.fetchAggregates(ctx, params); err != nil {
+			slog.Error("Error refreshing cache", "error", err, "params", params)
+		}
+	}()
+}
+
+// getChunkedAggregates fetches the complete chunks from the cache, and any
+// missing chunks and the partial chunks from the repository. Stale chunks are
+// served while they are refreshed in the background. The result is modified as
+// of the latest write within the request's time range.
+func (s *AggregatesService) getChunkedAggregates(ctx context.Context, params AggregatesReqParams, plan ChunkPlan) (AggregatesResult, error) {
+	versions := s.getCacheVersions(ctx, []AggregatesReqParams{params})
+
+	chunks, stale, err := s.cache.GetMany(ctx, plan.Chunks)
+	if err != nil {
+		slog.Error("Error reading from cache", "error", err)
+		chunks, stale = make([][]Aggregate, len(plan.Chunks)), make([]bool, len(plan.Chunks))
+	}
+
+	missing, staleIdxs := []int{}, []int{}
+	for idx, chunk := range chunks {
+		if chunk == nil {
+			missing = append(missing, idx)
+		} else if stale[idx] {
+			staleIdxs = append(staleIdxs, idx)
+		}
+	}
+	hits := len(chunks) - len(missing) - len(staleIdxs)
+	cacheLookups.WithLabelValues(cacheLookupChunk, cacheResultHit).Add(float64(hits))
+	cacheLookups.WithLabelValues(cacheLookupChunk, cacheResultStale).Add(float64(len(staleIdxs)))
+	cacheLookups.WithLabelValues(cacheLookupChunk, cacheResultMiss).Add(float64(len(missing)))
+
+	if len(missing) > 0 {
+		// Fetch all missing chunks together, along with any cached chunks in
+		// between them, which refreshes those that are stale.
+		first, last := missing[0], missing[len(missing)-1]
+		spanChunks, err := s.fetchChunks(ctx, plan.Precision, plan.Chunks[first:last+1])
+		if err != nil {
+			return AggregatesResult{Aggregates: []Aggregate{}}, err
+		}
+
+		for _, chunkIdx := range missing {
+			chunks[chunkIdx] = spanChunks[chunkIdx-first]
+		}
+
+		staleIdxs = slices.DeleteFunc(staleIdxs, func(chunkIdx int) bool {
+			return chunkIdx >= first && chunkIdx <= last
+		})
+	}
+
+	if len(staleIdxs) > 0 {
+		first, last := staleIdxs[0], staleIdxs[len(staleIdxs)-1]
+		s.refreshChunks(ctx, plan.Precision, plan.Chunks[first:last+1])
+	}
+
+	head, err := s.getPartialChunk(ctx, plan.Head)
 	if err != nil {
-		return []Aggregate{}, err
+		return AggregatesResult{Aggregates: []Aggregate{}}, err
 	}
+	chunks = append([][]Aggregate{head}, chunks...)
+
+	if plan.Tail != nil {
+		tail, err := s.getPartialChunk(ctx, *plan.Tail)
+		if err != nil {
+			return AggregatesResult{Aggregates: []Aggregate{}}, err
+		}
+		chunks = append(chunks, tail)
+	}
+
+	return NewAggregatesResult(StitchChunks(chunks...), versions[0].ModifiedAt), nil
+}
+
+// fetchChunks fetches consecutive complete chunks from the repository in a
+// single query, and caches them, coalescing concurrent fetches of the same
+// chunks.
+func (s *AggregatesService) fetchChunks(ctx context.Context, precision TimePrecision, chunkParams []AggregatesReqParams) ([][]Aggregate, error) {
+	first, last := chunkParams[0], chunkParams[len(chunkParams)-1]
+	span := withTimeRange(first, first.StartTime, last.EndTime)
+
+	return coalesce(ctx, &s.group, "chunks:"+ParamsKey(span), func(ctx context.Context) ([][]Aggregate, error) {
+		versions := s.getCacheVersions(ctx, chunkParams)
+
+		records, err := s.getRolledUpAggregates(ctx, span)
+		if err != nil {
+			return nil, err
+		}
+
+		chunkEnds := make([]time.Time, len(chunkParams))
+		for idx, p := range chunkParams {
+			chunkEnds[idx] = p.EndTime
+		}
+
+		chunks := SplitByChunk(records, precision, span.Location, chunkEnds)
+		if err := ignoreVersionChanged(s.cache.SetMany(ctx, chunkParams, chunks, versions)); err != nil {
+			slog.Error("Error updating cache", "error", err)
+		}
+		return chunks, nil
+	})
+}
 
-	records := Rollup(rows, params.TimePrecision, params.GeoPrecision)
+// refreshChunks refetches and caches consecutive complete chunks in the
+// background. The refresh outlives the request which triggered it.
+func (s *AggregatesService) refreshChunks(ctx context.Context, precision TimePrecision, chunkParams []AggregatesReqParams) {
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		if _, err := s.fetchChunks(ctx, precision, chunkParams); err != nil {
+			slog.Error("Error refreshing cache", "error", err)
+		}
+	}()
+}
+
+// getPartialChunk fetches a partial chunk, which isn't cached, from the
+// repository, coalescing concurrent fetches of the same chunk.
+func (s *AggregatesService) getPartialChunk(ctx context.Context, params AggregatesReqParams) ([]Aggregate, error) {
+	return coalesce(ctx, &s.group, "partial:"+ParamsKey(params), func(ctx context.Context) ([]Aggregate, error) {
+		return s.getRolledUpAggregates(ctx, params)
+	})
+}
 
-	if err := s.cache.Set(ctx, params, records); err != nil {
-		slog.Error("Error updating cache", "error", err, "params", params)
+// StreamAggregates calls `fn` with each aggregate as it is read from the
+// database, or, if the repository cannot roll up aggregates, once its time
+// bucket is complete. The cache is bypassed, so that results are never held in
+// memory in full.
+func (s *AggregatesService) StreamAggregates(ctx context.Context, params AggregatesReqParams, fn func(Aggregate) error) error {
+	if repo, ok := s.repo.(RollupRepoer); ok {
+		return repo.StreamRollupAggregateRows(ctx, MakeRowsFilter(params), MakeRowsRollup(params), func(row AggregateRow) error {
+			return fn(MapToAggregate(row))
+		})
 	}
 
-	return records, nil
+	rollup := NewStreamingRollup(params.TimePrecision, params.GeoPrecision, params.Location, fn)
+	if err := s.repo.StreamAggregateRows(ctx, MakeRowsFilter(params), rollup.Add); err != nil {
+		return err
+	}
+	return rollup.Flush()
 }
 
 func MapToRow(record Aggregate) AggregateRow {
 	return AggregateRow{
-		OccurredAt: record.OccurredAt,
-		Geohash:    record.Geohash,
-		Count:      record.Count,
+		OccurredAt:   record.OccurredAt,
+		Geohash:      record.Geohash,
+		IncidentType: record.IncidentType,
+		Count:        record.Count,
+	}
+}
+
+func MapToAggregate(row AggregateRow) Aggregate {
+	return Aggregate{
+		OccurredAt:   row.OccurredAt,
+		Geohash:      row.Geohash,
+		IncidentType: row.IncidentType,
+		Count:        row.Count,
+	}
+}
+
+func MapToAggregates(rows []AggregateRow) []Aggregate {
+	records := make([]Aggregate, len(rows))
+	for idx, row := range rows {
+		records[idx] = MapToAggregate(row)
 	}
+	return records
 }
 
 func MapToRows(records []Aggregate) []AggregateRow {
@@ -68,12 +353,37 @@ func MapToRows(records []Aggregate) []AggregateRow {
 	return rows
 }
 
+// invalidateCache removes cached aggregates which the written records fall
+// within, so that writes are visible immediately. Invalidation is not
+// cancelled along with the request, as the records have been written.
+func (s *AggregatesService) invalidateCache(ctx context.Context, records []Aggregate) {
+	ctx = context.WithoutCancel(ctx)
+	times := make([]time.Time, len(records))
+	for idx, record := range records {
+		times[idx] = record.OccurredAt
+	}
+
+	if err := s.cache.Invalidate(ctx, times); err != nil {
+		slog.Error("Error invalidating cache", "error", err)
+	}
+}
+
 func (s *AggregatesService) InsertAggregates(ctx context.Context, records []Aggregate) error {
 	rows := MapToRows(records)
-	return s.repo.InsertAggregateRows(ctx, rows)
+	if err := s.repo.InsertAggregateRows(ctx, rows); err != nil {
+		return err
+	}
+
+	s.invalidateCache(ctx, records)
+	return nil
 }
 
 func (s *AggregatesService) UpsertAggregates(ctx context.Context, records []Aggregate) error {
 	rows := MapToRows(records)
-	return s.repo.UpsertAggregateRows(ctx, rows)
+	if err := s.repo.UpsertAggregateRows(ctx, rows); err != nil {
+		return err
+	}
+
+	s.invalidateCache(ctx, records)
+	return nil
 }
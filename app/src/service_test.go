@@ -10,74 +10,540 @@ import (
 	"github.com/stretchr/testify/mock"
 )
 
+// matchesAggregates matches results with the given aggregates, regardless of
+// when they were read.
+func matchesAggregates(records []Aggregate) any {
+	return mock.MatchedBy(func(result AggregatesResult) bool {
+		return assert.ObjectsAreEqual(NewAggregatesResult(records, result.ModifiedAt), result)
+	})
+}
+
 func TestAggregatesServiceGetAggregatesWhenCacheHit(t *testing.T) {
 	records := []Aggregate{
-		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 1},
-		{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
 	}
 
 	repo := new(mockRepo)
 
 	cache := new(mockCache)
-	cache.On("Get", mock.Anything, mock.Anything).Return(records, nil)
+	result := NewAggregatesResult(records, time.Now())
+	cache.On("Get", mock.Anything, mock.Anything).Return(result, nil)
 
 	service := NewAggregatesService(repo, cache)
 	ctx := context.Background()
-	params := AggregatesReqParams{TimePrecision: DefaultTimePrecision, GeoPrecision: DefaultGeoPrecision}
+	params := AggregatesReqParams{TimePrecision: DefaultTimePrecision, Location: time.UTC, GeoPrecision: DefaultGeoPrecision}
 	actual, err := service.GetAggregates(ctx, params)
 
 	assert.Nil(t, err)
-	assert.Equal(t, records, actual)
+	assert.Equal(t, result, actual)
 	cache.AssertCalled(t, "Get", ctx, params)
 }
 
 func TestAggregatesServiceGetAggregatesWhenCacheMissOrUnavailable(t *testing.T) {
 	rows := []AggregateRow{
-		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 1},
-		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
 	}
 	records := []Aggregate{
-		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 2},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 2},
 	}
 
 	repo := new(mockRepo)
-	repo.On("GetAggregateRows", mock.Anything, mock.Anything, mock.Anything).Return(rows, nil)
+	repo.On("GetAggregateRows", mock.Anything, mock.Anything).Return(rows, nil)
 
 	cache := new(mockCache)
-	cache.On("Get", mock.Anything, mock.Anything).Return([]Aggregate{}, ErrNoSuchKey)
-	cache.On("Set", mock.Anything, mock.Anything, records).Return(nil)
+	cache.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+	cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil)
+	cache.On("Set", mock.Anything, mock.Anything, matchesAggregates(records), mock.Anything).Return(nil)
 
 	service := NewAggregatesService(repo, cache)
 
 	ctx := context.Background()
-	params := AggregatesReqParams{TimePrecision: DefaultTimePrecision, GeoPrecision: DefaultGeoPrecision}
+	params := AggregatesReqParams{TimePrecision: DefaultTimePrecision, Location: time.UTC, GeoPrecision: DefaultGeoPrecision}
 	actual, err := service.GetAggregates(ctx, params)
 
 	assert.Nil(t, err)
-	assert.Equal(t, records, actual)
+	assert.Equal(t, records, actual.Aggregates)
 	cache.AssertCalled(t, "Get", ctx, params)
-	repo.AssertCalled(t, "GetAggregateRows", ctx, params.StartTime, params.EndTime)
-	cache.AssertCalled(t, "Set", ctx, params, records)
+	repo.AssertCalled(t, "GetAggregateRows", context.WithoutCancel(ctx), MakeRowsFilter(params))
+	cache.AssertCalled(t, "Set", context.WithoutCancel(ctx), params, matchesAggregates(records), CacheVersion{})
+}
+
+func TestAggregatesServiceGetAggregatesReadsVersionBeforeQuerying(t *testing.T) {
+	version := CacheVersion{Generation: 3}
+
+	var versionRead bool
+	cache := new(mockCache)
+	cache.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+	cache.On("Versions", mock.Anything, mock.Anything).Return([]CacheVersion{version}, nil).Run(func(mock.Arguments) {
+		versionRead = true
+	})
+	cache.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(ErrVersionChanged)
+
+	repo := new(mockRepo)
+	repo.On("GetAggregateRows", mock.Anything, mock.Anything).Return([]AggregateRow{}, nil).Run(func(mock.Arguments) {
+		assert.True(t, versionRead)
+	})
+
+	service := NewAggregatesService(repo, cache)
+
+	params := AggregatesReqParams{TimePrecision: DefaultTimePrecision, Location: time.UTC, GeoPrecision: DefaultGeoPrecision}
+	_, err := service.GetAggregates(context.Background(), params)
+
+	// Aggregates which were outdated while they were read aren't cached, which
+	// isn't an error.
+	assert.Nil(t, err)
+	cache.AssertCalled(t, "Set", mock.Anything, params, mock.Anything, version)
+}
+
+func TestAggregatesServiceGetAggregatesWhenModified(t *testing.T) {
+	modifiedAt := time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC)
+
+	repo := new(mockRepo)
+	repo.On("GetAggregateRows", mock.Anything, mock.Anything).Return([]AggregateRow{}, nil)
+
+	cache := new(mockCache)
+	cache.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+	cache.On("Versions", mock.Anything, mock.Anything).Return([]CacheVersion{{Generation: 1, ModifiedAt: modifiedAt}}, nil)
+	cache.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	service := NewAggregatesService(repo, cache)
+
+	params := AggregatesReqParams{TimePrecision: DefaultTimePrecision, Location: time.UTC, GeoPrecision: DefaultGeoPrecision}
+	actual, err := service.GetAggregates(context.Background(), params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, modifiedAt, actual.ModifiedAt)
 }
 
 func TestAggregatesServiceGetAggregatesWhenDatabaseUnavailable(t *testing.T) {
 	databaseErr := errors.New("Database error")
 
 	repo := new(mockRepo)
-	repo.On("GetAggregateRows", mock.Anything, mock.Anything, mock.Anything).Return([]AggregateRow{}, databaseErr)
+	repo.On("GetAggregateRows", mock.Anything, mock.Anything).Return([]AggregateRow{}, databaseErr)
 
 	cache := new(mockCache)
-	cache.On("Get", mock.Anything, mock.Anything).Return([]Aggregate{}, ErrNoSuchKey)
-	cache.On("Set", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	cache.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+	cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil)
+	cache.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 	service := NewAggregatesService(repo, cache)
 
 	ctx := context.Background()
-	params := AggregatesReqParams{TimePrecision: DefaultTimePrecision, GeoPrecision: DefaultGeoPrecision}
+	params := AggregatesReqParams{TimePrecision: DefaultTimePrecision, Location: time.UTC, GeoPrecision: DefaultGeoPrecision}
 	_, err := service.GetAggregates(ctx, params)
 
 	assert.ErrorIs(t, databaseErr, err)
 	cache.AssertCalled(t, "Get", ctx, params)
-	repo.AssertCalled(t, "GetAggregateRows", ctx, params.StartTime, params.EndTime)
+	repo.AssertCalled(t, "GetAggregateRows", context.WithoutCancel(ctx), MakeRowsFilter(params))
 	cache.AssertNotCalled(t, "Set")
 }
+
+func TestAggregatesServiceStreamAggregates(t *testing.T) {
+	rows := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 1, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+	}
+	expected := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 2},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 1, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+	}
+
+	repo := new(mockRepo)
+	repo.On("StreamAggregateRows", mock.Anything, mock.Anything, mock.Anything).Return(rows, nil)
+
+	cache := new(mockCache)
+
+	service := NewAggregatesService(repo, cache)
+
+	ctx := context.Background()
+	params := AggregatesReqParams{TimePrecision: DefaultTimePrecision, Location: time.UTC, GeoPrecision: DefaultGeoPrecision}
+	actual := []Aggregate{}
+	err := service.StreamAggregates(ctx, params, func(record Aggregate) error {
+		actual = append(actual, record)
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+	repo.AssertCalled(t, "StreamAggregateRows", ctx, MakeRowsFilter(params), mock.Anything)
+	cache.AssertNotCalled(t, "Get")
+	cache.AssertNotCalled(t, "Set")
+}
+
+func TestAggregatesServiceGetAggregatesWhenRepoRollsUp(t *testing.T) {
+	rows := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcde", IncidentType: IncidentTypeFireIncident, Count: 2},
+	}
+	records := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcde", IncidentType: IncidentTypeFireIncident, Count: 2},
+	}
+
+	repo := new(mockRollupRepo)
+	repo.On("RollupAggregateRows", mock.Anything, mock.Anything, mock.Anything).Return(rows, nil)
+
+	cache := new(mockCache)
+	cache.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+	cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil)
+	cache.On("Set", mock.Anything, mock.Anything, matchesAggregates(records), mock.Anything).Return(nil)
+
+	service := NewAggregatesService(repo, cache)
+
+	ctx := context.Background()
+	params := AggregatesReqParams{TimePrecision: FixedTimePrecision(time.Hour), Location: time.UTC, GeoPrecision: 5}
+	actual, err := service.GetAggregates(ctx, params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, records, actual.Aggregates)
+	repo.AssertCalled(t, "RollupAggregateRows", context.WithoutCancel(ctx), MakeRowsFilter(params), MakeRowsRollup(params))
+	repo.AssertNotCalled(t, "GetAggregateRows")
+	cache.AssertCalled(t, "Set", context.WithoutCancel(ctx), params, matchesAggregates(records), CacheVersion{})
+}
+
+func TestAggregatesServiceStreamAggregatesWhenRepoRollsUp(t *testing.T) {
+	rows := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcde", IncidentType: IncidentTypeFireIncident, Count: 2},
+		{OccurredAt: time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC), Geohash: "abcde", IncidentType: IncidentTypeFireIncident, Count: 1},
+	}
+	expected := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC), Geohash: "abcde", IncidentType: IncidentTypeFireIncident, Count: 2},
+		{OccurredAt: time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC), Geohash: "abcde", IncidentType: IncidentTypeFireIncident, Count: 1},
+	}
+
+	repo := new(mockRollupRepo)
+	repo.On("StreamRollupAggregateRows", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(rows, nil)
+
+	service := NewAggregatesService(repo, new(mockCache))
+
+	ctx := context.Background()
+	params := AggregatesReqParams{TimePrecision: FixedTimePrecision(time.Hour), Location: time.UTC, GeoPrecision: 5}
+	actual := []Aggregate{}
+	err := service.StreamAggregates(ctx, params, func(record Aggregate) error {
+		actual = append(actual, record)
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+	repo.AssertCalled(t, "StreamRollupAggregateRows", ctx, MakeRowsFilter(params), MakeRowsRollup(params), mock.Anything)
+	repo.AssertNotCalled(t, "StreamAggregateRows")
+}
+
+func TestAggregatesServiceInsertAggregatesInvalidatesCache(t *testing.T) {
+	records := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 2, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+	}
+
+	repo := new(mockRepo)
+	repo.On("InsertAggregateRows", mock.Anything, mock.Anything).Return(nil)
+
+	cache := new(mockCache)
+	cache.On("Invalidate", mock.Anything, mock.Anything).Return(errors.New("unavailable"))
+
+	service := NewAggregatesService(repo, cache)
+
+	ctx := context.Background()
+	err := service.InsertAggregates(ctx, records)
+
+	assert.Nil(t, err)
+	repo.AssertCalled(t, "InsertAggregateRows", ctx, MapToRows(records))
+	cache.AssertCalled(t, "Invalidate", context.WithoutCancel(ctx), []time.Time{records[0].OccurredAt, records[1].OccurredAt})
+}
+
+func TestAggregatesServiceUpsertAggregatesWhenDatabaseUnavailable(t *testing.T) {
+	records := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+	}
+
+	repo := new(mockRepo)
+	repo.On("UpsertAggregateRows", mock.Anything, mock.Anything).Return(errors.New("unavailable"))
+
+	cache := new(mockCache)
+
+	service := NewAggregatesService(repo, cache)
+	err := service.UpsertAggregates(context.Background(), records)
+
+	assert.NotNil(t, err)
+	cache.AssertNotCalled(t, "Invalidate")
+}
+
+func TestAggregatesServiceGetAggregatesWhenChunked(t *testing.T) {
+	params := AggregatesReqParams{
+		StartTime:     time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(2025, 1, 3, 12, 0, 0, 0, time.UTC),
+		TimePrecision: FixedTimePrecision(time.Hour),
+		Location:      time.UTC,
+		GeoPrecision:  DefaultGeoPrecision,
+	}
+	plan, _ := PlanChunks(params, params.EndTime)
+
+	headRows := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+	}
+	chunkRows := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 2, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 2},
+	}
+	tailRows := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 3, 1, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 3},
+	}
+
+	repo := new(mockRollupRepo)
+	repo.On("RollupAggregateRows", mock.Anything, MakeRowsFilter(plan.Head), mock.Anything).Return(headRows, nil)
+	repo.On("RollupAggregateRows", mock.Anything, MakeRowsFilter(plan.Chunks[0]), mock.Anything).Return(chunkRows, nil)
+	repo.On("RollupAggregateRows", mock.Anything, MakeRowsFilter(*plan.Tail), mock.Anything).Return(tailRows, nil)
+
+	cache := new(mockCache)
+	cache.On("GetMany", mock.Anything, mock.Anything).Return(nil, nil, nil)
+	cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil)
+	cache.On("SetMany", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	service := NewAggregatesService(repo, cache)
+
+	ctx := context.Background()
+	actual, err := service.GetAggregates(ctx, params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, MapToAggregates(append(append(headRows, chunkRows...), tailRows...)), actual.Aggregates)
+	cache.AssertCalled(t, "GetMany", ctx, plan.Chunks)
+	cache.AssertCalled(t, "SetMany", context.WithoutCancel(ctx), plan.Chunks, [][]Aggregate{MapToAggregates(chunkRows)}, []CacheVersion{{}})
+	cache.AssertNotCalled(t, "Get")
+}
+
+func TestAggregatesServiceGetAggregatesWhenChunkedFromChunkBoundary(t *testing.T) {
+	params := AggregatesReqParams{
+		StartTime:     time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+		TimePrecision: FixedTimePrecision(time.Hour),
+		Location:      time.UTC,
+		GeoPrecision:  DefaultGeoPrecision,
+	}
+	plan, _ := PlanChunks(params, params.EndTime)
+
+	// Aggregates at the start of the time range lie in the bucket ending
+	// there, before the first chunk.
+	headRows := []AggregateRow{
+		{OccurredAt: params.StartTime, Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+	}
+	cachedChunk := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 2},
+	}
+
+	repo := new(mockRollupRepo)
+	repo.On("RollupAggregateRows", mock.Anything, MakeRowsFilter(plan.Head), mock.Anything).Return(headRows, nil)
+
+	cache := new(mockCache)
+	cache.On("GetMany", mock.Anything, mock.Anything).Return([][]Aggregate{cachedChunk}, nil, nil)
+	cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil)
+
+	service := NewAggregatesService(repo, cache)
+
+	actual, err := service.GetAggregates(context.Background(), params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, append(MapToAggregates(headRows), cachedChunk...), actual.Aggregates)
+	assert.Nil(t, plan.Tail)
+}
+
+func TestAggregatesServiceGetAggregatesWhenChunksCached(t *testing.T) {
+	params := AggregatesReqParams{
+		StartTime:     time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC),
+		TimePrecision: FixedTimePrecision(time.Hour),
+		Location:      time.UTC,
+		GeoPrecision:  DefaultGeoPrecision,
+	}
+	plan, _ := PlanChunks(params, params.EndTime)
+
+	cachedChunk := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 2, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 2},
+	}
+
+	repo := new(mockRollupRepo)
+	repo.On("RollupAggregateRows", mock.Anything, MakeRowsFilter(plan.Head), mock.Anything).Return([]AggregateRow{}, nil)
+
+	cache := new(mockCache)
+	cache.On("GetMany", mock.Anything, mock.Anything).Return([][]Aggregate{cachedChunk}, nil, nil)
+	cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil)
+
+	service := NewAggregatesService(repo, cache)
+
+	ctx := context.Background()
+	actual, err := service.GetAggregates(ctx, params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, cachedChunk, actual.Aggregates)
+	assert.Nil(t, plan.Tail)
+	repo.AssertNumberOfCalls(t, "RollupAggregateRows", 1)
+	cache.AssertNotCalled(t, "SetMany")
+}
+
+func TestAggregatesServiceGetAggregatesWhenChunksStale(t *testing.T) {
+	params := AggregatesReqParams{
+		StartTime:     time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC),
+		TimePrecision: FixedTimePrecision(time.Hour),
+		Location:      time.UTC,
+		GeoPrecision:  DefaultGeoPrecision,
+	}
+	plan, _ := PlanChunks(params, params.EndTime)
+
+	staleChunk := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 2, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+	}
+	chunkRows := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 2, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 2},
+	}
+
+	repo := new(mockRollupRepo)
+	repo.On("RollupAggregateRows", mock.Anything, MakeRowsFilter(plan.Head), mock.Anything).Return([]AggregateRow{}, nil)
+	repo.On("RollupAggregateRows", mock.Anything, MakeRowsFilter(plan.Chunks[0]), mock.Anything).Return(chunkRows, nil)
+
+	refreshed := make(chan struct{})
+	cache := new(mockCache)
+	cache.On("GetMany", mock.Anything, mock.Anything).Return([][]Aggregate{staleChunk}, []bool{true}, nil)
+	cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil)
+	cache.On("SetMany", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(mock.Arguments) {
+		close(refreshed)
+	})
+
+	service := NewAggregatesService(repo, cache)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	actual, err := service.GetAggregates(ctx, params)
+	// The refresh outlives the request.
+	cancel()
+
+	assert.Nil(t, err)
+	assert.Equal(t, staleChunk, actual.Aggregates)
+
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("Cache was not refreshed")
+	}
+	cache.AssertCalled(t, "SetMany", mock.Anything, plan.Chunks, [][]Aggregate{MapToAggregates(chunkRows)}, []CacheVersion{{}})
+}
+
+func TestAggregatesServiceGetAggregatesWhenCacheStale(t *testing.T) {
+	rows := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 2},
+	}
+	staleRecords := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+	}
+
+	repo := new(mockRollupRepo)
+	repo.On("RollupAggregateRows", mock.Anything, mock.Anything, mock.Anything).Return(rows, nil)
+
+	refreshed := make(chan struct{})
+	cache := new(mockCache)
+	cache.On("Get", mock.Anything, mock.Anything).Return(NewAggregatesResult(staleRecords, time.Now()), ErrStaleKey)
+	cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil)
+	cache.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(mock.Arguments) {
+		close(refreshed)
+	})
+
+	service := NewAggregatesService(repo, cache)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	params := AggregatesReqParams{TimePrecision: DefaultTimePrecision, Location: time.UTC, GeoPrecision: DefaultGeoPrecision}
+	actual, err := service.GetAggregates(ctx, params)
+	// The refresh outlives the request.
+	cancel()
+
+	assert.Nil(t, err)
+	assert.Equal(t, staleRecords, actual.Aggregates)
+
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("Cache was not refreshed")
+	}
+	cache.AssertCalled(t, "Set", mock.Anything, params, matchesAggregates(MapToAggregates(rows)), CacheVersion{})
+}
+
+func TestAggregatesServiceGetAggregatesCoalescesCacheMisses(t *testing.T) {
+	rows := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	repo := new(mockRollupRepo)
+	repo.On("RollupAggregateRows", mock.Anything, mock.Anything, mock.Anything).Return(rows, nil).Run(func(mock.Arguments) {
+		close(started)
+		<-release
+	}).Once()
+
+	cache := new(mockCache)
+	cache.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+	cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil)
+	cache.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	service := NewAggregatesService(repo, cache)
+
+	ctx := context.Background()
+	params := AggregatesReqParams{TimePrecision: DefaultTimePrecision, Location: time.UTC, GeoPrecision: DefaultGeoPrecision}
+
+	results := make(chan []Aggregate, 2)
+	get := func() {
+		result, err := service.GetAggregates(ctx, params)
+		assert.Nil(t, err)
+		results <- result.Aggregates
+	}
+
+	go get()
+	<-started
+	go get()
+
+	// Give the second request time to start waiting on the first's query.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	for range 2 {
+		assert.Equal(t, MapToAggregates(rows), <-results)
+	}
+	repo.AssertNumberOfCalls(t, "RollupAggregateRows", 1)
+	cache.AssertNumberOfCalls(t, "Set", 1)
+}
+
+func TestAggregatesServiceGetAggregatesWhenRequestCancelled(t *testing.T) {
+	rows := []AggregateRow{
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 1},
+	}
+
+	release := make(chan struct{})
+	repo := new(mockRollupRepo)
+	repo.On("RollupAggregateRows", mock.Anything, mock.Anything, mock.Anything).Return(rows, nil).Run(func(args mock.Arguments) {
+		<-release
+		// The query is shared, so isn't cancelled along with the request.
+		assert.Nil(t, args.Get(0).(context.Context).Err())
+	})
+
+	cached := make(chan struct{})
+	cache := new(mockCache)
+	cache.On("Get", mock.Anything, mock.Anything).Return(AggregatesResult{}, ErrNoSuchKey)
+	cache.On("Versions", mock.Anything, mock.Anything).Return(nil, nil)
+	cache.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(mock.Arguments) {
+		close(cached)
+	})
+
+	service := NewAggregatesService(repo, cache)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	params := AggregatesReqParams{TimePrecision: DefaultTimePrecision, Location: time.UTC, GeoPrecision: DefaultGeoPrecision}
+	_, err := service.GetAggregates(ctx, params)
+
+	assert.ErrorIs(t, err, context.Canceled)
+
+	close(release)
+	select {
+	case <-cached:
+	case <-time.After(time.Second):
+		t.Fatal("Fetched aggregates were not cached")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcloughlin/geohash"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+)
+
+const (
+	MaxTileZoom   = 22
+	TileLayerName = "aggregates"
+	// Buffer, in tile extent units, to keep around tiles when clipping
+	// geometries, so that cell borders are not drawn at tile edges.
+	tileBuffer = 64
+)
+
+var ErrInvalidTile = errors.New("Invalid tile")
+
+// Tiles sum counts over the requested time range, so a coarse time precision
+// is used to keep cached aggregates small.
+var TileTimePrecision = FixedTimePrecision(time.Duration(24) * time.Hour)
+
+// tileGeoPrecisions maps zoom levels to the geohash precision used for tiles
+// at that zoom level, such that tiles are roughly 4-32 cells across. Zoom
+// levels beyond the end of the mapping use the maximum geohash precision.
+var tileGeoPrecisions = []int{1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 5, 6, 6}
+
+func TileGeoPrecision(z maptile.Zoom) int {
+	if int(z) >= len(tileGeoPrecisions) {
+		return MaxGeoPrecision
+	}
+	return tileGeoPrecisions[z]
+}
+
+// ParseTile parses tile coordinates from their path values, where the `y`
+// value has a `.mvt` extension.
+func ParseTile(z, x, y string) (maptile.Tile, error) {
+	y, ok := strings.CutSuffix(y, ".mvt")
+	if !ok {
+		return maptile.Tile{}, ErrInvalidTile
+	}
+
+	values := make([]uint32, 3)
+	for idx, s := range []string{z, x, y} {
+		value, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return maptile.Tile{}, err
+		}
+		values[idx] = uint32(value)
+	}
+
+	tile := maptile.New(values[1], values[2], maptile.Zoom(values[0]))
+	if tile.Z > MaxTileZoom || !tile.Valid() {
+		return maptile.Tile{}, ErrInvalidTile
+	}
+
+	return tile, nil
+}
+
+// GetTileReqParams parses the parameters for a tile request. Parameters are
+// the same as for GetAggregatesReqParams, except that the spatial extent and
+// precision are determined by the tile.
+func GetTileReqParams(tile maptile.Tile, params url.Values) (p AggregatesReqParams, err error) {
+	p, err = GetAggregatesReqParams(params)
+	if err != nil {
+		return
+	}
+
+	bound := tile.Bound()
+	p.BoundingBox = &BoundingBox{
+		MinLon: bound.Min.Lon(),
+		MinLat: bound.Min.Lat(),
+		MaxLon: bound.Max.Lon(),
+		MaxLat: bound.Max.Lat(),
+	}
+	p.GeoPrecision = TileGeoPrecision(tile.Z)
+	p.TimePrecision = TileTimePrecision
+	return
+}
+
+type CellCount struct {
+	Geohash string
+	Count   int32
+	// Counts broken down by incident type.
+	IncidentTypeCounts map[string]int32
+}
+
+// SumByCell sums counts for each geohash cell over all times and incident
+// types. Cells are returned sorted by geohash.
+func SumByCell(records []Aggregate) []CellCount {
+	indexes := make(map[string]int)
+	cells := []CellCount{}
+	for _, record := range records {
+		idx, ok := indexes[record.Geohash]
+		if !ok {
+			idx = len(cells)
+			indexes[record.Geohash] = idx
+			cells = append(cells, CellCount{Geohash: record.Geohash, IncidentTypeCounts: make(map[string]int32)})
+		}
+
+		cells[idx].Count += record.Count
+		cells[idx].IncidentTypeCounts[record.IncidentType] += record.Count
+	}
+
+	slices.SortFunc(cells, func(a, b CellCount) int {
+		return cmp.Compare(a.Geohash, b.Geohash)
+	})
+	return cells
+}
+
+// EncodeTile encodes the cell counts as a Mapbox Vector Tile, with one polygon
+// feature per cell. Features carry the cell's total count as `count`, and
+// counts per incident type as `count_<incident type>`.
+func EncodeTile(tile maptile.Tile, cells []CellCount) ([]byte, error) {
+	fc := geojson.NewFeatureCollection()
+	for _, cell := range cells {
+		box := geohash.BoundingBox(cell.Geohash)
+		bound := orb.Bound{
+			Min: orb.Point{box.MinLng, box.MinLat},
+			Max: orb.Point{box.MaxLng, box.MaxLat},
+		}
+
+		feature := geojson.NewFeature(bound.ToPolygon())
+		feature.Properties["geohash"] = cell.Geohash
+		feature.Properties["count"] = cell.Count
+		for incidentType, count := range cell.IncidentTypeCounts {
+			feature.Properties["count_"+incidentType] = count
+		}
+		fc.Append(feature)
+	}
+
+	layers := mvt.NewLayers(map[string]*geojson.FeatureCollection{TileLayerName: fc})
+	layers.ProjectToTile(tile)
+	layers.Clip(orb.Bound{
+		Min: orb.Point{-tileBuffer, -tileBuffer},
+		Max: orb.Point{mvt.DefaultExtent + tileBuffer, mvt.DefaultExtent + tileBuffer},
+	})
+
+	return mvt.Marshal(layers)
+}
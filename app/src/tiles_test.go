@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTileGeoPrecision(t *testing.T) {
+	assert.Equal(t, 1, TileGeoPrecision(0))
+	assert.Equal(t, 5, TileGeoPrecision(10))
+	assert.Equal(t, MaxGeoPrecision, TileGeoPrecision(16))
+	assert.Equal(t, MaxGeoPrecision, TileGeoPrecision(MaxTileZoom))
+}
+
+func TestParseTile(t *testing.T) {
+	actual, err := ParseTile("12", "655", "1583.mvt")
+	assert.Nil(t, err)
+	assert.Equal(t, maptile.New(655, 1583, 12), actual)
+}
+
+func TestParseTileWhenUnparsableValue(t *testing.T) {
+	for _, values := range [][]string{
+		{"twelve", "655", "1583.mvt"},
+		{"12", "-655", "1583.mvt"},
+		{"12", "655", "y.mvt"},
+	} {
+		_, err := ParseTile(values[0], values[1], values[2])
+		assert.NotNil(t, err)
+	}
+}
+
+func TestParseTileWhenUnacceptedValue(t *testing.T) {
+	for _, values := range [][]string{
+		{"12", "655", "1583"},
+		{"12", "655", "1583.png"},
+		{"1", "2", "0.mvt"},
+		{"23", "0", "0.mvt"},
+	} {
+		_, err := ParseTile(values[0], values[1], values[2])
+		assert.ErrorIs(t, ErrInvalidTile, err)
+	}
+}
+
+func TestGetTileReqParams(t *testing.T) {
+	tile := maptile.New(655, 1583, 12)
+	params := url.Values{}
+	params.Set("start_time", "2025-01-01T13:00Z")
+	params.Set("incident_types", "police_incident")
+
+	actual, err := GetTileReqParams(tile, params)
+
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), actual.StartTime)
+	assert.Equal(t, []string{IncidentTypePoliceIncident}, actual.IncidentTypes)
+	assert.Equal(t, 6, actual.GeoPrecision)
+	assert.Equal(t, TileTimePrecision, actual.TimePrecision)
+
+	bound := tile.Bound()
+	assert.Equal(t, &BoundingBox{
+		MinLon: bound.Min.Lon(),
+		MinLat: bound.Min.Lat(),
+		MaxLon: bound.Max.Lon(),
+		MaxLat: bound.Max.Lat(),
+	}, actual.BoundingBox)
+}
+
+func TestSumByCell(t *testing.T) {
+	records := []Aggregate{
+		{OccurredAt: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), Geohash: "9q8yz", IncidentType: IncidentTypePoliceIncident, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), Geohash: "9q8yy", IncidentType: IncidentTypePoliceIncident, Count: 2},
+		{OccurredAt: time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC), Geohash: "9q8yy", IncidentType: IncidentTypePoliceIncident, Count: 3},
+		{OccurredAt: time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC), Geohash: "9q8yy", IncidentType: IncidentTypeTrafficCrash, Count: 4},
+	}
+	expected := []CellCount{
+		{
+			Geohash:            "9q8yy",
+			Count:              9,
+			IncidentTypeCounts: map[string]int32{IncidentTypePoliceIncident: 5, IncidentTypeTrafficCrash: 4},
+		}, {
+			Geohash:            "9q8yz",
+			Count:              1,
+			IncidentTypeCounts: map[string]int32{IncidentTypePoliceIncident: 1},
+		},
+	}
+
+	actual := SumByCell(records)
+	assert.Equal(t, expected, actual)
+}
+
+func TestEncodeTile(t *testing.T) {
+	// Tile containing geohash cell 9q8yy.
+	tile := maptile.New(1310, 3166, 13)
+	cells := []CellCount{
+		{Geohash: "9q8yy", Count: 3, IncidentTypeCounts: map[string]int32{IncidentTypePoliceIncident: 3}},
+		// Cell outside of the tile is clipped.
+		{Geohash: "u178k", Count: 1, IncidentTypeCounts: map[string]int32{IncidentTypePoliceIncident: 1}},
+	}
+
+	data, err := EncodeTile(tile, cells)
+	require.Nil(t, err)
+
+	layers, err := mvt.Unmarshal(data)
+	require.Nil(t, err)
+	require.Len(t, layers, 1)
+
+	layer := layers[0]
+	assert.Equal(t, TileLayerName, layer.Name)
+	require.Len(t, layer.Features, 1)
+
+	feature := layer.Features[0]
+	assert.Equal(t, "Polygon", feature.Geometry.GeoJSONType())
+	assert.Equal(t, "9q8yy", feature.Properties["geohash"])
+	assert.EqualValues(t, 3, feature.Properties["count"])
+	assert.EqualValues(t, 3, feature.Properties["count_police_incident"])
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setTestTracerProvider(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previousProvider := otel.GetTracerProvider()
+	previousPropagator := otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	return recorder
+}
+
+func TestTraceRouteContinuesTrace(t *testing.T) {
+	recorder := setTestTracerProvider(t)
+
+	var handlerSpan trace.SpanContext
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		handlerSpan = trace.SpanContextFromContext(ctx)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	handler := TraceRoute("/test", next)
+
+	request := httptest.NewRequest(http.MethodGet, "/test", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		span := spans[0]
+		assert.Equal(t, "GET /test", span.Name())
+		assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+		assert.Equal(t, span.SpanContext(), handlerSpan)
+		assert.Equal(t, "Error", span.Status().Code.String())
+	}
+}
+
+func TestTraceRouteStartsTrace(t *testing.T) {
+	recorder := setTestTracerProvider(t)
+
+	handler := TraceRoute("/test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		assert.True(t, spans[0].SpanContext().IsValid())
+		assert.False(t, spans[0].Parent().IsValid())
+	}
+}
+
+func TestInitTracingWhenInvalidExporter(t *testing.T) {
+	_, err := InitTracing(context.Background(), "app", "invalid", "")
+	assert.ErrorIs(t, err, ErrInvalidTracesExporter)
+}
@@ -6,15 +6,23 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"slices"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type AggregateItem struct {
-	OccurredAt time.Time `json:"occurred_at"`
-	Geohash    string    `json:"geohash"`
-	Count      int       `json:"count"`
+	OccurredAt   time.Time `json:"occurred_at"`
+	Geohash      string    `json:"geohash"`
+	IncidentType string    `json:"incident_type"`
+	Count        int       `json:"count"`
 }
 
 func FlattenBucketCounts(bucketCounts map[Bucket]int) []AggregateItem {
@@ -22,9 +30,10 @@ func FlattenBucketCounts(bucketCounts map[Bucket]int) []AggregateItem {
 	idx := 0
 	for bucket, count := range bucketCounts {
 		records[idx] = AggregateItem{
-			OccurredAt: bucket.Timestamp,
-			Geohash:    bucket.Geohash,
-			Count:      count,
+			OccurredAt:   bucket.Timestamp,
+			Geohash:      bucket.Geohash,
+			IncidentType: bucket.IncidentType,
+			Count:        count,
 		}
 		idx++
 	}
@@ -37,6 +46,9 @@ func FlattenBucketCounts(bucketCounts map[Bucket]int) []AggregateItem {
 		if n := cmp.Compare(a.Geohash, b.Geohash); n != 0 {
 			return n
 		}
+		if n := cmp.Compare(a.IncidentType, b.IncidentType); n != 0 {
+			return n
+		}
 		return cmp.Compare(a.Count, b.Count)
 	})
 
@@ -50,45 +62,79 @@ type HttpRequestDoer interface {
 // AggregatesServiceClient is an HTTP client for interacting with the aggregates
 // service (i.e. `app`).
 type AggregatesServiceClient struct {
-	client  HttpRequestDoer
-	url     string
-	retries int
-	backoff time.Duration
+	client      HttpRequestDoer
+	url         string
+	credentials Credentials
+	retries     int
+	backoff     time.Duration
 }
 
-func NewAggregatesServiceClient(url string, timeout time.Duration, retries int, backoff time.Duration) *AggregatesServiceClient {
+func NewAggregatesServiceClient(url string, credentials Credentials, timeout time.Duration, retries int, backoff time.Duration) *AggregatesServiceClient {
 	client := &http.Client{Timeout: timeout}
-	return &AggregatesServiceClient{client: client, url: url, retries: retries, backoff: backoff}
+	return &AggregatesServiceClient{client: client, url: url, credentials: credentials, retries: retries, backoff: backoff}
 }
 
-func NewAggregatesServiceClientFromHttpClient(httpClient HttpRequestDoer, url string, timeout time.Duration, retries int, backoff time.Duration) *AggregatesServiceClient {
-	return &AggregatesServiceClient{client: httpClient, url: url, retries: retries, backoff: backoff}
+func NewAggregatesServiceClientFromHttpClient(httpClient HttpRequestDoer, url string, credentials Credentials, timeout time.Duration, retries int, backoff time.Duration) *AggregatesServiceClient {
+	return &AggregatesServiceClient{client: httpClient, url: url, credentials: credentials, retries: retries, backoff: backoff}
 }
 
 // Dispatch sends a prepared request using the configured client. The request is
 // retried with linear backoff if the server responds with a 429 or 5xx status
-// code.
-func (c *AggregatesServiceClient) Dispatch(request *http.Request) error {
-	for attemptNumber := range c.retries + 1 {
-		response, err := c.client.Do(request)
+// code, and fails if it does so for every attempt, or if the request's context
+// is done while backing off. Credentials are added to each attempt, so that
+// signatures are fresh. The trace of the request's context is propagated to
+// the server.
+func (c *AggregatesServiceClient) Dispatch(request *http.Request) (err error) {
+	ctx, span := tracer().Start(
+		request.Context(),
+		"AggregatesServiceClient.Dispatch",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPRequestMethodKey.String(request.Method), semconv.URLFull(request.URL.String())),
+	)
+	defer func() { endSpan(span, err) }()
+
+	request = request.WithContext(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
+
+	var body []byte
+	if request.Body != nil {
+		data, err := io.ReadAll(request.Body)
+		request.Body.Close()
 		if err != nil {
 			return err
 		}
+		body = data
+	}
 
-		if response.StatusCode == http.StatusOK {
-			break
+	err = RetryWithBackoff(ctx, c.retries, c.backoff, func(attemptNumber int) (bool, error) {
+		if attemptNumber > 0 {
+			dispatchRetries.Inc()
+			span.SetAttributes(attribute.Int("http.request.resend_count", attemptNumber))
 		}
 
-		if response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= http.StatusInternalServerError {
-			// TODO: Jitter.
-			failures := 1 + attemptNumber
-			time.Sleep(time.Duration(failures) * c.backoff)
-		} else {
-			return fmt.Errorf("HTTP error: %s", response.Status)
+		if body != nil {
+			request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		if err := c.credentials.Authorize(request, body, time.Now()); err != nil {
+			return false, err
 		}
-	}
 
-	return nil
+		response, err := c.client.Do(request)
+		if err != nil {
+			return false, err
+		}
+		response.Body.Close()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(response.StatusCode))
+
+		if response.StatusCode == http.StatusOK {
+			return false, nil
+		}
+		return IsRetryableStatus(response.StatusCode), fmt.Errorf("HTTP error: %s", response.Status)
+	})
+	if err != nil {
+		dispatchFailures.Inc()
+	}
+	return err
 }
 
 // PostAggregates sends a POST request to the aggregates service to write
@@ -5,21 +5,25 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
 func TestFlattenBucketCounts(t *testing.T) {
 	bucketCounts := map[Bucket]int{
-		{Timestamp: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg"}: 1,
-		{Timestamp: time.Date(2025, 1, 2, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg"}: 2,
+		{Timestamp: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeTrafficCrash}: 1,
+		{Timestamp: time.Date(2025, 1, 2, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident}: 2,
+		{Timestamp: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentType311Case}:      3,
 	}
 	expected := []AggregateItem{
-		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 1},
-		{OccurredAt: time.Date(2025, 1, 2, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", Count: 2},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentType311Case, Count: 3},
+		{OccurredAt: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeTrafficCrash, Count: 1},
+		{OccurredAt: time.Date(2025, 1, 2, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypeFireIncident, Count: 2},
 	}
 
 	actual := FlattenBucketCounts(bucketCounts)
@@ -37,13 +41,14 @@ func (m *mockHttpClient) Do(req *http.Request) (*http.Response, error) {
 
 func TestAggregatesServiceClientPostAggregates(t *testing.T) {
 	bucketCounts := map[Bucket]int{
-		{Timestamp: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg"}: 1,
-		{Timestamp: time.Date(2025, 1, 2, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg"}: 2,
+		{Timestamp: time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident}: 1,
+		{Timestamp: time.Date(2025, 1, 2, 13, 0, 0, 0, time.UTC), Geohash: "abcdefg", IncidentType: IncidentTypePoliceIncident}: 2,
 	}
 
 	var (
-		payload string
-		method  string
+		payload       string
+		method        string
+		authorization string
 	)
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		data, err := io.ReadAll(r.Body)
@@ -51,14 +56,77 @@ func TestAggregatesServiceClientPostAggregates(t *testing.T) {
 			payload = string(data)
 		}
 		method = r.Method
+		authorization = r.Header.Get("Authorization")
 		defer r.Body.Close()
 	}))
 	defer ts.Close()
 
-	client := NewAggregatesServiceClient(ts.URL, time.Second, 0, time.Second)
+	credentials := Credentials{Method: AuthMethodAPIKey, KeyID: "consumer", Secret: "secret"}
+	client := NewAggregatesServiceClient(ts.URL, credentials, time.Second, 0, time.Second)
 	err := client.PostAggregates(context.Background(), bucketCounts)
 	assert.Nil(t, err)
 
-	assert.Equal(t, `[{"occurred_at":"2025-01-01T13:00:00Z","geohash":"abcdefg","count":1},{"occurred_at":"2025-01-02T13:00:00Z","geohash":"abcdefg","count":2}]`, payload)
+	assert.Equal(t, `[{"occurred_at":"2025-01-01T13:00:00Z","geohash":"abcdefg","incident_type":"police_incident","count":1},{"occurred_at":"2025-01-02T13:00:00Z","geohash":"abcdefg","incident_type":"police_incident","count":2}]`, payload)
 	assert.Equal(t, http.MethodPost, method)
+	assert.Equal(t, "Bearer secret", authorization)
+}
+
+func TestAggregatesServiceClientDispatchRetries(t *testing.T) {
+	var payloads []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		payloads = append(payloads, string(data))
+		if len(payloads) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	credentials := Credentials{Method: AuthMethodHMAC, KeyID: "consumer", Secret: "secret"}
+	client := NewAggregatesServiceClient(ts.URL, credentials, time.Second, 1, time.Millisecond)
+	request, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("[]"))
+
+	err := client.Dispatch(request)
+	assert.Nil(t, err)
+	// The body is resent when retrying.
+	assert.Equal(t, []string{"[]", "[]"}, payloads)
+}
+
+func TestAggregatesServiceClientDispatchWhenRetriesExhausted(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	retriesBefore := testutil.ToFloat64(dispatchRetries)
+	failuresBefore := testutil.ToFloat64(dispatchFailures)
+
+	credentials := Credentials{Method: AuthMethodAPIKey, Secret: "secret"}
+	client := NewAggregatesServiceClient(ts.URL, credentials, time.Second, 2, time.Millisecond)
+	request, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("[]"))
+
+	err := client.Dispatch(request)
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, retriesBefore+2, testutil.ToFloat64(dispatchRetries))
+	assert.Equal(t, failuresBefore+1, testutil.ToFloat64(dispatchFailures))
+}
+
+func TestAggregatesServiceClientDispatchWhenCancelledDuringBackoff(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	credentials := Credentials{Method: AuthMethodAPIKey, Secret: "secret"}
+	client := NewAggregatesServiceClient(ts.URL, credentials, time.Second, 1, time.Hour)
+	request, _ := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL, strings.NewReader("[]"))
+
+	err := client.Dispatch(request)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Poster interface {
@@ -14,20 +15,32 @@ type Poster interface {
 // and writes the counts to the data sink.
 type AggregateWriter struct {
 	client   Poster
+	decoder  *Decoder
 	bucketer *Bucketer
+	dlq      *DeadLetterQueue
 }
 
-func NewAggregateWriter(client Poster, bucketer *Bucketer) *AggregateWriter {
-	return &AggregateWriter{client: client, bucketer: bucketer}
+func NewAggregateWriter(client Poster, decoder *Decoder, bucketer *Bucketer, dlq *DeadLetterQueue) *AggregateWriter {
+	return &AggregateWriter{client: client, decoder: decoder, bucketer: bucketer, dlq: dlq}
 }
 
 // Aggregate aggregates/buckets messages by time and location of incident and
-// returns the counts by bucket.
-func (w *AggregateWriter) Aggregate(messages []kafka.Message) map[Bucket]int {
+// returns the counts by bucket. Messages which cannot be aggregated are passed
+// to `drop`.
+func (w *AggregateWriter) Aggregate(ctx context.Context, messages []kafka.Message, drop DropFunc) (map[Bucket]int, error) {
+	records, err := w.decoder.DecodeMessages(ctx, messages, drop)
+	if err != nil {
+		return nil, err
+	}
+
 	bucketCounts := make(map[Bucket]int)
-	for record := range DecodeMessages(SchemaNameHeader, messages) {
+	for message, record := range records {
 		bucket, ok := w.bucketer.MakeBucket(record)
 		if !ok {
+			messagesDropped.WithLabelValues(dropReasonNoCoordinates).Inc()
+			if drop != nil {
+				drop(message, dropReasonNoCoordinates, ErrNoCoordinates)
+			}
 			continue
 		}
 
@@ -39,7 +52,7 @@ func (w *AggregateWriter) Aggregate(messages []kafka.Message) map[Bucket]int {
 		bucketCounts[bucket] = count + 1
 	}
 
-	return bucketCounts
+	return bucketCounts, nil
 }
 
 // WriteAggregateRecords writes the given counts to the data sink.
@@ -49,12 +62,31 @@ func (w *AggregateWriter) WriteAggregateRecords(ctx context.Context, bucketCount
 
 // Write aggregates/buckets messages by time and location of incident and writes
 // the counts to the data sink.
-// NB: Any message which cannot be decoded will be dropped.
+// NB: Any message which cannot be aggregated will be dropped, and published to
+// the dead-letter queue before the counts are written.
 func (w *AggregateWriter) Write(ctx context.Context, messages []kafka.Message) error {
 	if len(messages) == 0 {
 		return nil
 	}
 
-	bucketCounts := w.Aggregate(messages)
-	return w.WriteAggregateRecords(ctx, bucketCounts)
+	ctx, span := tracer().Start(ctx, "AggregateWriter.Write")
+	span.SetAttributes(batchAttributes(messages)...)
+
+	var deadLetters DeadLetters
+	bucketCounts, err := w.Aggregate(ctx, messages, deadLetters.Add)
+	if err != nil {
+		endSpan(span, err)
+		return err
+	}
+	span.SetAttributes(attribute.Int("aggregates.bucket_count", len(bucketCounts)))
+
+	// Dead letters are published first, as the batch is retried if either
+	// fails, and counts which were written would be counted again. Dead letters
+	// may be published more than once instead.
+	err = w.dlq.Publish(ctx, deadLetters)
+	if err == nil {
+		err = w.WriteAggregateRecords(ctx, bucketCounts)
+	}
+	endSpan(span, err)
+	return err
 }
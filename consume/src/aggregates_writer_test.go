@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"github.com/segmentio/kafka-go"
 	"testing"
 	"time"
@@ -30,7 +31,7 @@ func TestAggregateWriterAggregate(t *testing.T) {
 	}
 	payloadWithoutLocation, _ := recordWithoutLocation.Marshal()
 
-	writer := NewAggregateWriter(nil, NewBucketer(timePrecision, geohashPrecision))
+	writer := NewAggregateWriter(nil, NewDecoder(SchemaNameHeader, nil), NewBucketer(timePrecision, geohashPrecision, time.UTC), nil)
 	messages := []kafka.Message{
 		{Headers: []kafka.Header{{Key: SchemaNameHeader, Value: []byte(SchemaNameFireEMSCall)}}, Value: payload},
 		// Message with unrecognized schema is skipped.
@@ -43,11 +44,20 @@ func TestAggregateWriterAggregate(t *testing.T) {
 	}
 
 	expected := make(map[Bucket]int)
-	expected[Bucket{Timestamp: expectedTimestamp, Geohash: expectedGeohash}] = 2
+	expected[Bucket{Timestamp: expectedTimestamp, Geohash: expectedGeohash, IncidentType: IncidentTypeFireEMSCall}] = 2
 
-	actual := writer.Aggregate(messages)
+	var deadLetters DeadLetters
+	actual, err := writer.Aggregate(context.Background(), messages, deadLetters.Add)
 
+	assert.Nil(t, err)
 	assert.Equal(t, expected, actual)
+	if assert.Len(t, deadLetters, 3) {
+		assert.Equal(t, dropReasonUnrecognizedSchema, deadLetters[0].Reason)
+		assert.Equal(t, dropReasonMissingHeader, deadLetters[1].Reason)
+		assert.Equal(t, dropReasonNoCoordinates, deadLetters[2].Reason)
+		assert.ErrorIs(t, deadLetters[2].Err, ErrNoCoordinates)
+		assert.Equal(t, messages[4], deadLetters[2].Message)
+	}
 }
 
 type mockClient struct {
@@ -78,9 +88,68 @@ func TestAggregateWriterWrite(t *testing.T) {
 	mockC := new(mockClient)
 	mockC.On("PostAggregates", mock.Anything, mock.Anything).Return(nil)
 
-	writer := NewAggregateWriter(mockC, NewBucketer(timePrecision, geohashPrecision))
+	writer := NewAggregateWriter(mockC, NewDecoder(SchemaNameHeader, nil), NewBucketer(timePrecision, geohashPrecision, time.UTC), nil)
+	err := writer.Write(context.Background(), messages)
+
+	assert.Nil(t, err)
+	mockC.AssertNumberOfCalls(t, "PostAggregates", 1)
+}
+
+func TestAggregateWriterWritePublishesDeadLetters(t *testing.T) {
+	record := &FireEmsCall{
+		ReceivedDttm: time.Date(2025, 1, 1, 13, 14, 15, 0, time.UTC),
+		Lat:          37.786358,
+		Long:         -122.41983,
+	}
+	payload, _ := record.Marshal()
+
+	messages := []kafka.Message{
+		{Headers: []kafka.Header{{Key: SchemaNameHeader, Value: []byte(SchemaNameFireEMSCall)}}, Value: payload},
+		{Headers: []kafka.Header{{Key: SchemaNameHeader, Value: []byte("Unknown")}}, Value: []byte("abc")},
+	}
+
+	mockC := new(mockClient)
+	mockC.On("PostAggregates", mock.Anything, mock.Anything).Return(nil)
+	mockW := new(mockMessageWriter)
+	mockW.On("WriteMessages", mock.Anything, mock.Anything).Return(nil)
+
+	dlq := NewDeadLetterQueue(mockW, time.Now)
+	writer := NewAggregateWriter(mockC, NewDecoder(SchemaNameHeader, nil), NewBucketer(time.Minute, 9, time.UTC), dlq)
 	err := writer.Write(context.Background(), messages)
 
 	assert.Nil(t, err)
 	mockC.AssertNumberOfCalls(t, "PostAggregates", 1)
+	mockW.AssertNumberOfCalls(t, "WriteMessages", 1)
+	published := mockW.Calls[0].Arguments.Get(1).([]kafka.Message)
+	if assert.Len(t, published, 1) {
+		assert.Equal(t, messages[1].Value, published[0].Value)
+	}
+}
+
+func TestAggregateWriterWriteWhenPublishFails(t *testing.T) {
+	errPublishFailed := errors.New("publish failed")
+	record := &FireEmsCall{
+		ReceivedDttm: time.Date(2025, 1, 1, 13, 14, 15, 0, time.UTC),
+		Lat:          37.786358,
+		Long:         -122.41983,
+	}
+	payload, _ := record.Marshal()
+
+	messages := []kafka.Message{
+		{Headers: []kafka.Header{{Key: SchemaNameHeader, Value: []byte(SchemaNameFireEMSCall)}}, Value: payload},
+		{Headers: []kafka.Header{{Key: SchemaNameHeader, Value: []byte("Unknown")}}, Value: []byte("abc")},
+	}
+
+	mockC := new(mockClient)
+	mockW := new(mockMessageWriter)
+	mockW.On("WriteMessages", mock.Anything, mock.Anything).Return(errPublishFailed)
+
+	dlq := NewDeadLetterQueue(mockW, time.Now)
+	writer := NewAggregateWriter(mockC, NewDecoder(SchemaNameHeader, nil), NewBucketer(time.Minute, 9, time.UTC), dlq)
+	err := writer.Write(context.Background(), messages)
+
+	// Counts are only written once dead letters are published, as the batch is
+	// retried otherwise.
+	assert.ErrorIs(t, err, errPublishFailed)
+	mockC.AssertNotCalled(t, "PostAggregates", mock.Anything, mock.Anything)
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AuthMethodAPIKey = "api_key"
+	AuthMethodHMAC   = "hmac"
+)
+
+// Credentials for the aggregates service. See `app` for how requests are
+// authenticated.
+type Credentials struct {
+	Method string
+	KeyID  string
+	Secret string
+}
+
+// Authorize adds credentials to the request, signing it and its body at the
+// given time if using HMAC auth.
+func (c Credentials) Authorize(request *http.Request, body []byte, now time.Time) error {
+	switch c.Method {
+	case AuthMethodAPIKey:
+		request.Header.Set("Authorization", "Bearer "+c.Secret)
+	case AuthMethodHMAC:
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		digest := sha256.Sum256(body)
+		signatureString := strings.Join([]string{
+			request.Method,
+			request.URL.RequestURI(),
+			timestamp,
+			hex.EncodeToString(digest[:]),
+		}, "\n")
+
+		mac := hmac.New(sha256.New, []byte(c.Secret))
+		mac.Write([]byte(signatureString))
+		signature := hex.EncodeToString(mac.Sum(nil))
+
+		request.Header.Set("Authorization", fmt.Sprintf("HMAC %s:%s", c.KeyID, signature))
+		request.Header.Set("X-Auth-Timestamp", timestamp)
+	default:
+		return fmt.Errorf("Unknown auth method: %s", c.Method)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialsAuthorize(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("API key", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodPost, "http://app:8080/aggregates", nil)
+		credentials := Credentials{Method: AuthMethodAPIKey, KeyID: "consumer", Secret: "secret"}
+
+		err := credentials.Authorize(request, nil, now)
+		assert.Nil(t, err)
+		assert.Equal(t, "Bearer secret", request.Header.Get("Authorization"))
+	})
+
+	t.Run("HMAC", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodPost, "http://app:8080/aggregates", nil)
+		credentials := Credentials{Method: AuthMethodHMAC, KeyID: "consumer", Secret: "secret"}
+
+		err := credentials.Authorize(request, []byte("[]"), now)
+		assert.Nil(t, err)
+		// Signature of "POST\n/aggregates\n1735732800\n<sha256 of []>".
+		assert.Equal(t, "HMAC consumer:00063425fb7eaefb2442b53fe93d40a5c43874de412ce9da7c5339dfab703b1c", request.Header.Get("Authorization"))
+		assert.Equal(t, "1735732800", request.Header.Get("X-Auth-Timestamp"))
+	})
+
+	t.Run("Unknown method", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodPost, "http://app:8080/aggregates", nil)
+		credentials := Credentials{Method: "basic"}
+
+		err := credentials.Authorize(request, nil, now)
+		assert.NotNil(t, err)
+	})
+}
@@ -7,18 +7,43 @@ import (
 )
 
 type Bucket struct {
-	Timestamp time.Time
-	Geohash   string
+	Timestamp    time.Time
+	Geohash      string
+	IncidentType string
 }
 
 // BucketTime rounds the given time to `precision`, such that the given time
-// occurred no later than the returned time.
-func BucketTime(t time.Time, precision time.Duration) time.Time {
-	truncated := t.Truncate(precision)
-	if truncated == t {
-		return truncated
+// occurred no later than the returned time. Buckets are aligned to the wall
+// clock in the given location, and returned in UTC.
+func BucketTime(t time.Time, precision time.Duration, loc *time.Location) time.Time {
+	_, offset := t.In(loc).Zone()
+	shift := time.Duration(offset) * time.Second
+
+	wall := t.Add(shift)
+	truncated := wall.Truncate(precision)
+	if !truncated.Equal(wall) {
+		truncated = truncated.Add(precision)
+	}
+	bucket := truncated.Add(-shift)
+
+	_, bucketOffset := bucket.In(loc).Zone()
+	if bucketOffset != offset {
+		// A daylight saving time transition occurs within the bucket, so align
+		// the bucket to the wall clock after the transition, unless that wall
+		// clock time was skipped over.
+		adjusted := bucket.Add(time.Duration(offset-bucketOffset) * time.Second)
+		if !adjusted.Before(t) {
+			bucket = adjusted
+		}
 	}
-	return truncated.Add(precision)
+
+	return bucket.UTC()
+}
+
+// LocalizeTime interprets the wall clock of a naive timestamp, which is decoded
+// as UTC, as being in the given location.
+func LocalizeTime(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
 }
 
 // BucketLocation assigns the geographic coordinates to a spatial bucket.
@@ -31,27 +56,33 @@ func BucketLocation(longitude, latitude float32, geohashPrecision uint) string {
 type Bucketer struct {
 	TimePrecision    time.Duration
 	GeohashPrecision uint
+	// Location in which records' naive timestamps were recorded.
+	SourceLocation *time.Location
 }
 
-func NewBucketer(timePrecision time.Duration, geohashPrecision uint) *Bucketer {
+func NewBucketer(timePrecision time.Duration, geohashPrecision uint, sourceLocation *time.Location) *Bucketer {
 	if timePrecision <= 0 {
 		panic("Time precision must be positive")
 	}
 	if geohashPrecision == 0 {
 		panic("Geohash precision must be positive")
 	}
-	return &Bucketer{TimePrecision: timePrecision, GeohashPrecision: geohashPrecision}
+	if sourceLocation == nil {
+		panic("Source location must be given")
+	}
+	return &Bucketer{TimePrecision: timePrecision, GeohashPrecision: geohashPrecision, SourceLocation: sourceLocation}
 }
 
-// BucketRecord assigns temporal and spatial buckets to the given record.
+// MakeBucket assigns temporal and spatial buckets to the given record, keyed by
+// the record's incident type. Bucket timestamps are in UTC.
 func (b *Bucketer) MakeBucket(record ProcessableRecord) (Bucket, bool) {
 	coordinates := record.Coordinates()
 	if coordinates == nil {
 		return Bucket{}, false
 	}
 
-	ts := record.Timestamp()
+	ts := LocalizeTime(record.Timestamp(), b.SourceLocation)
 	geohash := BucketLocation(coordinates.Longitude, coordinates.Latitude, b.GeohashPrecision)
-	timestamp := BucketTime(ts, b.TimePrecision)
-	return Bucket{Timestamp: timestamp, Geohash: geohash}, true
+	timestamp := BucketTime(ts, b.TimePrecision, b.SourceLocation)
+	return Bucket{Timestamp: timestamp, Geohash: geohash, IncidentType: record.IncidentType()}, true
 }
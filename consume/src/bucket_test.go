@@ -23,11 +23,52 @@ func TestBucketTime(t *testing.T) {
 	for _, testCase := range testCases {
 		timestamp, _ := time.Parse(time.DateTime, testCase.Datetime)
 		expected, _ := time.Parse(time.DateTime, testCase.Expected)
-		actual := BucketTime(timestamp, precision)
+		actual := BucketTime(timestamp, precision, time.UTC)
 		assert.Equal(t, expected, actual)
 	}
 }
 
+func TestBucketTimeInLocation(t *testing.T) {
+	loc, _ := time.LoadLocation("America/Los_Angeles")
+
+	type testCase struct {
+		Timestamp time.Time
+		Precision time.Duration
+		Expected  time.Time
+	}
+
+	testCases := []testCase{
+		{
+			Timestamp: time.Date(2025, 1, 1, 20, 0, 0, 0, loc),
+			Precision: 24 * time.Hour,
+			Expected:  time.Date(2025, 1, 2, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			Timestamp: time.Date(2025, 3, 9, 1, 0, 0, 0, loc),
+			Precision: 24 * time.Hour,
+			Expected:  time.Date(2025, 3, 10, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			// Repeated hour with fall back, in PST.
+			Timestamp: time.Date(2025, 11, 2, 9, 30, 5, 0, time.UTC),
+			Precision: time.Minute,
+			Expected:  time.Date(2025, 11, 2, 9, 31, 0, 0, time.UTC),
+		},
+	}
+	for _, testCase := range testCases {
+		actual := BucketTime(testCase.Timestamp, testCase.Precision, loc)
+		assert.Equal(t, testCase.Expected, actual)
+	}
+}
+
+func TestLocalizeTime(t *testing.T) {
+	loc, _ := time.LoadLocation("America/Los_Angeles")
+	timestamp := time.Date(2025, 1, 1, 13, 4, 5, 0, time.UTC)
+
+	actual := LocalizeTime(timestamp, loc)
+	assert.Equal(t, time.Date(2025, 1, 1, 21, 4, 5, 0, time.UTC), actual.UTC())
+}
+
 func TestBucketLocation(t *testing.T) {
 	geohashPrecision := uint(9)
 	latitude := float32(52.09367)
@@ -41,7 +82,7 @@ func TestBucketLocation(t *testing.T) {
 func TestBucketerMakeBucket(t *testing.T) {
 	timePrecision := time.Minute
 	geohashPrecision := uint(9)
-	bucketer := NewBucketer(timePrecision, geohashPrecision)
+	bucketer := NewBucketer(timePrecision, geohashPrecision, time.UTC)
 
 	record := &FireEmsCall{
 		ReceivedDttm: time.Date(2025, 1, 1, 13, 4, 5, 0, time.UTC),
@@ -55,12 +96,29 @@ func TestBucketerMakeBucket(t *testing.T) {
 	assert.True(t, ok)
 	assert.Equal(t, expectedTimestamp, actual.Timestamp)
 	assert.Equal(t, expectedGeohash, actual.Geohash)
+	assert.Equal(t, IncidentTypeFireEMSCall, actual.IncidentType)
+}
+
+func TestBucketerMakeBucketInSourceLocation(t *testing.T) {
+	loc, _ := time.LoadLocation("America/Los_Angeles")
+	bucketer := NewBucketer(time.Minute, uint(9), loc)
+
+	record := &FireEmsCall{
+		ReceivedDttm: time.Date(2025, 1, 1, 13, 4, 5, 0, time.UTC),
+		Lat:          52.09367,
+		Long:         5.124242,
+	}
+	expectedTimestamp := time.Date(2025, 1, 1, 21, 5, 0, 0, time.UTC)
+
+	actual, ok := bucketer.MakeBucket(record)
+	assert.True(t, ok)
+	assert.Equal(t, expectedTimestamp, actual.Timestamp)
 }
 
 func TestBucketerMakeBucketWhenNoCoordinates(t *testing.T) {
 	timePrecision := time.Minute
 	geohashPrecision := uint(9)
-	bucketer := NewBucketer(timePrecision, geohashPrecision)
+	bucketer := NewBucketer(timePrecision, geohashPrecision, time.UTC)
 
 	record := &PoliceIncident{
 		IncidentDatetime: time.Date(2025, 1, 1, 13, 4, 5, 0, time.UTC),
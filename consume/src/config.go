@@ -11,6 +11,12 @@ const (
 	AggregateConsumerType = "aggregates"
 )
 
+// Consumers either consume the topic, or replay the dead-letter topic.
+const (
+	ConsumeMode = "consume"
+	ReplayMode  = "replay"
+)
+
 func LookupDuration(name string) (time.Duration, bool) {
 	s, ok := os.LookupEnv(name)
 	if !ok {
@@ -25,6 +31,20 @@ func LookupDuration(name string) (time.Duration, bool) {
 	return d, true
 }
 
+func LookupLocation(name string) (*time.Location, bool) {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, false
+	}
+
+	loc, err := time.LoadLocation(s)
+	if err != nil {
+		return nil, false
+	}
+
+	return loc, true
+}
+
 func LookupInt(name string) (int, bool) {
 	s, ok := os.LookupEnv(name)
 	if !ok {
@@ -47,19 +67,33 @@ func LookupUint(name string) (uint, bool) {
 
 type Config struct {
 	ConsumerType           string
+	ConsumerMode           string
 	Topic                  string
 	BrokerURL              string
 	ConsumerGroupID        string
 	BufferSize             int
 	FlushInterval          time.Duration
+	ShutdownTimeout        time.Duration
 	BucketTimePrecision    time.Duration
 	BucketGeohashPrecision uint
+	SourceLocation         *time.Location
 	AggregatesDatabaseURL  string
 	WarehouseURL           string
 	AppURL                 string
+	AppCredentials         Credentials
 	HttpRequestTimeout     time.Duration
 	HttpRequestRetries     int
 	HttpRequestBackoff     time.Duration
+	// Port on which health checks are served.
+	Port string
+	// Span exporter, and the file spans are written to by the file exporter.
+	TracesExporter string
+	TracesFile     string
+	// Topic dropped messages are published to. Disabled if empty.
+	DeadLetterTopic string
+	// Schema registry for messages in the wire format, either an HTTP url or
+	// a `file` url of a directory of schemas. Disabled if empty.
+	SchemaRegistryURL string
 }
 
 func NewConfig() (*Config, bool) {
@@ -91,6 +125,11 @@ func NewConfig() (*Config, bool) {
 		return nil, false
 	}
 
+	config.ShutdownTimeout, ok = LookupDuration("SHUTDOWN_TIMEOUT")
+	if !ok {
+		return nil, false
+	}
+
 	config.BucketTimePrecision, ok = LookupDuration("BUCKET_TIME_PRECISION")
 	if !ok {
 		return nil, false
@@ -101,11 +140,31 @@ func NewConfig() (*Config, bool) {
 		return nil, false
 	}
 
+	config.SourceLocation, ok = LookupLocation("SOURCE_TIMEZONE")
+	if !ok {
+		return nil, false
+	}
+
 	config.ConsumerType, ok = os.LookupEnv("CONSUMER_TYPE")
 	if !ok {
 		return nil, false
 	}
 
+	config.ConsumerMode, ok = os.LookupEnv("CONSUMER_MODE")
+	if !ok {
+		return nil, false
+	}
+
+	config.DeadLetterTopic, ok = os.LookupEnv("DEAD_LETTER_TOPIC")
+	if !ok {
+		return nil, false
+	}
+
+	config.SchemaRegistryURL, ok = os.LookupEnv("SCHEMA_REGISTRY_URL")
+	if !ok {
+		return nil, false
+	}
+
 	config.AggregatesDatabaseURL, ok = os.LookupEnv("AGGREGATES_DB_URL")
 	if !ok {
 		return nil, false
@@ -121,6 +180,21 @@ func NewConfig() (*Config, bool) {
 		return nil, false
 	}
 
+	config.AppCredentials.Method, ok = os.LookupEnv("APP_AUTH_METHOD")
+	if !ok {
+		return nil, false
+	}
+
+	config.AppCredentials.KeyID, ok = os.LookupEnv("APP_KEY_ID")
+	if !ok {
+		return nil, false
+	}
+
+	config.AppCredentials.Secret, ok = os.LookupEnv("APP_KEY_SECRET")
+	if !ok {
+		return nil, false
+	}
+
 	config.HttpRequestTimeout, ok = LookupDuration("HTTP_REQUEST_TIMEOUT")
 	if !ok {
 		return nil, false
@@ -136,5 +210,22 @@ func NewConfig() (*Config, bool) {
 		return nil, false
 	}
 
+	config.Port, ok = os.LookupEnv("CONSUMER_PORT")
+	if !ok {
+		return nil, false
+	}
+
+	config.TracesExporter, ok = os.LookupEnv("TRACES_EXPORTER")
+	if !ok {
+		return nil, false
+	}
+
+	if config.TracesExporter == TracesExporterFile {
+		config.TracesFile, ok = os.LookupEnv("TRACES_FILE")
+		if !ok {
+			return nil, false
+		}
+	}
+
 	return config, true
 }
@@ -4,9 +4,13 @@ import (
 	"context"
 	"errors"
 	"log/slog"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/segmentio/kafka-go"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Readable provides methods for consuming messages from Kafka.
@@ -27,22 +31,37 @@ type BufferedConsumer struct {
 	BufferSize int
 	// Maximum time to wait between flushing.
 	FlushInterval time.Duration
+	// Maximum time to spend flushing buffered messages once processing is
+	// stopped.
+	ShutdownTimeout time.Duration
 
 	reader Readable
 	writer Writable
 	buffer []kafka.Message
+	// Whether Process is running.
+	running atomic.Bool
 }
 
-func NewBufferedConsumer(bufferSize int, flushInterval time.Duration, reader Readable, writer Writable) *BufferedConsumer {
+func NewBufferedConsumer(bufferSize int, flushInterval, shutdownTimeout time.Duration, reader Readable, writer Writable) *BufferedConsumer {
 	if bufferSize <= 0 {
 		panic("Buffer size must be positive")
 	}
 	if flushInterval <= 0 {
 		panic("Flush interval must be positive")
 	}
+	if shutdownTimeout <= 0 {
+		panic("Shutdown timeout must be positive")
+	}
 
 	buffer := make([]kafka.Message, 0, bufferSize)
-	return &BufferedConsumer{BufferSize: bufferSize, FlushInterval: flushInterval, reader: reader, writer: writer, buffer: buffer}
+	return &BufferedConsumer{
+		BufferSize:      bufferSize,
+		FlushInterval:   flushInterval,
+		ShutdownTimeout: shutdownTimeout,
+		reader:          reader,
+		writer:          writer,
+		buffer:          buffer,
+	}
 }
 
 // BufferEmpty returns whether the buffer is empty or not.
@@ -63,22 +82,52 @@ func (r *BufferedConsumer) Fetch(ctx context.Context) error {
 		return ErrBufferFull
 	}
 
+	start := time.Now()
 	msg, err := r.reader.FetchMessage(ctx)
 	if err != nil {
 		return err
 	}
 
+	// The span continues the producer's trace, which is only known once the
+	// message has been fetched.
+	_, span := tracer().Start(
+		MessageContext(ctx, msg),
+		"BufferedConsumer.Fetch",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithTimestamp(start),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName(msg.Topic),
+			semconv.MessagingDestinationPartitionID(strconv.Itoa(msg.Partition)),
+			semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+		),
+	)
+	span.End()
+
 	r.buffer = append(r.buffer, msg)
+	messagesFetched.Inc()
 	return nil
 }
 
 // Flush flushes buffered messages out and marks them as committed in Kafka.
-// Note that messages may be processed more than once.
-func (r *BufferedConsumer) Flush(ctx context.Context) (int, error) {
+// Note that messages may be processed more than once. The flush is traced as a
+// span linked to the traces of the buffered messages.
+func (r *BufferedConsumer) Flush(ctx context.Context) (_ int, err error) {
 	if r.BufferEmpty() {
 		return 0, nil
 	}
 
+	ctx, span := tracer().Start(
+		ctx,
+		"BufferedConsumer.Flush",
+		trace.WithLinks(messageLinks(ctx, r.buffer)...),
+		trace.WithAttributes(batchAttributes(r.buffer)...),
+	)
+	defer func() { endSpan(span, err) }()
+
+	start := time.Now()
+	defer func() { flushDuration.Observe(time.Since(start).Seconds()) }()
+
 	if err := r.writer.Write(ctx, r.buffer); err != nil {
 		slog.Error("Unable to write data", "error", err)
 		return 0, err
@@ -92,11 +141,33 @@ func (r *BufferedConsumer) Flush(ctx context.Context) (int, error) {
 	}
 
 	numMessages := len(r.buffer)
+	messagesFlushed.Add(float64(numMessages))
 	r.buffer = r.buffer[:0] // Retain capacity.
 	return numMessages, nil
 }
 
+// Running returns whether Process is running, i.e. has been started and has
+// not exited.
+func (r *BufferedConsumer) Running() bool {
+	return r.running.Load()
+}
+
+// CheckRunning is a readiness check, which fails if Process is not running.
+func (r *BufferedConsumer) CheckRunning(context.Context) error {
+	if !r.Running() {
+		return ErrConsumerStopped
+	}
+	return nil
+}
+
+// Process fetches, buffers and flushes messages until `ctx` is cancelled, the
+// reader has no more messages to replay, or an error occurs. Once cancelled or
+// replayed, fetching stops and the buffer is drained, so that buffered messages
+// are written and committed, and nil is returned unless draining fails.
 func (r *BufferedConsumer) Process(ctx context.Context) error {
+	r.running.Store(true)
+	defer r.running.Store(false)
+
 	for {
 		// Fetch and buffer messages until either the buffer is full, or the
 		// flush interval has been met. As the underlying Kafka client blocks
@@ -109,18 +180,28 @@ func (r *BufferedConsumer) Process(ctx context.Context) error {
 
 		for {
 			err := r.Fetch(fetchCtx)
+			if ctx.Err() != nil || errors.Is(err, ErrReplayComplete) {
+				cancel() // Cleanup `fetchCtx`.
+				return r.Drain(ctx)
+			}
+
 			flush := errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrBufferFull)
 
 			if err != nil && !flush {
-				slog.Info("Terminating without flushing", "error", err)
+				slog.Error("Terminating without flushing", "error", err, "n_buffered_messages", len(r.buffer))
 				cancel() // Cleanup `fetchCtx`.
-				panic(err.Error())
+				return err
 			}
 
 			if flush {
 				numFlushed, flushErr := r.Flush(ctx)
 				if flushErr != nil {
 					cancel() // Cleanup `fetchCtx`.
+					// The buffer is retained if the flush was interrupted, so
+					// it is drained instead.
+					if ctx.Err() != nil {
+						return r.Drain(ctx)
+					}
 					return flushErr
 				}
 
@@ -132,3 +213,21 @@ func (r *BufferedConsumer) Process(ctx context.Context) error {
 		cancel() // Cleanup `fetchCtx`.
 	}
 }
+
+// Drain flushes the buffer, without the cancellation of `ctx`, within the
+// shutdown timeout. Messages which are not flushed are left uncommitted, and
+// will be reprocessed.
+func (r *BufferedConsumer) Drain(ctx context.Context) error {
+	drainCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.ShutdownTimeout)
+	defer cancel()
+
+	numBuffered := len(r.buffer)
+	numFlushed, err := r.Flush(drainCtx)
+	if err != nil {
+		slog.Error("Unable to drain buffer", "error", err, "n_unflushed_messages", numBuffered)
+		return errors.Join(ErrDrainFailed, err)
+	}
+
+	slog.Info("Drained buffer", "n_flushed_messages", numFlushed)
+	return nil
+}
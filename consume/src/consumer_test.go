@@ -89,7 +89,7 @@ func TestBufferedConsumerFetch(t *testing.T) {
 
 	mockW := new(mockWriter)
 
-	consumer := NewBufferedConsumer(10, flushInterval, mockR, mockW)
+	consumer := NewBufferedConsumer(10, flushInterval, time.Minute, mockR, mockW)
 
 	err := consumer.Fetch(ctx)
 
@@ -107,7 +107,7 @@ func TestBufferedConsumerFetchWhenErrorFetchingMessage(t *testing.T) {
 
 	mockW := new(mockWriter)
 
-	consumer := NewBufferedConsumer(10, flushInterval, mockR, mockW)
+	consumer := NewBufferedConsumer(10, flushInterval, time.Minute, mockR, mockW)
 
 	err := consumer.Fetch(ctx)
 
@@ -138,10 +138,10 @@ func TestBufferedConsumerFlushWhenBufferEmpty(t *testing.T) {
 	bufferSize := 10
 
 	mockR := new(mockReader)
-	mockR.On("CommitMessages", ctx, mock.Anything).Return(nil)
+	mockR.On("CommitMessages", mock.Anything, mock.Anything).Return(nil)
 
 	mockW := new(mockWriter)
-	mockW.On("Write", ctx, mock.Anything).Return(nil)
+	mockW.On("Write", mock.Anything, mock.Anything).Return(nil)
 
 	consumer := BufferedConsumer{
 		BufferSize: bufferSize,
@@ -159,10 +159,10 @@ func TestBufferedConsumerFlushWhenBufferFull(t *testing.T) {
 	bufferSize := 3
 
 	mockR := new(mockReader)
-	mockR.On("CommitMessages", ctx, mock.Anything).Return(nil)
+	mockR.On("CommitMessages", mock.Anything, mock.Anything).Return(nil)
 
 	mockW := new(mockWriter)
-	mockW.On("Write", ctx, mock.Anything).Return(nil)
+	mockW.On("Write", mock.Anything, mock.Anything).Return(nil)
 
 	buffer := []kafka.Message{{}, {}, {}}
 	consumer := BufferedConsumer{
@@ -177,8 +177,8 @@ func TestBufferedConsumerFlushWhenBufferFull(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, 3, actual)
 
-	mockW.AssertCalled(t, "Write", ctx, buffer)
-	mockR.AssertCalled(t, "CommitMessages", ctx, buffer)
+	mockW.AssertCalled(t, "Write", mock.Anything, buffer)
+	mockR.AssertCalled(t, "CommitMessages", mock.Anything, buffer)
 }
 
 func TestBufferedConsumerFlushWhenBufferPartial(t *testing.T) {
@@ -187,10 +187,10 @@ func TestBufferedConsumerFlushWhenBufferPartial(t *testing.T) {
 	bufferSize := 3
 
 	mockR := new(mockReader)
-	mockR.On("CommitMessages", ctx, mock.Anything).Return(nil)
+	mockR.On("CommitMessages", mock.Anything, mock.Anything).Return(nil)
 
 	mockW := new(mockWriter)
-	mockW.On("Write", ctx, mock.Anything).Return(nil)
+	mockW.On("Write", mock.Anything, mock.Anything).Return(nil)
 
 	unflushedMessage := kafka.Message{Value: []byte("unflushed")}
 	buffer := make([]kafka.Message, 0, bufferSize)
@@ -208,6 +208,95 @@ func TestBufferedConsumerFlushWhenBufferPartial(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, 1, actual)
 
-	mockW.AssertCalled(t, "Write", ctx, []kafka.Message{unflushedMessage})
-	mockR.AssertCalled(t, "CommitMessages", ctx, []kafka.Message{unflushedMessage})
+	mockW.AssertCalled(t, "Write", mock.Anything, []kafka.Message{unflushedMessage})
+	mockR.AssertCalled(t, "CommitMessages", mock.Anything, []kafka.Message{unflushedMessage})
+}
+
+func TestBufferedConsumerProcessWhenFlushFails(t *testing.T) {
+	reader := new(mockReader)
+	reader.On("FetchMessage", mock.Anything).Return(kafka.Message{}, nil)
+
+	writer := new(mockWriter)
+	writer.On("Write", mock.Anything, mock.Anything).Return(fmt.Errorf("Error"))
+
+	consumer := NewBufferedConsumer(1, time.Minute, time.Minute, reader, writer)
+	assert.ErrorIs(t, consumer.CheckRunning(context.Background()), ErrConsumerStopped)
+
+	err := consumer.Process(context.Background())
+
+	assert.NotNil(t, err)
+	assert.False(t, consumer.Running())
+	assert.ErrorIs(t, consumer.CheckRunning(context.Background()), ErrConsumerStopped)
+}
+
+func TestBufferedConsumerProcessDrainsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	msg := kafka.Message{Value: []byte("buffered")}
+
+	reader := new(mockReader)
+	reader.On("FetchMessage", mock.Anything).Return(msg, nil).Once()
+	reader.On("FetchMessage", mock.Anything).Run(func(mock.Arguments) { cancel() }).Return(kafka.Message{}, context.Canceled)
+	reader.On("CommitMessages", mock.Anything, mock.Anything).Return(nil)
+
+	// Writes must not be cancelled along with processing.
+	writer := new(mockWriter)
+	writer.On("Write", mock.MatchedBy(func(ctx context.Context) bool { return ctx.Err() == nil }), mock.Anything).Return(nil)
+
+	consumer := NewBufferedConsumer(10, time.Minute, time.Minute, reader, writer)
+	err := consumer.Process(ctx)
+
+	assert.Nil(t, err)
+	assert.True(t, consumer.BufferEmpty())
+	writer.AssertCalled(t, "Write", mock.Anything, []kafka.Message{msg})
+	reader.AssertCalled(t, "CommitMessages", mock.Anything, []kafka.Message{msg})
+}
+
+func TestBufferedConsumerProcessWhenDrainFails(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	reader := new(mockReader)
+	reader.On("FetchMessage", mock.Anything).Return(kafka.Message{}, nil).Once()
+	reader.On("FetchMessage", mock.Anything).Run(func(mock.Arguments) { cancel() }).Return(kafka.Message{}, context.Canceled)
+
+	writer := new(mockWriter)
+	writer.On("Write", mock.Anything, mock.Anything).Return(context.DeadlineExceeded)
+
+	consumer := NewBufferedConsumer(10, time.Minute, time.Minute, reader, writer)
+	err := consumer.Process(ctx)
+
+	assert.ErrorIs(t, err, ErrDrainFailed)
+	assert.False(t, consumer.BufferEmpty())
+	reader.AssertNotCalled(t, "CommitMessages", mock.Anything, mock.Anything)
+}
+
+func TestBufferedConsumerProcessWhenFetchFails(t *testing.T) {
+	fetchErr := fmt.Errorf("Error")
+
+	reader := new(mockReader)
+	reader.On("FetchMessage", mock.Anything).Return(kafka.Message{}, fetchErr)
+
+	consumer := NewBufferedConsumer(10, time.Minute, time.Minute, reader, new(mockWriter))
+	err := consumer.Process(context.Background())
+
+	assert.ErrorIs(t, err, fetchErr)
+}
+
+func TestBufferedConsumerProcessWhenReplayComplete(t *testing.T) {
+	msg := kafka.Message{Value: []byte("replayed")}
+
+	reader := new(mockReader)
+	reader.On("FetchMessage", mock.Anything).Return(msg, nil).Once()
+	reader.On("FetchMessage", mock.Anything).Return(kafka.Message{}, ErrReplayComplete)
+	reader.On("CommitMessages", mock.Anything, mock.Anything).Return(nil)
+
+	writer := new(mockWriter)
+	writer.On("Write", mock.Anything, mock.Anything).Return(nil)
+
+	consumer := NewBufferedConsumer(10, time.Minute, time.Minute, reader, writer)
+	err := consumer.Process(context.Background())
+
+	assert.Nil(t, err)
+	assert.True(t, consumer.BufferEmpty())
+	writer.AssertCalled(t, "Write", mock.Anything, []kafka.Message{msg})
+	reader.AssertCalled(t, "CommitMessages", mock.Anything, []kafka.Message{msg})
 }
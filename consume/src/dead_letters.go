@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Headers added to dead-lettered messages, giving the reason the message was
+// dropped for, i.e. one of the drop reasons, and the error.
+const (
+	DeadLetterReasonHeader = "dlq_reason"
+	DeadLetterErrorHeader  = "dlq_error"
+)
+
+// DeadLetter is a message which was dropped, rather than written to the data
+// sink.
+type DeadLetter struct {
+	Message kafka.Message
+	Reason  string
+	Err     error
+}
+
+// DeadLetters collects the messages dropped while writing a batch.
+type DeadLetters []DeadLetter
+
+// Add adds a dropped message. It is a DropFunc.
+func (d *DeadLetters) Add(message kafka.Message, reason string, err error) {
+	*d = append(*d, DeadLetter{Message: message, Reason: reason, Err: err})
+}
+
+// MessageWriter provides a method for publishing messages to Kafka.
+type MessageWriter interface {
+	WriteMessages(context.Context, ...kafka.Message) error
+}
+
+// DeadLetterQueue publishes dropped messages to a dead-letter topic, from which
+// they can be inspected and replayed. A nil queue drops messages.
+type DeadLetterQueue struct {
+	writer MessageWriter
+	now    func() time.Time
+}
+
+func NewDeadLetterQueue(writer MessageWriter, now func() time.Time) *DeadLetterQueue {
+	return &DeadLetterQueue{writer: writer, now: now}
+}
+
+// Publish publishes the dead letters, with their original keys, payloads and
+// headers, and headers for why they were dropped. Dead letters are timestamped
+// when published, rather than with the original message's time.
+func (q *DeadLetterQueue) Publish(ctx context.Context, deadLetters DeadLetters) error {
+	if q == nil || len(deadLetters) == 0 {
+		return nil
+	}
+
+	now := q.now()
+	messages := make([]kafka.Message, len(deadLetters))
+	for idx, deadLetter := range deadLetters {
+		messages[idx] = MakeDeadLetterMessage(deadLetter, now)
+	}
+
+	if err := q.writer.WriteMessages(ctx, messages...); err != nil {
+		return err
+	}
+
+	for _, deadLetter := range deadLetters {
+		messagesDeadLettered.WithLabelValues(deadLetter.Reason).Inc()
+	}
+	return nil
+}
+
+// MakeDeadLetterMessage makes the message to publish for the dead letter.
+// Headers of a previous dead-lettering, i.e. of a replayed message, are
+// replaced.
+func MakeDeadLetterMessage(deadLetter DeadLetter, now time.Time) kafka.Message {
+	headers := append(StripDeadLetterHeaders(deadLetter.Message.Headers), kafka.Header{Key: DeadLetterReasonHeader, Value: []byte(deadLetter.Reason)})
+	if deadLetter.Err != nil {
+		headers = append(headers, kafka.Header{Key: DeadLetterErrorHeader, Value: []byte(deadLetter.Err.Error())})
+	}
+
+	return kafka.Message{
+		Key:     deadLetter.Message.Key,
+		Value:   deadLetter.Message.Value,
+		Headers: headers,
+		Time:    now,
+	}
+}
+
+// StripDeadLetterHeaders returns a copy of the headers without those added by
+// dead-lettering.
+func StripDeadLetterHeaders(headers []kafka.Header) []kafka.Header {
+	return slices.DeleteFunc(slices.Clone(headers), func(header kafka.Header) bool {
+		return header.Key == DeadLetterReasonHeader || header.Key == DeadLetterErrorHeader
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockMessageWriter struct {
+	mock.Mock
+}
+
+func (m *mockMessageWriter) WriteMessages(ctx context.Context, messages ...kafka.Message) error {
+	args := m.Called(ctx, messages)
+	return args.Error(0)
+}
+
+func TestMakeDeadLetterMessage(t *testing.T) {
+	now := time.Date(2025, 1, 1, 13, 14, 15, 0, time.UTC)
+	deadLetter := DeadLetter{
+		Message: kafka.Message{
+			Key:   []byte("key"),
+			Value: []byte("abc"),
+			Headers: []kafka.Header{
+				{Key: SchemaNameHeader, Value: []byte("Unknown")},
+				// Headers of a previous dead-lettering are replaced.
+				{Key: DeadLetterReasonHeader, Value: []byte(dropReasonDecodeFailure)},
+				{Key: DeadLetterErrorHeader, Value: []byte("previous")},
+			},
+			Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		Reason: dropReasonUnrecognizedSchema,
+		Err:    ErrUnrecognizedSchema,
+	}
+
+	expected := kafka.Message{
+		Key:   []byte("key"),
+		Value: []byte("abc"),
+		Headers: []kafka.Header{
+			{Key: SchemaNameHeader, Value: []byte("Unknown")},
+			{Key: DeadLetterReasonHeader, Value: []byte(dropReasonUnrecognizedSchema)},
+			{Key: DeadLetterErrorHeader, Value: []byte(ErrUnrecognizedSchema.Error())},
+		},
+		Time: now,
+	}
+
+	actual := MakeDeadLetterMessage(deadLetter, now)
+	assert.Equal(t, expected, actual)
+	// The original message's headers are not modified.
+	assert.Len(t, deadLetter.Message.Headers, 3)
+}
+
+func TestDeadLetterQueuePublish(t *testing.T) {
+	now := time.Date(2025, 1, 1, 13, 14, 15, 0, time.UTC)
+	deadLetters := DeadLetters{
+		{Message: kafka.Message{Value: []byte("abc")}, Reason: dropReasonMissingHeader, Err: ErrNoSchemaNameHeader},
+		{Message: kafka.Message{Value: []byte("def")}, Reason: dropReasonMissingHeader, Err: ErrNoSchemaNameHeader},
+	}
+
+	writer := new(mockMessageWriter)
+	writer.On("WriteMessages", mock.Anything, mock.Anything).Return(nil)
+
+	counter := messagesDeadLettered.WithLabelValues(dropReasonMissingHeader)
+	before := testutil.ToFloat64(counter)
+
+	dlq := NewDeadLetterQueue(writer, func() time.Time { return now })
+	err := dlq.Publish(context.Background(), deadLetters)
+
+	assert.Nil(t, err)
+	writer.AssertCalled(t, "WriteMessages", mock.Anything, []kafka.Message{
+		MakeDeadLetterMessage(deadLetters[0], now),
+		MakeDeadLetterMessage(deadLetters[1], now),
+	})
+	assert.Equal(t, before+2, testutil.ToFloat64(counter))
+}
+
+func TestDeadLetterQueuePublishWhenWriteFails(t *testing.T) {
+	writeErr := errors.New("write failed")
+	deadLetters := DeadLetters{
+		{Message: kafka.Message{Value: []byte("abc")}, Reason: dropReasonDecodeFailure},
+	}
+
+	writer := new(mockMessageWriter)
+	writer.On("WriteMessages", mock.Anything, mock.Anything).Return(writeErr)
+
+	counter := messagesDeadLettered.WithLabelValues(dropReasonDecodeFailure)
+	before := testutil.ToFloat64(counter)
+
+	dlq := NewDeadLetterQueue(writer, time.Now)
+	err := dlq.Publish(context.Background(), deadLetters)
+
+	assert.ErrorIs(t, err, writeErr)
+	assert.Equal(t, before, testutil.ToFloat64(counter))
+}
+
+func TestDeadLetterQueuePublishWhenNil(t *testing.T) {
+	var dlq *DeadLetterQueue
+	err := dlq.Publish(context.Background(), DeadLetters{{Reason: dropReasonDecodeFailure}})
+	assert.Nil(t, err)
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"iter"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/hamba/avro/v2"
@@ -19,6 +23,15 @@ const (
 	SchemaNameTrafficCrash   = "traffic_crash"
 )
 
+// Incident types, matching those used by the warehouse's `incidents` view.
+const (
+	IncidentType311Case        = "311_case"
+	IncidentTypeFireEMSCall    = "fire_ems_call"
+	IncidentTypeFireIncident   = "fire_incident"
+	IncidentTypePoliceIncident = "police_incident"
+	IncidentTypeTrafficCrash   = "traffic_crash"
+)
+
 // GetSchemaName extracts the name of message's schema and returns it if found.
 func GetSchemaName(headers []kafkaProtocol.Header, schemaNameHeader string) (string, error) {
 	for _, header := range headers {
@@ -36,6 +49,8 @@ type ProcessableRecord interface {
 	Schema() avro.Schema
 	// SchemaName returns the name of the record's Avro schema.
 	SchemaName() string
+	// IncidentType returns the type of incident the record describes.
+	IncidentType() string
 	// Unmarshal decodes the message into the receiver.
 	Unmarshal([]byte) error
 	// Coordinates returns the coordinates of where the incident occurred.
@@ -78,30 +93,214 @@ func DecodeMessage(data []byte, schemaName string) (ProcessableRecord, error) {
 	return record, err
 }
 
-// DecodeMessages decodes Kafka messages, using each message's headers to determine
-// how to decode the message. Messages which cannot be decoded will be dropped.
-func DecodeMessages(schemaNameHeader string, messages []kafka.Message) iter.Seq[ProcessableRecord] {
-	return func(yield func(ProcessableRecord) bool) {
+// DecodeResolvedMessage decodes a payload written with a writer schema, and
+// returns a populated record of the reader schema it was resolved against.
+func DecodeResolvedMessage(data []byte, schema ResolvedSchema) (ProcessableRecord, error) {
+	record, err := NewRecord(schema.SchemaName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = avro.Unmarshal(schema.Schema, data, record)
+	return record, err
+}
+
+// DropFunc is called with each message which is dropped, with the reason and
+// error it was dropped for.
+type DropFunc func(message kafka.Message, reason string, err error)
+
+// Decoder decodes Kafka messages into records. Messages with a schema name
+// header are bare Avro records, written with the named reader schema. Messages
+// without one are in the Confluent wire format, if a schema registry is
+// configured, and are decoded by resolving the registered writer schema
+// against the reader schema with the same name. This allows producers to evolve
+// schemas compatibly, without the reader schemas being regenerated.
+type Decoder struct {
+	schemaNameHeader string
+	registry         SchemaRegistry
+	compatibility    *avro.SchemaCompatibility
+
+	mu sync.Mutex
+	// Resolved schemas, by writer schema ID. Registered schemas are immutable,
+	// so are cached indefinitely.
+	resolved map[int]ResolvedSchema
+}
+
+// ResolvedSchema is a writer schema resolved against a reader schema.
+type ResolvedSchema struct {
+	// Name of the reader schema.
+	SchemaName string
+	// Schema for decoding records written with the writer schema as records
+	// of the reader schema.
+	Schema avro.Schema
+}
+
+// NewDecoder creates a decoder. Messages are only decoded from the wire format
+// if `registry` is not nil.
+func NewDecoder(schemaNameHeader string, registry SchemaRegistry) *Decoder {
+	return &Decoder{
+		schemaNameHeader: schemaNameHeader,
+		registry:         registry,
+		compatibility:    avro.NewSchemaCompatibility(),
+		resolved:         make(map[int]ResolvedSchema),
+	}
+}
+
+// ResolveSchema gets the writer schema with the given ID from the registry and
+// resolves it against the reader schema with the same name.
+func (d *Decoder) ResolveSchema(ctx context.Context, schemaID int) (ResolvedSchema, error) {
+	d.mu.Lock()
+	resolved, ok := d.resolved[schemaID]
+	d.mu.Unlock()
+	if ok {
+		return resolved, nil
+	}
+
+	writerSchema, err := d.registry.GetSchema(ctx, schemaID)
+	if err != nil {
+		return resolved, err
+	}
+
+	namedSchema, ok := writerSchema.(avro.NamedSchema)
+	if !ok {
+		return resolved, ErrUnrecognizedSchema
+	}
+	record, err := NewRecord(namedSchema.Name())
+	if err != nil {
+		return resolved, err
+	}
+
+	schema, err := d.compatibility.Resolve(record.Schema(), writerSchema)
+	if err != nil {
+		return resolved, fmt.Errorf("%w: %w", ErrIncompatibleSchema, err)
+	}
+
+	resolved = ResolvedSchema{SchemaName: namedSchema.Name(), Schema: schema}
+	d.mu.Lock()
+	d.resolved[schemaID] = resolved
+	d.mu.Unlock()
+	return resolved, nil
+}
+
+// resolveSchemas resolves the writer schemas of messages in the wire format,
+// and returns the resolved schemas, or why they could not be resolved, by ID.
+// Errors which aren't due to the schema itself, e.g. the registry being
+// unavailable, are returned, so that the messages are not dropped.
+func (d *Decoder) resolveSchemas(ctx context.Context, messages []kafka.Message) (map[int]ResolvedSchema, map[int]error, error) {
+	resolved := make(map[int]ResolvedSchema)
+	unresolved := make(map[int]error)
+	if d.registry == nil {
+		return resolved, unresolved, nil
+	}
+
+	for _, message := range messages {
+		if _, err := GetSchemaName(message.Headers, d.schemaNameHeader); err == nil {
+			continue
+		}
+		schemaID, _, err := ParseWireFormat(message.Value)
+		if err != nil {
+			continue
+		}
+		if _, ok := resolved[schemaID]; ok {
+			continue
+		}
+		if _, ok := unresolved[schemaID]; ok {
+			continue
+		}
+
+		schema, err := d.ResolveSchema(ctx, schemaID)
+		switch {
+		case err == nil:
+			resolved[schemaID] = schema
+		case errors.Is(err, ErrSchemaNotFound), errors.Is(err, ErrUnsupportedSchemaType), errors.Is(err, ErrUnrecognizedSchema), errors.Is(err, ErrIncompatibleSchema):
+			unresolved[schemaID] = err
+		default:
+			return nil, nil, err
+		}
+	}
+
+	return resolved, unresolved, nil
+}
+
+// DecodeMessages decodes Kafka messages, and yields each message with its
+// record. Messages which cannot be decoded are dropped, and passed to `drop` if
+// not nil. An error is returned if the writer schemas of messages in the wire
+// format cannot be fetched.
+func (d *Decoder) DecodeMessages(ctx context.Context, messages []kafka.Message, drop DropFunc) (iter.Seq2[kafka.Message, ProcessableRecord], error) {
+	if drop == nil {
+		drop = func(kafka.Message, string, error) {}
+	}
+
+	resolved, unresolved, err := d.resolveSchemas(ctx, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(yield func(kafka.Message, ProcessableRecord) bool) {
 		for _, message := range messages {
-			schemaName, err := GetSchemaName(message.Headers, schemaNameHeader)
-			if err != nil {
+			var record ProcessableRecord
+
+			schemaName, err := GetSchemaName(message.Headers, d.schemaNameHeader)
+			if err != nil && d.registry == nil {
 				slog.Error(
 					"Unable to get schema name from message headers, dropping message",
 					"headers", message.Headers,
-					"schema_name_header", schemaNameHeader,
+					"schema_name_header", d.schemaNameHeader,
 				)
+				messagesDropped.WithLabelValues(dropReasonMissingHeader).Inc()
+				drop(message, dropReasonMissingHeader, err)
 				continue
 			}
 
-			record, err := DecodeMessage(message.Value, schemaName)
+			if err == nil {
+				record, err = DecodeMessage(message.Value, schemaName)
+			} else {
+				var schemaID int
+				var payload []byte
+				schemaID, payload, err = ParseWireFormat(message.Value)
+				if err != nil {
+					slog.Error(
+						"Message has no schema name header and is not in the wire format, dropping message",
+						"headers", message.Headers,
+						"schema_name_header", d.schemaNameHeader,
+					)
+					messagesDropped.WithLabelValues(dropReasonInvalidWireFormat).Inc()
+					drop(message, dropReasonInvalidWireFormat, err)
+					continue
+				}
+
+				if err, ok := unresolved[schemaID]; ok {
+					reason := dropReasonUnrecognizedSchema
+					if errors.Is(err, ErrIncompatibleSchema) {
+						reason = dropReasonIncompatibleSchema
+					}
+					slog.Error("Unable to resolve schema, dropping message", "schema_id", schemaID, "error", err)
+					messagesDropped.WithLabelValues(reason).Inc()
+					drop(message, reason, err)
+					continue
+				}
+
+				schema := resolved[schemaID]
+				schemaName = schema.SchemaName
+				record, err = DecodeResolvedMessage(payload, schema)
+			}
+
+			if errors.Is(err, ErrUnrecognizedSchema) {
+				slog.Error("Unrecognized schema, dropping message", "schema_name", schemaName)
+				messagesDropped.WithLabelValues(dropReasonUnrecognizedSchema).Inc()
+				drop(message, dropReasonUnrecognizedSchema, err)
+				continue
+			}
 			if err != nil {
 				slog.Error("Unable to decode message, dropping message", "schema_name", schemaName)
+				messagesDropped.WithLabelValues(dropReasonDecodeFailure).Inc()
+				drop(message, dropReasonDecodeFailure, err)
 				continue
 			}
 
-			if !yield(record) {
+			if !yield(message, record) {
 				return
 			}
 		}
-	}
+	}, nil
 }
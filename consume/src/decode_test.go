@@ -1,11 +1,23 @@
 package main
 
 import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hamba/avro/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/segmentio/kafka-go"
 	kafkaProtocol "github.com/segmentio/kafka-go/protocol"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestGetSchemaName(t *testing.T) {
@@ -121,11 +133,146 @@ func TestDecodeMessages(t *testing.T) {
 	}
 
 	actual := make([]*A311Case, 0)
-	for r := range DecodeMessages(SchemaNameHeader, messages) {
+	missingHeader := messagesDropped.WithLabelValues(dropReasonMissingHeader)
+	unrecognizedSchema := messagesDropped.WithLabelValues(dropReasonUnrecognizedSchema)
+	missingHeaderBefore := testutil.ToFloat64(missingHeader)
+	unrecognizedSchemaBefore := testutil.ToFloat64(unrecognizedSchema)
+
+	var deadLetters DeadLetters
+	decoder := NewDecoder(SchemaNameHeader, nil)
+	records, err := decoder.DecodeMessages(context.Background(), messages, deadLetters.Add)
+	assert.Nil(t, err)
+	for _, r := range records {
 		actual = append(actual, r.(*A311Case))
 	}
 
 	assert.Equal(t, 2, len(actual))
+	if assert.Len(t, deadLetters, 2) {
+		assert.Equal(t, messages[1], deadLetters[0].Message)
+		assert.Equal(t, dropReasonMissingHeader, deadLetters[0].Reason)
+		assert.ErrorIs(t, deadLetters[0].Err, ErrNoSchemaNameHeader)
+		assert.Equal(t, messages[2], deadLetters[1].Message)
+		assert.Equal(t, dropReasonUnrecognizedSchema, deadLetters[1].Reason)
+	}
+	assert.Equal(t, missingHeaderBefore+1, testutil.ToFloat64(missingHeader))
+	assert.Equal(t, unrecognizedSchemaBefore+1, testutil.ToFloat64(unrecognizedSchema))
 	assert.EqualValues(t, record, actual[0])
 	assert.EqualValues(t, record, actual[1])
 }
+
+type mockRegistry struct {
+	mock.Mock
+}
+
+func (m *mockRegistry) GetSchema(ctx context.Context, schemaID int) (avro.Schema, error) {
+	args := m.Called(ctx, schemaID)
+	schema, _ := args.Get(0).(avro.Schema)
+	return schema, args.Error(1)
+}
+
+// evolveSchema returns the schema with a field added, as written by a producer
+// which has evolved the schema.
+func evolveSchema(t *testing.T, schema avro.Schema, field map[string]any) string {
+	var definition map[string]any
+	if err := json.Unmarshal([]byte(schema.String()), &definition); err != nil {
+		t.Fatal(err)
+	}
+	definition["fields"] = append(definition["fields"].([]any), field)
+
+	data, err := json.Marshal(definition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func makeWireFormat(schemaID uint32, payload []byte) []byte {
+	return append(binary.BigEndian.AppendUint32([]byte{wireFormatMagicByte}, schemaID), payload...)
+}
+
+func TestDecoderDecodeMessagesWireFormat(t *testing.T) {
+	record := &A311Case{
+		ServiceRequestID:  100,
+		RequestedDatetime: time.Date(2025, 1, 1, 13, 14, 15, 0, time.UTC),
+		Address:           "123 Front Street",
+	}
+	data, _ := record.Marshal()
+	// Records are encoded as their fields in order, so a field added to the end
+	// of the schema is encoded after the reader's fields.
+	evolvedData := append(slices.Clone(data), 0x06, 'n', 'e', 'w')
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "1.avsc"), []byte(schemaA311Case.String()), 0o644)
+	os.WriteFile(filepath.Join(dir, "2.avsc"), []byte(evolveSchema(t, schemaA311Case, map[string]any{"name": "new_field", "type": "string"})), 0o644)
+	// Changing the type of a field is incompatible.
+	os.WriteFile(filepath.Join(dir, "3.avsc"), []byte(strings.Replace(schemaA311Case.String(), `"name":"service_request_id","type":"int"`, `"name":"service_request_id","type":"boolean"`, 1)), 0o644)
+
+	messages := []kafka.Message{
+		{Value: makeWireFormat(1, data)},
+		{Value: makeWireFormat(2, evolvedData)},
+		// Message with a schema name header is decoded with the named schema.
+		{Headers: []kafka.Header{{Key: SchemaNameHeader, Value: []byte(SchemaName311Case)}}, Value: data},
+		// Message with an unregistered schema. Should be dropped.
+		{Value: makeWireFormat(4, data)},
+		// Message with an incompatible schema. Should be dropped.
+		{Value: makeWireFormat(3, data)},
+		// Message without a schema name header, not in the wire format. Should be dropped.
+		{Value: data},
+	}
+
+	var deadLetters DeadLetters
+	decoder := NewDecoder(SchemaNameHeader, NewFileSchemaRegistry(dir))
+	records, err := decoder.DecodeMessages(context.Background(), messages, deadLetters.Add)
+	assert.Nil(t, err)
+
+	actual := make([]ProcessableRecord, 0)
+	for _, r := range records {
+		actual = append(actual, r)
+	}
+
+	assert.Equal(t, []ProcessableRecord{record, record, record}, actual)
+	if assert.Len(t, deadLetters, 3) {
+		assert.Equal(t, dropReasonUnrecognizedSchema, deadLetters[0].Reason)
+		assert.ErrorIs(t, deadLetters[0].Err, ErrSchemaNotFound)
+		assert.Equal(t, dropReasonIncompatibleSchema, deadLetters[1].Reason)
+		assert.ErrorIs(t, deadLetters[1].Err, ErrIncompatibleSchema)
+		assert.Equal(t, dropReasonInvalidWireFormat, deadLetters[2].Reason)
+		assert.ErrorIs(t, deadLetters[2].Err, ErrInvalidWireFormat)
+	}
+}
+
+func TestDecoderDecodeMessagesCachesSchemas(t *testing.T) {
+	record := &A311Case{ServiceRequestID: 100}
+	data, _ := record.Marshal()
+	messages := []kafka.Message{{Value: makeWireFormat(1, data)}, {Value: makeWireFormat(1, data)}}
+
+	registry := new(mockRegistry)
+	registry.On("GetSchema", mock.Anything, 1).Return(schemaA311Case, nil)
+
+	decoder := NewDecoder(SchemaNameHeader, registry)
+	for range 2 {
+		records, err := decoder.DecodeMessages(context.Background(), messages, nil)
+		assert.Nil(t, err)
+		for _, r := range records {
+			assert.EqualValues(t, record, r)
+		}
+	}
+
+	registry.AssertNumberOfCalls(t, "GetSchema", 1)
+}
+
+func TestDecoderDecodeMessagesWhenRegistryUnavailable(t *testing.T) {
+	registryErr := errors.New("registry unavailable")
+	messages := []kafka.Message{{Value: makeWireFormat(1, []byte{})}}
+
+	registry := new(mockRegistry)
+	registry.On("GetSchema", mock.Anything, 1).Return(nil, registryErr)
+
+	var deadLetters DeadLetters
+	decoder := NewDecoder(SchemaNameHeader, registry)
+	_, err := decoder.DecodeMessages(context.Background(), messages, deadLetters.Add)
+
+	// Messages are not dropped, so that they are retried.
+	assert.ErrorIs(t, err, registryErr)
+	assert.Empty(t, deadLetters)
+}
@@ -3,7 +3,17 @@ package main
 import "errors"
 
 var (
-	ErrNoSchemaNameHeader = errors.New("Unable to get schema name")
-	ErrUnrecognizedSchema = errors.New("Unrecognized schema")
-	ErrBufferFull         = errors.New("Buffer is full")
+	ErrNoSchemaNameHeader       = errors.New("Unable to get schema name")
+	ErrUnrecognizedSchema       = errors.New("Unrecognized schema")
+	ErrBufferFull               = errors.New("Buffer is full")
+	ErrConsumerStopped          = errors.New("Consumer is not processing messages")
+	ErrInvalidTracesExporter    = errors.New("Invalid traces exporter")
+	ErrNoCoordinates            = errors.New("Record has no coordinates")
+	ErrReplayComplete           = errors.New("Dead letters have been replayed")
+	ErrDrainFailed              = errors.New("Unable to flush buffered messages before shutting down")
+	ErrInvalidWireFormat        = errors.New("Message is not in the schema registry wire format")
+	ErrSchemaNotFound           = errors.New("Schema not found in registry")
+	ErrUnsupportedSchemaType    = errors.New("Unsupported schema type")
+	ErrInvalidSchemaRegistryURL = errors.New("Invalid schema registry url")
+	ErrIncompatibleSchema       = errors.New("Schema is incompatible with the reader schema")
 )
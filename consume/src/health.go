@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Each readiness check must complete within this time.
+const readinessCheckTimeout = 2 * time.Second
+
+// HealthCheck checks that a dependency is available.
+type HealthCheck func(context.Context) error
+
+// ReadinessResponse reports the result of each readiness check, which is "ok"
+// or the check's error.
+type ReadinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// HandleLiveness responds that the consumer is running.
+func HandleLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// MakeReadinessHandler makes a handler which runs the checks concurrently,
+// responding with a 503 if any fail.
+func MakeReadinessHandler(checks map[string]HealthCheck) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+		defer cancel()
+
+		response := ReadinessResponse{Status: "ok", Checks: make(map[string]string, len(checks))}
+		status := http.StatusOK
+
+		var (
+			mu sync.Mutex
+			wg sync.WaitGroup
+		)
+		for name, check := range checks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := check(ctx)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					slog.Error("Readiness check failed", "check", name, "error", err)
+					response.Checks[name] = err.Error()
+					response.Status = "unavailable"
+					status = http.StatusServiceUnavailable
+					return
+				}
+				response.Checks[name] = "ok"
+			}()
+		}
+		wg.Wait()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			slog.Error("Unable to write readiness response", "error", err)
+		}
+	}
+}
+
+// KafkaCheck returns a readiness check which connects to the broker and reads
+// the topic's partitions.
+func KafkaCheck(brokerURL, topic string) HealthCheck {
+	return func(ctx context.Context) error {
+		conn, err := kafka.DialContext(ctx, "tcp", brokerURL)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		_, err = conn.ReadPartitions(topic)
+		return err
+	}
+}
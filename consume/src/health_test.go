@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleLiveness(t *testing.T) {
+	w := httptest.NewRecorder()
+	HandleLiveness(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status": "ok"}`, w.Body.String())
+}
+
+func TestReadinessHandler(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	failed := func(context.Context) error { return errors.New("connection refused") }
+
+	type testCase struct {
+		Name           string
+		Checks         map[string]HealthCheck
+		ExpectedStatus int
+		Expected       ReadinessResponse
+	}
+
+	testCases := []testCase{
+		{
+			Name:           "Ready",
+			Checks:         map[string]HealthCheck{"kafka": ok, "consumer": ok},
+			ExpectedStatus: http.StatusOK,
+			Expected:       ReadinessResponse{Status: "ok", Checks: map[string]string{"kafka": "ok", "consumer": "ok"}},
+		},
+		{
+			Name:           "Dependency unavailable",
+			Checks:         map[string]HealthCheck{"kafka": ok, "consumer": failed},
+			ExpectedStatus: http.StatusServiceUnavailable,
+			Expected:       ReadinessResponse{Status: "unavailable", Checks: map[string]string{"kafka": "ok", "consumer": "connection refused"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			MakeReadinessHandler(tc.Checks)(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			assert.Equal(t, tc.ExpectedStatus, w.Code)
+
+			var actual ReadinessResponse
+			require.Nil(t, json.NewDecoder(w.Body).Decode(&actual))
+			assert.Equal(t, tc.Expected, actual)
+		})
+	}
+}
@@ -2,78 +2,203 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+	_ "time/tzdata" // Embed timezone data for the source timezone.
 
 	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/segmentio/kafka-go"
 )
 
+// Exit codes.
+const (
+	// Stopped by a signal, after flushing buffered messages.
+	ExitOK = 0
+	// Unable to start, or stopped processing messages due to an error.
+	ExitFailure = 1
+	// Stopped by a signal, but buffered messages could not be flushed within
+	// the shutdown timeout, and will be reprocessed.
+	ExitDrainFailure = 2
+)
+
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
 	config, ok := NewConfig()
 	if !ok {
 		slog.Error("Unable to read config from environment")
-		os.Exit(1)
+		return ExitFailure
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	shutdownTracing, err := InitTracing(ctx, "consumer-"+config.ConsumerType, config.TracesExporter, config.TracesFile)
+	if err != nil {
+		slog.Error("Unable to configure tracing", "error", err)
+		return ExitFailure
+	}
+	defer shutdownTracing(context.Background())
+
+	// Dead letters are replayed under their own consumer group, so that the
+	// topic's offsets are unaffected.
+	topic, groupID := config.Topic, config.ConsumerGroupID
+	if config.ConsumerMode == ReplayMode {
+		if config.DeadLetterTopic == "" {
+			slog.Error("Unable to replay without a dead-letter topic")
+			return ExitFailure
+		}
+		topic, groupID = config.DeadLetterTopic, config.ConsumerGroupID+"-replay"
+	} else if config.ConsumerMode != ConsumeMode {
+		slog.Error("Unknown consumer mode", "consumer_mode", config.ConsumerMode)
+		return ExitFailure
+	}
+
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:  []string{config.BrokerURL},
-		Topic:    config.Topic,
-		GroupID:  config.ConsumerGroupID,
+		Topic:    topic,
+		GroupID:  groupID,
 		MaxBytes: 10e6, // 10MB
 	})
-	defer reader.Close()
+	// Closing the reader leaves the consumer group, so that partitions are
+	// rebalanced without waiting for the session to time out.
+	defer func() {
+		if err := reader.Close(); err != nil {
+			slog.Error("Unable to close reader", "error", err)
+		}
+	}()
+
+	// Stats resets the reader's counters, but they are otherwise unused.
+	prometheus.MustRegister(NewKafkaLagGauge(func() int64 { return reader.Stats().Lag }))
+
+	var readable Readable = reader
+	if config.ConsumerMode == ReplayMode {
+		partitions, err := ReadPartitionCount(ctx, config.BrokerURL, topic)
+		if err != nil {
+			slog.Error("Unable to read dead-letter topic partitions", "error", err)
+			return ExitFailure
+		}
+		readable = NewReplayReader(reader, time.Now(), partitions)
+	}
 
-	bucketer := NewBucketer(config.BucketTimePrecision, config.BucketGeohashPrecision)
+	var dlq *DeadLetterQueue
+	if config.DeadLetterTopic != "" {
+		dlqWriter := &kafka.Writer{
+			Addr:                   kafka.TCP(config.BrokerURL),
+			Topic:                  config.DeadLetterTopic,
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			AllowAutoTopicCreation: true,
+		}
+		defer dlqWriter.Close()
+		dlq = NewDeadLetterQueue(dlqWriter, time.Now)
+	}
+
+	registry, err := NewSchemaRegistry(config.SchemaRegistryURL, config.HttpRequestTimeout, config.HttpRequestRetries, config.HttpRequestBackoff)
+	if err != nil {
+		slog.Error("Unable to configure schema registry", "error", err)
+		return ExitFailure
+	}
+	decoder := NewDecoder(SchemaNameHeader, registry)
+
+	bucketer := NewBucketer(config.BucketTimePrecision, config.BucketGeohashPrecision, config.SourceLocation)
 
 	var writer Writable
+	checks := map[string]HealthCheck{
+		"kafka": KafkaCheck(config.BrokerURL, topic),
+	}
 
 	if config.ConsumerType == RawConsumerType {
 		warehouseConnOptions, err := clickhouse.ParseDSN(config.WarehouseURL)
 		if err != nil {
 			slog.Error("Unable to parse warehouse url", "error", err)
-			os.Exit(1)
+			return ExitFailure
 		}
 		conn, err := clickhouse.Open(warehouseConnOptions)
 		if err != nil {
 			slog.Error("Unable to connect to warehouse", "error", err)
-			os.Exit(1)
+			return ExitFailure
 		}
 		defer conn.Close()
-		writer = NewRawWriter(conn, bucketer)
+		writer = NewRawWriter(conn, decoder, bucketer, dlq)
+		checks["clickhouse"] = conn.Ping
 	} else if config.ConsumerType == AggregateConsumerType {
 		client := NewAggregatesServiceClient(
 			config.AppURL,
+			config.AppCredentials,
 			config.HttpRequestTimeout,
 			config.HttpRequestRetries,
 			config.HttpRequestBackoff,
 		)
-		writer = NewAggregateWriter(client, bucketer)
+		writer = NewAggregateWriter(client, decoder, bucketer, dlq)
 	} else {
 		slog.Error("Unknown consumer type", "consumer_type", config.ConsumerType)
-		os.Exit(1)
+		return ExitFailure
 	}
 	if writer == nil {
 		slog.Error("Error initiating writer")
-		os.Exit(1)
+		return ExitFailure
 	}
 
-	consumer := NewBufferedConsumer(config.BufferSize, config.FlushInterval, reader, writer)
+	consumer := NewBufferedConsumer(config.BufferSize, config.FlushInterval, config.ShutdownTimeout, readable, writer)
 	if consumer == nil {
 		slog.Error("Error initiating consumer")
-		os.Exit(1)
+		return ExitFailure
 	}
 
+	checks["consumer"] = consumer.CheckRunning
+
+	http.HandleFunc("GET /healthz", HandleLiveness)
+	http.HandleFunc("GET /readyz", MakeReadinessHandler(checks))
+	http.Handle("GET /metrics", promhttp.Handler())
+	go func() {
+		if err := http.ListenAndServe(fmt.Sprintf(":%s", config.Port), nil); err != nil {
+			slog.Error("Unable to serve health checks and metrics", "error", err)
+		}
+	}()
+
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 
-	go consumer.Process(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.Process(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		// Processing only stops without error once dead letters are replayed.
+		if err == nil {
+			slog.Info("Replayed dead letters")
+			return ExitOK
+		}
+		slog.Error("Stopped processing messages", "error", err)
+		return ExitFailure
+	case sig := <-signalChan:
+		slog.Info("Shutting down...", "signal", sig.String())
+	}
+
+	// Stop fetching, and wait for buffered messages to be flushed and
+	// committed, which is bounded by the shutdown timeout.
+	cancel()
+	if err := <-done; err != nil {
+		if errors.Is(err, ErrDrainFailed) {
+			return ExitDrainFailure
+		}
+		slog.Error("Stopped processing messages", "error", err)
+		return ExitFailure
+	}
 
-	<-signalChan
-	slog.Info("Shutting down...")
+	slog.Info("Shut down")
+	return ExitOK
 }
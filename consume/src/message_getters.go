@@ -11,6 +11,10 @@ func (r *A311Case) SchemaName() string {
 	return SchemaName311Case
 }
 
+func (r *A311Case) IncidentType() string {
+	return IncidentType311Case
+}
+
 func (r *A311Case) Coordinates() *Coordinates {
 	return &Coordinates{Longitude: r.Long, Latitude: r.Lat}
 }
@@ -23,6 +27,10 @@ func (r *FireEmsCall) SchemaName() string {
 	return SchemaNameFireEMSCall
 }
 
+func (r *FireEmsCall) IncidentType() string {
+	return IncidentTypeFireEMSCall
+}
+
 func (r *FireEmsCall) Coordinates() *Coordinates {
 	return &Coordinates{Longitude: r.Long, Latitude: r.Lat}
 }
@@ -35,6 +43,10 @@ func (r *FireIncident) SchemaName() string {
 	return SchemaNameFireIncident
 }
 
+func (r *FireIncident) IncidentType() string {
+	return IncidentTypeFireIncident
+}
+
 func (r *FireIncident) Coordinates() *Coordinates {
 	return &Coordinates{Longitude: r.Long, Latitude: r.Lat}
 }
@@ -47,6 +59,10 @@ func (r *PoliceIncident) SchemaName() string {
 	return SchemaNamePoliceIncident
 }
 
+func (r *PoliceIncident) IncidentType() string {
+	return IncidentTypePoliceIncident
+}
+
 func (r *PoliceIncident) Coordinates() *Coordinates {
 	if r.Longitude == nil || r.Latitude == nil {
 		return nil
@@ -62,6 +78,10 @@ func (r *TrafficCrash) SchemaName() string {
 	return SchemaNameTrafficCrash
 }
 
+func (r *TrafficCrash) IncidentType() string {
+	return IncidentTypeTrafficCrash
+}
+
 func (r *TrafficCrash) Coordinates() *Coordinates {
 	if r.Long == nil || r.Lat == nil {
 		return nil
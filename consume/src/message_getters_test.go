@@ -21,6 +21,7 @@ func TestA311Case(t *testing.T) {
 	}
 
 	assert.Equal(t, "a311_case", record.SchemaName())
+	assert.Equal(t, "311_case", record.IncidentType())
 
 	coords := record.Coordinates()
 	assert.NotNil(t, coords)
@@ -39,6 +40,7 @@ func TestFireEmsCall(t *testing.T) {
 	}
 
 	assert.Equal(t, "fire_ems_call", record.SchemaName())
+	assert.Equal(t, "fire_ems_call", record.IncidentType())
 
 	coords := record.Coordinates()
 	assert.NotNil(t, coords)
@@ -57,6 +59,7 @@ func TestFireIncident(t *testing.T) {
 	}
 
 	assert.Equal(t, "fire_incident", record.SchemaName())
+	assert.Equal(t, "fire_incident", record.IncidentType())
 
 	coords := record.Coordinates()
 	assert.NotNil(t, coords)
@@ -74,6 +77,7 @@ func TestPoliceIncident(t *testing.T) {
 	}
 
 	assert.Equal(t, "police_incident", record.SchemaName())
+	assert.Equal(t, "police_incident", record.IncidentType())
 
 	coords := record.Coordinates()
 	assert.NotNil(t, coords)
@@ -91,6 +95,7 @@ func TestTrafficCrash(t *testing.T) {
 	}
 
 	assert.Equal(t, "traffic_crash", record.SchemaName())
+	assert.Equal(t, "traffic_crash", record.IncidentType())
 
 	coords := record.Coordinates()
 	assert.NotNil(t, coords)
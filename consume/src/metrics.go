@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Reasons for which messages are dropped.
+const (
+	dropReasonMissingHeader      = "missing_header"
+	dropReasonUnrecognizedSchema = "unrecognized_schema"
+	dropReasonDecodeFailure      = "decode_failure"
+	dropReasonNoCoordinates      = "no_coordinates"
+	dropReasonInvalidWireFormat  = "invalid_wire_format"
+	dropReasonIncompatibleSchema = "incompatible_schema"
+)
+
+var (
+	messagesFetched = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consumer_messages_fetched_total",
+		Help: "Messages fetched from Kafka.",
+	})
+	messagesFlushed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consumer_messages_flushed_total",
+		Help: "Messages written to the data sink and committed.",
+	})
+	messagesDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "consumer_messages_dropped_total",
+			Help: "Messages dropped, rather than written to the data sink, by reason.",
+		},
+		[]string{"reason"},
+	)
+	messagesDeadLettered = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "consumer_messages_dead_lettered_total",
+			Help: "Dropped messages published to the dead-letter topic, by reason.",
+		},
+		[]string{"reason"},
+	)
+	flushDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "consumer_flush_duration_seconds",
+		Help:    "Time taken to flush the buffer.",
+		Buckets: prometheus.DefBuckets,
+	})
+	dispatchRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "aggregates_client_retries_total",
+		Help: "Requests to the aggregates service which were retried.",
+	})
+	dispatchFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "aggregates_client_failures_total",
+		Help: "Requests to the aggregates service which failed.",
+	})
+)
+
+// NewKafkaLagGauge returns a gauge of the reader's lag, as reported by `lag`.
+func NewKafkaLagGauge(lag func() int64) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "consumer_kafka_lag",
+			Help: "Messages between the last fetched message and the end of its partition.",
+		},
+		func() float64 { return float64(lag()) },
+	)
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/segmentio/kafka-go"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func mapNullableUInt8(v *int) *uint8 {
@@ -267,17 +269,35 @@ type BatchPreparer interface {
 // RawWriter writes data directly (i.e. as it is received) to a data sink.
 type RawWriter struct {
 	conn     BatchPreparer
+	decoder  *Decoder
 	bucketer *Bucketer
+	dlq      *DeadLetterQueue
 }
 
-func NewRawWriter(conn BatchPreparer, bucketer *Bucketer) *RawWriter {
-	return &RawWriter{conn: conn, bucketer: bucketer}
+func NewRawWriter(conn BatchPreparer, decoder *Decoder, bucketer *Bucketer, dlq *DeadLetterQueue) *RawWriter {
+	return &RawWriter{conn: conn, decoder: decoder, bucketer: bucketer, dlq: dlq}
 }
 
 // WriteRawRecords decodes the messages and writes them to the data sink,
 // sending one batch of records per each record type. There is no guarantee that
-// all messages will be written atomically.
-func (w *RawWriter) WriteRawRecords(ctx context.Context, messages []kafka.Message) error {
+// all messages will be written atomically. Messages which cannot be decoded are
+// published to the dead-letter queue before the batches are written.
+func (w *RawWriter) WriteRawRecords(ctx context.Context, messages []kafka.Message) (err error) {
+	ctx, span := tracer().Start(
+		ctx,
+		"RawWriter.WriteRawRecords",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemClickhouse),
+		trace.WithAttributes(batchAttributes(messages)...),
+	)
+	defer func() { endSpan(span, err) }()
+
+	var deadLetters DeadLetters
+	records, err := w.decoder.DecodeMessages(ctx, messages, deadLetters.Add)
+	if err != nil {
+		return err
+	}
+
 	batches := make(map[string]driver.Batch)
 	for _, item := range []struct {
 		Stmt       string
@@ -298,7 +318,7 @@ func (w *RawWriter) WriteRawRecords(ctx context.Context, messages []kafka.Messag
 
 	loadedAt := time.Now().UTC()
 
-	for record := range DecodeMessages(SchemaNameHeader, messages) {
+	for message, record := range records {
 		var (
 			bucketTimestamp *time.Time
 			bucketGeohash   *string
@@ -333,6 +353,8 @@ func (w *RawWriter) WriteRawRecords(ctx context.Context, messages []kafka.Messag
 			batch, _ = batches[SchemaNameTrafficCrash]
 		default:
 			slog.Error("Message with unrecognized schema name", "schema_name, dropping message", record.SchemaName())
+			messagesDropped.WithLabelValues(dropReasonUnrecognizedSchema).Inc()
+			deadLetters.Add(message, dropReasonUnrecognizedSchema, ErrUnrecognizedSchema)
 			continue
 		}
 
@@ -341,17 +363,23 @@ func (w *RawWriter) WriteRawRecords(ctx context.Context, messages []kafka.Messag
 		}
 	}
 
+	// Dead letters are published first, as the batch is retried if either
+	// fails, and records which were written would be written again.
+	if err := w.dlq.Publish(ctx, deadLetters); err != nil {
+		return err
+	}
+
 	for _, batch := range batches {
 		if err := batch.Flush(); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
 // Write writes messages to the data sink.
-// NB: Malformed messages will be dropped.
+// NB: Malformed messages will be dropped, and published to the dead-letter
+// queue.
 func (w *RawWriter) Write(ctx context.Context, messages []kafka.Message) error {
 	if len(messages) == 0 {
 		return nil
@@ -94,11 +94,17 @@ func TestRawWriterWrite(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	writer := NewRawWriter(conn, NewBucketer(timePrecision, geohashPrecision))
+	mockW := new(mockMessageWriter)
+	mockW.On("WriteMessages", mock.Anything, mock.Anything).Return(nil)
+	dlq := NewDeadLetterQueue(mockW, time.Now)
+	writer := NewRawWriter(conn, NewDecoder(SchemaNameHeader, nil), NewBucketer(timePrecision, geohashPrecision, time.UTC), dlq)
 	err := writer.Write(ctx, messages)
 
 	assert.Nil(t, err)
-	conn.AssertCalled(t, "PrepareBatch", ctx, mock.Anything, mock.Anything, mock.Anything)
+	conn.AssertCalled(t, "PrepareBatch", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 	batch.AssertNumberOfCalls(t, "Append", 2)
 	batch.AssertCalled(t, "Flush")
+	mockW.AssertNumberOfCalls(t, "WriteMessages", 1)
+	published := mockW.Calls[0].Arguments.Get(1).([]kafka.Message)
+	assert.Len(t, published, 2)
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// ReplayReader reads dead letters from the dead-letter topic, so that they are
+// reprocessed. Each partition is replayed until a dead letter published after
+// the replay started is reached, as it may have been dead-lettered again by the
+// replay. Replay is complete, and ErrReplayComplete is returned, once every
+// partition has been replayed, or once a flush interval passes without any
+// dead letters.
+//
+// A single replay consumer is expected to read all of the topic's partitions,
+// as only then are they all replayed.
+type ReplayReader struct {
+	Readable
+	// Time the replay started.
+	Until time.Time
+	// Number of partitions of the dead-letter topic.
+	Partitions int
+
+	// Whether a dead letter has been fetched since the last fetch timed out.
+	fetched bool
+	// Partitions which have been replayed.
+	replayed map[int]bool
+}
+
+func NewReplayReader(reader Readable, until time.Time, partitions int) *ReplayReader {
+	if partitions <= 0 {
+		panic("Partitions must be positive")
+	}
+	return &ReplayReader{Readable: reader, Until: until, Partitions: partitions, replayed: make(map[int]bool)}
+}
+
+// FetchMessage fetches a dead letter, without the headers added by
+// dead-lettering, as if it had been fetched from the original topic.
+func (r *ReplayReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		msg, err := r.Readable.FetchMessage(ctx)
+		if errors.Is(err, context.DeadlineExceeded) {
+			if !r.fetched {
+				return msg, ErrReplayComplete
+			}
+			r.fetched = false
+			return msg, err
+		}
+		if err != nil {
+			return msg, err
+		}
+
+		// The dead letter, and those after it in the partition, are left
+		// uncommitted, for a later replay.
+		if !msg.Time.Before(r.Until) {
+			r.replayed[msg.Partition] = true
+			if len(r.replayed) >= r.Partitions {
+				return kafka.Message{}, ErrReplayComplete
+			}
+			continue
+		}
+		if r.replayed[msg.Partition] {
+			continue
+		}
+
+		r.fetched = true
+		msg.Headers = StripDeadLetterHeaders(msg.Headers)
+		return msg, nil
+	}
+}
+
+// ReadPartitionCount connects to the broker and reads the number of partitions
+// of the topic.
+func ReadPartitionCount(ctx context.Context, brokerURL, topic string) (int, error) {
+	conn, err := kafka.DialContext(ctx, "tcp", brokerURL)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	partitions, err := conn.ReadPartitions(topic)
+	if err != nil {
+		return 0, err
+	}
+	return len(partitions), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestReplayReaderFetchMessage(t *testing.T) {
+	until := time.Date(2025, 1, 1, 13, 14, 15, 0, time.UTC)
+	deadLetter := kafka.Message{
+		Value: []byte("abc"),
+		Headers: []kafka.Header{
+			{Key: SchemaNameHeader, Value: []byte(SchemaNameFireEMSCall)},
+			{Key: DeadLetterReasonHeader, Value: []byte(dropReasonDecodeFailure)},
+		},
+		Time: until.Add(-time.Second),
+	}
+
+	reader := new(mockReader)
+	reader.On("FetchMessage", mock.Anything).Return(deadLetter, nil)
+
+	replayReader := NewReplayReader(reader, until, 1)
+	actual, err := replayReader.FetchMessage(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, []kafka.Header{{Key: SchemaNameHeader, Value: []byte(SchemaNameFireEMSCall)}}, actual.Headers)
+	assert.Equal(t, deadLetter.Value, actual.Value)
+}
+
+func TestReplayReaderFetchMessageWhenPublishedAfterReplayStarted(t *testing.T) {
+	until := time.Date(2025, 1, 1, 13, 14, 15, 0, time.UTC)
+
+	reader := new(mockReader)
+	reader.On("FetchMessage", mock.Anything).Return(kafka.Message{Time: until}, nil)
+
+	replayReader := NewReplayReader(reader, until, 1)
+	_, err := replayReader.FetchMessage(context.Background())
+
+	assert.ErrorIs(t, err, ErrReplayComplete)
+}
+
+func TestReplayReaderFetchMessageWhenIdle(t *testing.T) {
+	until := time.Date(2025, 1, 1, 13, 14, 15, 0, time.UTC)
+
+	reader := new(mockReader)
+	reader.On("FetchMessage", mock.Anything).Return(kafka.Message{Time: until.Add(-time.Second)}, nil).Once()
+	reader.On("FetchMessage", mock.Anything).Return(kafka.Message{}, context.DeadlineExceeded)
+
+	replayReader := NewReplayReader(reader, until, 1)
+	ctx := context.Background()
+
+	_, err := replayReader.FetchMessage(ctx)
+	assert.Nil(t, err)
+	// The first timeout flushes the replayed dead letters.
+	_, err = replayReader.FetchMessage(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	// The replay is complete once a flush interval passes without any.
+	_, err = replayReader.FetchMessage(ctx)
+	assert.ErrorIs(t, err, ErrReplayComplete)
+}
+
+func TestReplayReaderFetchMessageWhenPartitionReplayed(t *testing.T) {
+	until := time.Date(2025, 1, 1, 13, 14, 15, 0, time.UTC)
+	deadLetters := []kafka.Message{
+		{Partition: 0, Time: until.Add(-time.Second), Value: []byte("a")},
+		{Partition: 0, Time: until},
+		{Partition: 1, Time: until.Add(-time.Second), Value: []byte("b")},
+		// Dead letters in a replayed partition are skipped, even if published
+		// before the replay started.
+		{Partition: 0, Time: until.Add(-time.Second), Value: []byte("c")},
+		{Partition: 1, Time: until.Add(time.Second)},
+	}
+
+	reader := new(mockReader)
+	for _, deadLetter := range deadLetters {
+		reader.On("FetchMessage", mock.Anything).Return(deadLetter, nil).Once()
+	}
+
+	replayReader := NewReplayReader(reader, until, 2)
+	ctx := context.Background()
+
+	actual, err := replayReader.FetchMessage(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), actual.Value)
+	// Replay continues while other partitions have yet to be replayed.
+	actual, err = replayReader.FetchMessage(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), actual.Value)
+	// The replay is complete once every partition has been replayed.
+	_, err = replayReader.FetchMessage(ctx)
+	assert.ErrorIs(t, err, ErrReplayComplete)
+	reader.AssertNumberOfCalls(t, "FetchMessage", len(deadLetters))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// IsRetryableStatus returns whether a request which failed with the status code
+// may succeed if retried.
+func IsRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// RetryWithBackoff calls `attempt` until it succeeds, fails without being
+// retryable, or has been retried `retries` times, backing off linearly between
+// attempts. If the context is done while backing off, its error is returned.
+func RetryWithBackoff(ctx context.Context, retries int, backoff time.Duration, attempt func(attemptNumber int) (retryable bool, err error)) error {
+	var err error
+	for attemptNumber := range retries + 1 {
+		var retryable bool
+		retryable, err = attempt(attemptNumber)
+		if !retryable {
+			return err
+		}
+
+		if attemptNumber < retries {
+			// TODO: Jitter.
+			failures := 1 + attemptNumber
+			select {
+			case <-time.After(time.Duration(failures) * backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return fmt.Errorf("%w, after %d retries", err, retries)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryableStatus(t *testing.T) {
+	assert.True(t, IsRetryableStatus(http.StatusTooManyRequests))
+	assert.True(t, IsRetryableStatus(http.StatusServiceUnavailable))
+	assert.False(t, IsRetryableStatus(http.StatusNotFound))
+	assert.False(t, IsRetryableStatus(http.StatusOK))
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	attempts := []int{}
+	err := RetryWithBackoff(context.Background(), 2, time.Millisecond, func(attemptNumber int) (bool, error) {
+		attempts = append(attempts, attemptNumber)
+		if attemptNumber == 0 {
+			return true, errors.New("retryable")
+		}
+		return false, nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, attempts)
+}
+
+func TestRetryWithBackoffWhenNotRetryable(t *testing.T) {
+	errFailed := errors.New("failed")
+	attempts := 0
+	err := RetryWithBackoff(context.Background(), 2, time.Millisecond, func(attemptNumber int) (bool, error) {
+		attempts++
+		return false, errFailed
+	})
+
+	assert.ErrorIs(t, err, errFailed)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetryWithBackoffWhenRetriesExhausted(t *testing.T) {
+	errFailed := errors.New("failed")
+	attempts := 0
+	err := RetryWithBackoff(context.Background(), 2, time.Millisecond, func(attemptNumber int) (bool, error) {
+		attempts++
+		return true, errFailed
+	})
+
+	assert.ErrorIs(t, err, errFailed)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestRetryWithBackoffWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	err := RetryWithBackoff(ctx, 2, time.Hour, func(attemptNumber int) (bool, error) {
+		attempts++
+		cancel()
+		return true, errors.New("retryable")
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, attempts)
+}
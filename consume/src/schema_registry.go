@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hamba/avro/v2"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Messages in the Confluent wire format are prefixed with a magic byte and the
+// big-endian ID of the writer schema, as registered with a schema registry.
+const (
+	wireFormatMagicByte  = 0
+	wireFormatHeaderSize = 5
+)
+
+// ParseWireFormat splits a message payload in the Confluent wire format into
+// the ID of the schema it was written with, and the Avro-encoded record.
+func ParseWireFormat(data []byte) (int, []byte, error) {
+	if len(data) < wireFormatHeaderSize || data[0] != wireFormatMagicByte {
+		return 0, nil, ErrInvalidWireFormat
+	}
+	schemaID := int(binary.BigEndian.Uint32(data[1:wireFormatHeaderSize]))
+	return schemaID, data[wireFormatHeaderSize:], nil
+}
+
+// SchemaRegistry provides a method for getting writer schemas by ID.
+type SchemaRegistry interface {
+	// GetSchema returns the schema registered with the given ID, or
+	// ErrSchemaNotFound if there is none.
+	GetSchema(context.Context, int) (avro.Schema, error)
+}
+
+// NewSchemaRegistry creates a registry client from its url. A `file` url is
+// read by a FileSchemaRegistry, and an empty url disables the registry.
+func NewSchemaRegistry(registryURL string, timeout time.Duration, retries int, backoff time.Duration) (SchemaRegistry, error) {
+	if registryURL == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(registryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "file":
+		return NewFileSchemaRegistry(u.Path), nil
+	case "http", "https":
+		return NewHttpSchemaRegistry(registryURL, timeout, retries, backoff), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSchemaRegistryURL, registryURL)
+	}
+}
+
+// ParseRegisteredSchema parses a writer schema. Each schema is parsed with its
+// own cache, so that names registered by different versions of a schema don't
+// collide with each other, or with the reader schemas.
+func ParseRegisteredSchema(schema string) (avro.Schema, error) {
+	parsed, err := avro.ParseWithCache(schema, "", &avro.SchemaCache{})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnrecognizedSchema, err)
+	}
+	return parsed, nil
+}
+
+// HttpSchemaRegistry is an HTTP client for a Confluent Schema Registry
+// compatible API.
+type HttpSchemaRegistry struct {
+	client  HttpRequestDoer
+	url     string
+	retries int
+	backoff time.Duration
+}
+
+func NewHttpSchemaRegistry(url string, timeout time.Duration, retries int, backoff time.Duration) *HttpSchemaRegistry {
+	client := &http.Client{Timeout: timeout}
+	return &HttpSchemaRegistry{client: client, url: strings.TrimSuffix(url, "/"), retries: retries, backoff: backoff}
+}
+
+func NewHttpSchemaRegistryFromHttpClient(httpClient HttpRequestDoer, url string, retries int, backoff time.Duration) *HttpSchemaRegistry {
+	return &HttpSchemaRegistry{client: httpClient, url: strings.TrimSuffix(url, "/"), retries: retries, backoff: backoff}
+}
+
+type registeredSchemaResponse struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType"`
+}
+
+// GetSchema gets the schema with the given ID from the registry. The request is
+// retried with linear backoff if the registry responds with a 429 or 5xx status
+// code.
+func (r *HttpSchemaRegistry) GetSchema(ctx context.Context, schemaID int) (_ avro.Schema, err error) {
+	requestURL := fmt.Sprintf("%s/schemas/ids/%d", r.url, schemaID)
+	ctx, span := tracer().Start(
+		ctx,
+		"HttpSchemaRegistry.GetSchema",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPRequestMethodKey.String(http.MethodGet), semconv.URLFull(requestURL)),
+	)
+	defer func() { endSpan(span, err) }()
+
+	var schema avro.Schema
+	err = RetryWithBackoff(ctx, r.retries, r.backoff, func(attemptNumber int) (bool, error) {
+		if attemptNumber > 0 {
+			span.SetAttributes(attribute.Int("http.request.resend_count", attemptNumber))
+		}
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+		if err != nil {
+			return false, err
+		}
+		request.Header.Add("Accept", "application/vnd.schemaregistry.v1+json")
+
+		response, err := r.client.Do(request)
+		if err != nil {
+			return false, err
+		}
+		defer response.Body.Close()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(response.StatusCode))
+
+		if response.StatusCode == http.StatusNotFound {
+			return false, ErrSchemaNotFound
+		}
+		if response.StatusCode != http.StatusOK {
+			return IsRetryableStatus(response.StatusCode), fmt.Errorf("HTTP error: %s", response.Status)
+		}
+
+		var body registeredSchemaResponse
+		if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+			return false, err
+		}
+		// The schema type is omitted for Avro schemas.
+		if body.SchemaType != "" && body.SchemaType != "AVRO" {
+			return false, fmt.Errorf("%w: %s", ErrUnsupportedSchemaType, body.SchemaType)
+		}
+		schema, err = ParseRegisteredSchema(body.Schema)
+		return false, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// FileSchemaRegistry is a stand-in for a schema registry, reading the schema
+// with each ID from `<id>.avsc` in a directory.
+type FileSchemaRegistry struct {
+	dir string
+}
+
+func NewFileSchemaRegistry(dir string) *FileSchemaRegistry {
+	return &FileSchemaRegistry{dir: dir}
+}
+
+// GetSchema reads the schema with the given ID from the directory.
+func (r *FileSchemaRegistry) GetSchema(ctx context.Context, schemaID int) (avro.Schema, error) {
+	data, err := os.ReadFile(filepath.Join(r.dir, strconv.Itoa(schemaID)+".avsc"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrSchemaNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseRegisteredSchema(string(data))
+}
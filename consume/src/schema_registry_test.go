@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWireFormat(t *testing.T) {
+	type testCase struct {
+		Data             []byte
+		ExpectedSchemaID int
+		ExpectedPayload  []byte
+		ExpectedError    error
+	}
+
+	testCases := []testCase{
+		{Data: []byte{0, 0, 0, 1, 2, 10, 11}, ExpectedSchemaID: 258, ExpectedPayload: []byte{10, 11}},
+		{Data: []byte{0, 0, 0, 0, 1}, ExpectedSchemaID: 1, ExpectedPayload: []byte{}},
+		// Unknown magic byte.
+		{Data: []byte{1, 0, 0, 0, 1, 10}, ExpectedError: ErrInvalidWireFormat},
+		// Too short to contain a schema ID.
+		{Data: []byte{0, 0, 0}, ExpectedError: ErrInvalidWireFormat},
+	}
+	for _, testCase := range testCases {
+		schemaID, payload, err := ParseWireFormat(testCase.Data)
+		if testCase.ExpectedError != nil {
+			assert.ErrorIs(t, err, testCase.ExpectedError)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, testCase.ExpectedSchemaID, schemaID)
+		assert.Equal(t, testCase.ExpectedPayload, payload)
+	}
+}
+
+func TestNewSchemaRegistry(t *testing.T) {
+	registry, err := NewSchemaRegistry("", time.Second, 0, time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, registry)
+
+	registry, err = NewSchemaRegistry("file:///schemas", time.Second, 0, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, NewFileSchemaRegistry("/schemas"), registry)
+
+	registry, err = NewSchemaRegistry("http://registry:8081/", time.Second, 0, time.Second)
+	assert.Nil(t, err)
+	assert.IsType(t, &HttpSchemaRegistry{}, registry)
+
+	_, err = NewSchemaRegistry("ftp://registry", time.Second, 0, time.Second)
+	assert.ErrorIs(t, err, ErrInvalidSchemaRegistryURL)
+}
+
+func TestHttpSchemaRegistryGetSchema(t *testing.T) {
+	var path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(`{"schema": "{\"type\": \"record\", \"name\": \"a311_case\", \"namespace\": \"raw.avro\", \"fields\": [{\"name\": \"service_request_id\", \"type\": \"int\"}]}"}`))
+	}))
+	defer ts.Close()
+
+	registry := NewHttpSchemaRegistry(ts.URL+"/", time.Second, 0, time.Second)
+	schema, err := registry.GetSchema(context.Background(), 7)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/schemas/ids/7", path)
+	assert.Equal(t, `{"name":"raw.avro.a311_case","type":"record","fields":[{"name":"service_request_id","type":"int"}]}`, schema.String())
+}
+
+func TestHttpSchemaRegistryGetSchemaRetries(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"schema": "\"string\""}`))
+	}))
+	defer ts.Close()
+
+	registry := NewHttpSchemaRegistry(ts.URL, time.Second, 1, time.Millisecond)
+	_, err := registry.GetSchema(context.Background(), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestHttpSchemaRegistryGetSchemaWhenFailing(t *testing.T) {
+	type testCase struct {
+		Status        int
+		Body          string
+		ExpectedError error
+	}
+
+	testCases := []testCase{
+		{Status: http.StatusNotFound, ExpectedError: ErrSchemaNotFound},
+		{Status: http.StatusOK, Body: `{"schema": "syntax = \"proto3\";", "schemaType": "PROTOBUF"}`, ExpectedError: ErrUnsupportedSchemaType},
+		{Status: http.StatusOK, Body: `{"schema": "{\"type\": \"unknown\"}"}`, ExpectedError: ErrUnrecognizedSchema},
+		{Status: http.StatusServiceUnavailable},
+	}
+	for _, testCase := range testCases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(testCase.Status)
+			w.Write([]byte(testCase.Body))
+		}))
+
+		registry := NewHttpSchemaRegistry(ts.URL, time.Second, 0, time.Millisecond)
+		_, err := registry.GetSchema(context.Background(), 1)
+		ts.Close()
+
+		assert.NotNil(t, err)
+		if testCase.ExpectedError != nil {
+			assert.ErrorIs(t, err, testCase.ExpectedError)
+		}
+	}
+}
+
+func TestFileSchemaRegistryGetSchema(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "1.avsc"), []byte(schemaA311Case.String()), 0o644)
+
+	registry := NewFileSchemaRegistry(dir)
+
+	schema, err := registry.GetSchema(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, schemaA311Case.Fingerprint(), schema.Fingerprint())
+
+	_, err = registry.GetSchema(context.Background(), 2)
+	assert.ErrorIs(t, err, ErrSchemaNotFound)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/dslaw/hotspots/consume"
+
+// Span exporters. The OTLP exporter is configured by the standard
+// OTEL_EXPORTER_OTLP_* environment variables.
+const (
+	TracesExporterNone   = "none"
+	TracesExporterStdout = "stdout"
+	TracesExporterFile   = "file"
+	TracesExporterOTLP   = "otlp"
+)
+
+// tracer returns the tracer of the global tracer provider, so that spans are
+// recorded by whichever provider is current.
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// InitTracing sets the global tracer provider, exporting spans with the given
+// exporter, and the global propagator. `path` is the file spans are written to
+// by the file exporter. The returned function flushes and stops exporting.
+func InitTracing(ctx context.Context, serviceName, exporter, path string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var (
+		spanExporter sdktrace.SpanExporter
+		err          error
+	)
+	switch exporter {
+	case TracesExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case TracesExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case TracesExporterFile:
+		var file *os.File
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		if err == nil {
+			spanExporter = closingExporter{SpanExporter: spanExporter, closer: file}
+		}
+	case TracesExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, ErrInvalidTracesExporter
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// closingExporter closes the file spans are written to on shutdown.
+type closingExporter struct {
+	sdktrace.SpanExporter
+	closer io.Closer
+}
+
+func (e closingExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(e.SpanExporter.Shutdown(ctx), e.closer.Close())
+}
+
+// endSpan records the error, if any, on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// KafkaHeaderCarrier adapts a message's headers for propagation of trace
+// context, which is carried alongside the schema name header.
+type KafkaHeaderCarrier []kafka.Header
+
+func (c *KafkaHeaderCarrier) Get(key string) string {
+	for _, header := range *c {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func (c *KafkaHeaderCarrier) Set(key, value string) {
+	for idx, header := range *c {
+		if header.Key == key {
+			(*c)[idx].Value = []byte(value)
+			return
+		}
+	}
+	*c = append(*c, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c *KafkaHeaderCarrier) Keys() []string {
+	keys := make([]string, len(*c))
+	for idx, header := range *c {
+		keys[idx] = header.Key
+	}
+	return keys
+}
+
+// MessageContext returns `ctx` with the trace context propagated by the
+// message's producer, if any.
+func MessageContext(ctx context.Context, msg kafka.Message) context.Context {
+	headers := KafkaHeaderCarrier(msg.Headers)
+	return otel.GetTextMapPropagator().Extract(ctx, &headers)
+}
+
+// messageLinks links to the producers' spans of the messages.
+func messageLinks(ctx context.Context, messages []kafka.Message) []trace.Link {
+	links := make([]trace.Link, 0, len(messages))
+	for _, msg := range messages {
+		spanContext := trace.SpanContextFromContext(MessageContext(ctx, msg))
+		if spanContext.IsValid() {
+			links = append(links, trace.Link{SpanContext: spanContext})
+		}
+	}
+	return links
+}
+
+// batchAttributes describes a batch of messages, for spans.
+func batchAttributes(messages []kafka.Message) []attribute.KeyValue {
+	return []attribute.KeyValue{semconv.MessagingBatchMessageCount(len(messages))}
+}
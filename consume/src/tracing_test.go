@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func setTestTracerProvider(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previousProvider := otel.GetTracerProvider()
+	previousPropagator := otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	return recorder
+}
+
+func TestKafkaHeaderCarrier(t *testing.T) {
+	carrier := KafkaHeaderCarrier{{Key: SchemaNameHeader, Value: []byte(SchemaNameFireEMSCall)}}
+
+	carrier.Set("traceparent", "a")
+	carrier.Set("traceparent", testTraceparent)
+
+	assert.Equal(t, testTraceparent, carrier.Get("traceparent"))
+	assert.Equal(t, SchemaNameFireEMSCall, carrier.Get(SchemaNameHeader))
+	assert.Equal(t, "", carrier.Get("tracestate"))
+	assert.Equal(t, []string{SchemaNameHeader, "traceparent"}, carrier.Keys())
+}
+
+func TestBufferedConsumerFetchContinuesTrace(t *testing.T) {
+	recorder := setTestTracerProvider(t)
+
+	msg := kafka.Message{Headers: []kafka.Header{{Key: "traceparent", Value: []byte(testTraceparent)}}}
+	reader := new(mockReader)
+	reader.On("FetchMessage", mock.Anything).Return(msg, nil)
+	consumer := NewBufferedConsumer(1, time.Second, time.Second, reader, nil)
+
+	err := consumer.Fetch(context.Background())
+	assert.Nil(t, err)
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	}
+}
+
+func TestBufferedConsumerFlushLinksMessages(t *testing.T) {
+	recorder := setTestTracerProvider(t)
+
+	messages := []kafka.Message{
+		{Headers: []kafka.Header{{Key: "traceparent", Value: []byte(testTraceparent)}}},
+		{},
+	}
+	reader := new(mockReader)
+	reader.On("CommitMessages", mock.Anything, mock.Anything).Return(nil)
+	writer := new(mockWriter)
+	writer.On("Write", mock.Anything, mock.Anything).Return(nil)
+	consumer := NewBufferedConsumer(2, time.Second, time.Second, reader, writer)
+	consumer.buffer = append(consumer.buffer, messages...)
+
+	_, err := consumer.Flush(context.Background())
+	assert.Nil(t, err)
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		links := spans[0].Links()
+		if assert.Len(t, links, 1) {
+			assert.Equal(t, "00f067aa0ba902b7", links[0].SpanContext.SpanID().String())
+		}
+	}
+}
+
+func TestAggregatesServiceClientDispatchPropagatesTrace(t *testing.T) {
+	recorder := setTestTracerProvider(t)
+
+	var traceparent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer ts.Close()
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	request, _ := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL, nil)
+
+	client := NewAggregatesServiceClient(ts.URL, Credentials{Method: AuthMethodAPIKey, Secret: "secret"}, time.Second, 0, time.Second)
+	err := client.Dispatch(request)
+	parent.End()
+	assert.Nil(t, err)
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 2) {
+		dispatch := spans[0]
+		assert.Equal(t, "AggregatesServiceClient.Dispatch", dispatch.Name())
+		assert.Equal(t, parent.SpanContext().SpanID(), dispatch.Parent().SpanID())
+		assert.Equal(t, "00-"+dispatch.SpanContext().TraceID().String()+"-"+dispatch.SpanContext().SpanID().String()+"-01", traceparent)
+	}
+}
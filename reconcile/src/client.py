@@ -1,5 +1,9 @@
 import datetime
-from typing import Any, Iterable
+import hashlib
+import hmac
+import time
+from dataclasses import dataclass
+from typing import Any, Generator, Iterable
 
 import httpx
 
@@ -9,20 +13,77 @@ def serialize_datetime(d: datetime.datetime) -> str:
 
 
 def to_aggregate_item(
-    occurred_at: datetime.datetime, geohash: str, count: int
+    occurred_at: datetime.datetime, geohash: str, incident_type: str, count: int
 ) -> dict[str, Any]:
     return {
         "occurred_at": serialize_datetime(occurred_at),
         "geohash": geohash,
+        "incident_type": incident_type,
         "count": count,
     }
 
 
+@dataclass
+class Credentials:
+    """Credentials for the aggregates service (i.e. `app`)."""
+
+    method: str
+    key_id: str
+    secret: str
+
+
+class AppAuth(httpx.Auth):
+    """Adds credentials to requests, either as a bearer token (`api_key`) or by
+    signing the request (`hmac`).
+
+    Signed requests carry an HMAC-SHA256 signature of the method, request URI,
+    Unix timestamp and hex encoded SHA-256 digest of the body, joined by
+    newlines.
+    """
+
+    requires_request_body = True
+
+    def __init__(self, credentials: Credentials) -> None:
+        if credentials.method not in ("api_key", "hmac"):
+            raise ValueError(f"Unknown auth method: {credentials.method}")
+        self.credentials = credentials
+
+    def auth_flow(
+        self, request: httpx.Request
+    ) -> Generator[httpx.Request, httpx.Response, None]:
+        if self.credentials.method == "api_key":
+            request.headers["Authorization"] = f"Bearer {self.credentials.secret}"
+            yield request
+            return
+
+        timestamp = str(int(time.time()))
+        signature_string = "\n".join(
+            [
+                request.method,
+                request.url.raw_path.decode("ascii"),
+                timestamp,
+                hashlib.sha256(request.content).hexdigest(),
+            ]
+        )
+        signature = hmac.new(
+            self.credentials.secret.encode(),
+            signature_string.encode(),
+            hashlib.sha256,
+        ).hexdigest()
+        request.headers["Authorization"] = f"HMAC {self.credentials.key_id}:{signature}"
+        request.headers["X-Auth-Timestamp"] = timestamp
+        yield request
+
+
 def write(
-    url: str, records: Iterable[Iterable[dict]], connect_retries: int = 3
+    url: str,
+    records: Iterable[Iterable[dict]],
+    credentials: Credentials,
+    connect_retries: int = 3,
 ) -> None:
     with httpx.Client(
-        transport=httpx.HTTPTransport(retries=connect_retries)
+        auth=AppAuth(credentials),
+        transport=httpx.HTTPTransport(retries=connect_retries),
     ) as http_client:
         for records_batch in records:
             response = http_client.put(url, json=records_batch)
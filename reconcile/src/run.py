@@ -6,7 +6,7 @@ from itertools import batched
 
 import clickhouse_connect as clickhouse
 
-from src.client import to_aggregate_item, write
+from src.client import Credentials, to_aggregate_item, write
 
 
 def read(src_client, start_time, end_time):
@@ -21,7 +21,7 @@ def read(src_client, start_time, end_time):
         ), processable_incidents as (
             -- Fully recompute counts for every affected bucket, so that all matching
             -- records in the target table can be purged and replaced.
-            select bucket_timestamp, bucket_geohash
+            select bucket_timestamp, bucket_geohash, incidents.incident_type
             from warehouse.incidents
             inner join new_incidents on
                 incidents.incident_type = new_incidents.incident_type
@@ -30,6 +30,7 @@ def read(src_client, start_time, end_time):
         select
             bucket_timestamp,
             bucket_geohash,
+            incident_type,
             count(*) as incident_count
         from processable_incidents
         where
@@ -37,7 +38,8 @@ def read(src_client, start_time, end_time):
             and processable_incidents.bucket_geohash is not null
         group by
             bucket_timestamp,
-            bucket_geohash
+            bucket_geohash,
+            incident_type
         """,
         {"start_timestamp": start_time, "end_timestamp": end_time},
     )
@@ -70,12 +72,17 @@ def main(start_time: datetime.datetime, end_time: datetime.datetime) -> None:
     src_url = os.environ["WAREHOUSE_URL"]
     dst_url = os.environ["APP_URL"]
     batch_size = int(os.environ["RECONCILE_BATCH_SIZE"])
+    credentials = Credentials(
+        method=os.environ["APP_AUTH_METHOD"],
+        key_id=os.environ["APP_KEY_ID"],
+        secret=os.environ["APP_KEY_SECRET"],
+    )
 
     with closing(clickhouse.get_client(dsn=src_url)) as src_client:
         with read(src_client, start_time, end_time) as stream:
             records = process(stream)
             records_batched = batched(records, batch_size)
-            write(dst_url, records_batched)
+            write(dst_url, records_batched, credentials)
 
     return
 